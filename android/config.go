@@ -2125,3 +2125,84 @@ func (c *config) OdmPropFiles(ctx PathContext) Paths {
 func (c *config) EnableUffdGc() string {
 	return String(c.productVariables.EnableUffdGc)
 }
+
+// ManifestAllowBackupDefault returns the value of android:allowBackup that is forced on apps that
+// don't set allow_backup, or nil if the value in the manifest should be trusted.
+func (c *config) ManifestAllowBackupDefault() *bool {
+	return c.productVariables.ManifestAllowBackupDefault
+}
+
+// ManifestAllowBackupAllowlist returns the names of the apps that may set allow_backup: true when
+// ManifestAllowBackupDefault is false.
+func (c *config) ManifestAllowBackupAllowlist() []string {
+	return c.productVariables.ManifestAllowBackupAllowlist
+}
+
+// ManifestDeprecatedPermissions returns "<deprecated permission>:<replacement>" entries for the
+// permissions that apps must not request in their merged manifests.
+func (c *config) ManifestDeprecatedPermissions() []string {
+	return c.productVariables.ManifestDeprecatedPermissions
+}
+
+// ManifestDeprecatedPermissionsWarnOnly returns true if requests for the permissions in
+// ManifestDeprecatedPermissions should only cause warnings instead of failing the build.
+func (c *config) ManifestDeprecatedPermissionsWarnOnly() bool {
+	return Bool(c.productVariables.ManifestDeprecatedPermissionsWarnOnly)
+}
+
+// ManifestSharedUserIdWarnTargetSdkVersion returns the targetSdkVersion at or above which apps
+// whose merged manifests declare android:sharedUserId cause a warning, or nil if the warning is
+// disabled.
+func (c *config) ManifestSharedUserIdWarnTargetSdkVersion() *int {
+	return c.productVariables.ManifestSharedUserIdWarnTargetSdkVersion
+}
+
+// ManifestRejectPreviewTargetSdkInUserBuilds returns true if user builds must fail when an app
+// targets a preview SDK, unless it is in ManifestPreviewTargetSdkAllowlist.
+func (c *config) ManifestRejectPreviewTargetSdkInUserBuilds() bool {
+	return Bool(c.productVariables.ManifestRejectPreviewTargetSdkInUserBuilds)
+}
+
+// ManifestPreviewTargetSdkAllowlist returns the names of the apps that may target a preview SDK in
+// user builds when ManifestRejectPreviewTargetSdkInUserBuilds is set.
+func (c *config) ManifestPreviewTargetSdkAllowlist() []string {
+	return c.productVariables.ManifestPreviewTargetSdkAllowlist
+}
+
+// ManifestStrictUsesLibraries returns true if the build must fail when a <uses-library> tag would
+// be added to the manifest of an app for a library in its class loader context that isn't built.
+func (c *config) ManifestStrictUsesLibraries() bool {
+	return Bool(c.productVariables.ManifestStrictUsesLibraries)
+}
+
+// ManifestCheckUsesLibrariesOrder returns true if the build must fail when the <uses-library> tags
+// added to the manifest of an app are not in the order that dexpreopt expects.
+func (c *config) ManifestCheckUsesLibrariesOrder() bool {
+	return Bool(c.productVariables.ManifestCheckUsesLibrariesOrder)
+}
+
+// ManifestRejectTestOnlyProductPackagesInUserBuilds returns true if user builds must fail when the
+// product installs a test-only app.
+func (c *config) ManifestRejectTestOnlyProductPackagesInUserBuilds() bool {
+	return Bool(c.productVariables.ManifestRejectTestOnlyProductPackagesInUserBuilds)
+}
+
+// ManifestUsesLibraryCertificates returns "<library>:<certificate>" entries for the shared
+// libraries that may only be used by apps signed with the given certificate, either the name of a
+// certificate in the default certificate directory or an android_app_certificate module name in
+// the form ":module".
+func (c *config) ManifestUsesLibraryCertificates() []string {
+	return c.productVariables.ManifestUsesLibraryCertificates
+}
+
+// ManifestProtectedBroadcasts returns the broadcast actions that only the system can send, which
+// receivers may handle without declaring android:exported.
+func (c *config) ManifestProtectedBroadcasts() []string {
+	return c.productVariables.ManifestProtectedBroadcasts
+}
+
+// ManifestEnabledFeatureFlags returns the product feature flags that are enabled, which the
+// manifest components that are gated behind a feature flag require.
+func (c *config) ManifestEnabledFeatureFlags() []string {
+	return c.productVariables.ManifestEnabledFeatureFlags
+}
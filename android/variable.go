@@ -539,6 +539,28 @@ type ProductVariables struct {
 	OdmPropFiles       []string `json:",omitempty"`
 
 	EnableUffdGc *string `json:",omitempty"`
+
+	ManifestAllowBackupDefault   *bool    `json:",omitempty"`
+	ManifestAllowBackupAllowlist []string `json:",omitempty"`
+
+	ManifestDeprecatedPermissions         []string `json:",omitempty"`
+	ManifestDeprecatedPermissionsWarnOnly *bool    `json:",omitempty"`
+
+	ManifestSharedUserIdWarnTargetSdkVersion *int `json:",omitempty"`
+
+	ManifestRejectPreviewTargetSdkInUserBuilds *bool    `json:",omitempty"`
+	ManifestPreviewTargetSdkAllowlist          []string `json:",omitempty"`
+
+	ManifestStrictUsesLibraries     *bool `json:",omitempty"`
+	ManifestCheckUsesLibrariesOrder *bool `json:",omitempty"`
+
+	ManifestRejectTestOnlyProductPackagesInUserBuilds *bool `json:",omitempty"`
+
+	ManifestUsesLibraryCertificates []string `json:",omitempty"`
+
+	ManifestProtectedBroadcasts []string `json:",omitempty"`
+
+	ManifestEnabledFeatureFlags []string `json:",omitempty"`
 }
 
 type PartitionQualifiedVariablesType struct {
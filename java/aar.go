@@ -90,6 +90,33 @@ type aaptProperties struct {
 	// do not include AndroidManifest from dependent libraries
 	Dont_merge_manifests *bool
 
+	// If true, mark the AndroidManifest.xml produced by manifest_fixer as final.  A final manifest
+	// already contains the values injected by the build system, e.g. the SDK versions, and keeps
+	// them if it is fixed again, e.g. after the module has been captured in a snapshot or prebuilt.
+	// The other fixes, including those applied to the merged manifest, are still applied.
+	Mark_manifest_final *bool
+
+	// If true, don't add android:compileSdkVersion and android:compileSdkVersionCodename to the
+	// manifest, so that it isn't pinned to the SDK the module was built against, e.g. when the
+	// module is captured in a prebuilt.
+	Omit_compile_sdk_version *bool
+
+	// If true, write numeric minSdkVersion, targetSdkVersion and compileSdkVersionCodename values to
+	// the manifest even when the module is built against a preview SDK, resolving codenames to
+	// their final or future API level instead of using the codename or the API fingerprint.  For
+	// manifests that are exported to consumers that can't parse codenames.
+	Numeric_sdk_versions *bool
+
+	// If true, remove all tools: attributes from the final manifest of the module, including the
+	// merged manifest of a library that is exported to Make.  The manifests of libraries that are
+	// merged into apps keep their tools: attributes so that they still guide the manifest merger.
+	Strip_all_tools_attributes *bool
+
+	// If true, rename the prefixes of the android and tools namespaces in the final manifest of the
+	// module to android: and tools:, e.g. when the source manifests use a: for the android
+	// namespace, so that the output can be compared with other manifests.
+	Canonicalize_manifest_namespaces *bool
+
 	// If use_resource_processor is set, use Bazel's resource processor instead of aapt2 to generate R.class files.
 	// The resource processor produces more optimal R.class files that only list resources in the package of the
 	// library that provided them, as opposed to aapt2 which produces R.java files for every package containing
@@ -120,6 +147,12 @@ type aapt struct {
 	rJar                               android.Path
 	extraAaptPackagesFile              android.Path
 	mergedManifestFile                 android.Path
+	unfixedMergedManifestFile          android.Path
+	manifestLibPackagesFile            android.Path
+	manifestMergeChangesFile           android.Path
+	manifestLibPermissionsFile         android.Path
+	manifestComponentInventoryFile     android.Path
+	manifestCompatReportFile           android.Path
 	noticeFile                         android.OptionalPath
 	assetPackage                       android.OptionalPath
 	isLibrary                          bool
@@ -357,6 +390,10 @@ type aaptBuildActionOptions struct {
 	extraLinkFlags                 []string
 	aconfigTextFiles               android.Paths
 	usesLibrary                    *usesLibrary
+	manifestProperties             *appManifestProperties
+	rroManifests                   android.Paths
+	manifestCheckParams            ManifestCheckParams
+	renamedPackageName             string
 }
 
 func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptions) {
@@ -371,7 +408,7 @@ func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptio
 	manifestFile := proptools.StringDefault(a.aaptProperties.Manifest, "AndroidManifest.xml")
 	manifestSrcPath := android.PathForModuleSrc(ctx, manifestFile)
 
-	manifestPath := ManifestFixer(ctx, manifestSrcPath, ManifestFixerParams{
+	manifestFixerParams := ManifestFixerParams{
 		SdkContext:                     opts.sdkContext,
 		ClassLoaderContexts:            opts.classLoaderContexts,
 		IsLibrary:                      a.isLibrary,
@@ -382,7 +419,17 @@ func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptio
 		HasNoCode:                      a.hasNoCode,
 		LoggingParent:                  a.LoggingParent,
 		EnforceDefaultTargetSdkVersion: opts.enforceDefaultTargetSdkVersion,
-	})
+		MarkFinal:                      Bool(a.aaptProperties.Mark_manifest_final),
+		OmitCompileSdkVersion:          Bool(a.aaptProperties.Omit_compile_sdk_version),
+		NumericSdkVersions:             Bool(a.aaptProperties.Numeric_sdk_versions),
+		StripAllToolsAttributes:        Bool(a.aaptProperties.Strip_all_tools_attributes),
+		CanonicalizeNamespaces:         Bool(a.aaptProperties.Canonicalize_manifest_namespaces),
+	}
+	if opts.manifestProperties != nil {
+		opts.manifestProperties.setManifestFixerParams(ctx, &manifestFixerParams)
+	}
+	manifestPath, fixerArgs := manifestFixerWithArgs(ctx, manifestSrcPath, manifestFixerParams)
+	var postMergeFixerArgs []string
 
 	staticDeps := transitiveAarDeps(staticResourcesNodesDepSet.ToList())
 	sharedDeps := transitiveAarDeps(sharedResourcesNodesDepSet.ToList())
@@ -391,14 +438,35 @@ func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptio
 	additionalManifests := android.PathsForModuleSrc(ctx, a.aaptProperties.Additional_manifests)
 	transitiveManifestPaths := append(android.Paths{manifestPath}, additionalManifests...)
 	transitiveManifestPaths = append(transitiveManifestPaths, staticManifestsDepSet.ToList()...)
+	transitiveManifestPaths = append(transitiveManifestPaths, opts.rroManifests...)
 
+	packageName := opts.renamedPackageName
 	if len(transitiveManifestPaths) > 1 && !Bool(a.aaptProperties.Dont_merge_manifests) {
+		if packageName == "" {
+			packageName = a.manifestValues.applicationId
+		}
 		manifestMergerParams := ManifestMergerParams{
 			staticLibManifests: transitiveManifestPaths[1:],
 			isLibrary:          a.isLibrary,
 			packageName:        a.manifestValues.applicationId,
 		}
+		if opts.manifestProperties != nil {
+			manifestMergerParams.checkLibPackages = Bool(opts.manifestProperties.Enforce_static_lib_manifest_packages)
+			manifestMergerParams.allowedLibPackages = opts.manifestProperties.Allowed_static_lib_manifest_packages
+			manifestMergerParams.checkDuplicateComponents = Bool(opts.manifestProperties.Check_duplicate_manifest_components)
+		}
 		a.mergedManifestFile = manifestMerger(ctx, transitiveManifestPaths[0], manifestMergerParams)
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_static_lib_manifest_packages) {
+			a.manifestLibPackagesFile = manifestLibPackages(ctx, transitiveManifestPaths[0], manifestMergerParams)
+		}
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_manifest_merge_changes) {
+			a.manifestMergeChangesFile = manifestMergeChanges(ctx, transitiveManifestPaths[0], a.mergedManifestFile)
+		}
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_manifest_lib_permissions) {
+			a.manifestLibPermissionsFile = manifestLibPermissions(ctx, transitiveManifestPaths[0],
+				a.mergedManifestFile, manifestMergerParams.staticLibManifests)
+		}
+		a.unfixedMergedManifestFile = a.mergedManifestFile
 		if !a.isLibrary {
 			// Only use the merged manifest for applications.  For libraries, the transitive closure of manifests
 			// will be propagated to the final application and merged there.  The merged manifest for libraries is
@@ -407,14 +475,86 @@ func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptio
 		}
 	} else {
 		a.mergedManifestFile = manifestPath
+		a.unfixedMergedManifestFile = manifestSrcPath
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_static_lib_manifest_packages) {
+			// Nothing was merged, so no static library contributes a package.
+			libPackages := android.PathForModuleOut(ctx, "manifest_merger", "lib_packages.txt")
+			android.WriteFileRule(ctx, libPackages, "")
+			a.manifestLibPackagesFile = libPackages
+		}
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_manifest_merge_changes) {
+			// Nothing was merged, so nothing was changed by the merge.
+			changes := android.PathForModuleOut(ctx, "manifest_merger", "merge_changes.json")
+			android.WriteFileRule(ctx, changes, "[]")
+			a.manifestMergeChangesFile = changes
+		}
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_manifest_lib_permissions) {
+			// Nothing was merged, so no static library contributes a permission and the copy is
+			// the manifest as is.
+			a.manifestLibPermissionsFile = manifestLibPermissions(ctx, manifestPath, manifestPath, nil)
+		}
 	}
 
+	if !a.isLibrary {
+		// Fixes that affect entries contributed by static libraries can only be applied to the
+		// merged manifest of an app.
+		manifestPath, postMergeFixerArgs = manifestPostMergeFixer(ctx, manifestPath, manifestFixerParams)
+		if opts.manifestProperties != nil {
+			opts.manifestProperties.setManifestCheckParams(ctx, &opts.manifestCheckParams)
+			if Bool(opts.manifestProperties.Check_merged_target_sdk_version) && opts.sdkContext != nil {
+				opts.manifestCheckParams.TargetSdkVersion = targetSdkVersionForManifestFixer(ctx, manifestFixerParams)
+			}
+			if Bool(opts.manifestProperties.Check_merged_min_sdk_version) && opts.sdkContext != nil {
+				opts.manifestCheckParams.MinSdkVersion = minSdkVersionForManifestFixer(ctx, manifestFixerParams)
+			}
+		}
+		manifestPath = manifestPostMergeCheck(ctx, manifestPath, opts.manifestCheckParams)
+		a.mergedManifestFile = manifestPath
+	} else if manifestFixerParams.StripAllToolsAttributes || manifestFixerParams.CanonicalizeNamespaces {
+		a.mergedManifestFile, postMergeFixerArgs = manifestPostMergeFixer(ctx, a.mergedManifestFile, manifestFixerParams)
+	}
+
+	manifestMetadataInfo := manifestMetadata(ctx, a.mergedManifestFile, manifestFixerParams)
+	manifestMetadataInfo.FixerArgs = fixerArgs
+	manifestMetadataInfo.PostMergeFixerArgs = postMergeFixerArgs
+	manifestMetadataInfo.PackageName = packageName
+	if opts.manifestProperties != nil {
+		manifestMetadataInfo.SplitOf = String(opts.manifestProperties.Feature_split_of)
+	}
+	if !a.isLibrary {
+		manifestMetadataInfo.ApplicationClass = manifestApplicationClass(ctx, a.mergedManifestFile)
+		if opts.manifestProperties != nil && Bool(opts.manifestProperties.Emit_manifest_component_inventory) {
+			a.manifestComponentInventoryFile = manifestComponentInventory(ctx, a.mergedManifestFile)
+		}
+		if opts.manifestProperties != nil && opts.manifestProperties.Baseline_manifest != nil {
+			baseline := android.PathForModuleSrc(ctx, *opts.manifestProperties.Baseline_manifest)
+			a.manifestCompatReportFile = manifestCompatReport(ctx, a.mergedManifestFile, baseline)
+		}
+	}
+	if manifestWarningsEnabled(ctx.Config()) {
+		manifestMetadataInfo.WarningsFile = manifestWarningsFile(ctx, postMergeFixerArgs != nil)
+	}
+	android.SetProvider(ctx, ManifestMetadataInfoProvider, manifestMetadataInfo)
+
 	compileFlags, linkFlags, linkDeps, resDirs, overlayDirs, rroDirs, resZips := a.aapt2Flags(ctx, opts.sdkContext, manifestPath)
 
 	linkFlags = append(linkFlags, libFlags...)
 	linkDeps = append(linkDeps, sharedExportPackages...)
 	linkDeps = append(linkDeps, staticDeps.resPackages()...)
 	linkFlags = append(linkFlags, opts.extraLinkFlags...)
+	if manifestFixerParams.OmitCompileSdkVersion {
+		// aapt2 adds the compile SDK attributes unless told not to.
+		linkFlags = append(linkFlags, "--no-compile-sdk-metadata")
+	} else if manifestFixerParams.NumericSdkVersions && opts.sdkContext != nil {
+		// aapt2 uses the codename of a preview SDK as compileSdkVersionCodename.
+		compileSdkVersion, err := opts.sdkContext.SdkVersion(ctx).EffectiveVersion(ctx)
+		if err != nil {
+			ctx.ModuleErrorf("invalid sdk_version: %s", err)
+		} else if compileSdkVersion.IsPreview() {
+			linkFlags = append(linkFlags,
+				"--compile-sdk-version-name "+strconv.Itoa(compileSdkVersion.FinalOrFutureInt()))
+		}
+	}
 	if a.isLibrary {
 		linkFlags = append(linkFlags, "--static-lib")
 	}
@@ -449,6 +589,22 @@ func (a *aapt) buildActions(ctx android.ModuleContext, opts aaptBuildActionOptio
 		compiledResDirs = append(compiledResDirs, android.Paths{flata})
 	}
 
+	if len(manifestFixerParams.KnownActivityEmbeddingCerts) > 1 {
+		// The manifest refers to a generated string array holding the digests.
+		certsDir, certsFile := knownActivityEmbeddingCertsResource(ctx, manifestFixerParams.KnownActivityEmbeddingCerts)
+		compiledResDirs = append(compiledResDirs,
+			aapt2Compile(ctx, certsDir, android.Paths{certsFile}, compileFlags, "").Paths())
+		linkFlags = append(linkFlags, "--auto-add-overlay")
+	}
+
+	if manifestFixerParams.GenerateLocaleConfig {
+		// The manifest refers to a generated locale-config listing the locales of the resources.
+		localeConfigDir, localeConfigFile := localeConfigResourceFile(ctx, a.resourceFiles)
+		compiledResDirs = append(compiledResDirs,
+			aapt2Compile(ctx, localeConfigDir, android.Paths{localeConfigFile}, compileFlags, "").Paths())
+		linkFlags = append(linkFlags, "--auto-add-overlay")
+	}
+
 	var compiledRes, compiledOverlay android.Paths
 
 	// AAPT2 overlays are in lowest to highest priority order, reverse the topological order
@@ -16,10 +16,15 @@ package java
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 	"android/soong/dexpreopt"
@@ -58,6 +63,29 @@ func targetSdkVersionForManifestFixer(ctx android.ModuleContext, params Manifest
 	return targetSdkVersion
 }
 
+// minSdkVersionForManifestFixer returns the minSdkVersion that manifest_fixer.py raises the
+// minSdkVersion of the manifest to.
+func minSdkVersionForManifestFixer(ctx android.ModuleContext, params ManifestFixerParams) string {
+	if params.NumericSdkVersions {
+		return numericSdkVersionForManifestFixer(ctx, "minSdkVersion", params.SdkContext.MinSdkVersion(ctx))
+	}
+	minSdkVersion, err := params.SdkContext.MinSdkVersion(ctx).EffectiveVersionString(ctx)
+	if err != nil {
+		ctx.ModuleErrorf("invalid minSdkVersion: %s", err)
+	}
+	return minSdkVersion
+}
+
+// numericSdkVersionForManifestFixer returns the effective API level as a number, resolving the
+// codename of a preview SDK to its final or future int.
+func numericSdkVersionForManifestFixer(ctx android.ModuleContext, attr string, level android.ApiLevel) string {
+	effective, err := level.EffectiveVersion(ctx)
+	if err != nil {
+		ctx.ModuleErrorf("invalid %s: %s", attr, err)
+	}
+	return strconv.Itoa(effective.FinalOrFutureInt())
+}
+
 // Return true for modules targeting "current" if either
 // 1. The module is built in unbundled mode (TARGET_BUILD_APPS not empty)
 // 2. The module is run as part of MTS, and should be testable on stable branches
@@ -80,23 +108,1601 @@ func includedInMts(module android.Module) bool {
 	return false
 }
 
+// Properties of android_app and related modules that control how their AndroidManifest.xml is
+// fixed up by manifest_fixer.
+type appManifestProperties struct {
+	// Names of <meta-data> entries to remove from <application> in the final manifest.  The entries
+	// are removed after the manifests of static libraries have been merged, so entries contributed
+	// by a static library can be removed as well.  Names that are not present are ignored.
+	Remove_meta_data []string
+
+	// If true, add a <meta-data android:name="android.testSuites"> entry to <application> of test
+	// apps whose value is the comma-separated list of the test suites the module is included in, so
+	// that on-device harnesses can find them.  Ignored for modules that aren't tests.
+	Test_suites_meta_data *bool
+
+	// If set, forces android:allowBackup on <application> to the given value, overriding the value
+	// in the manifest.  Defaults to the product's ManifestAllowBackupDefault.  When the product
+	// default is false, only apps in the product's ManifestAllowBackupAllowlist may set this to
+	// true.
+	Allow_backup *bool
+
+	// If set, forces android:gwpAsanMode on <application> to the given value, overriding the value
+	// in the manifest.  Must be one of "always", "never" or "default".
+	Gwp_asan_mode *string
+
+	// If set, sets android:backupAgent on <application> to the given class name, overriding the
+	// value in the manifest.  May be fully qualified or relative to the package, e.g. ".Backup".
+	Backup_agent *string
+
+	// If set, sets android:zygotePreloadName on <application> to the given class name, overriding
+	// the value in the manifest.  The class preloads code in the app zygote that is used to spawn
+	// the app's isolated processes.
+	Zygote_preload_name *string
+
+	// If set, sets android:appComponentFactory on <application> to the given class name, overriding
+	// the value in the manifest.  The factory instantiates the app's components, e.g. to wrap
+	// them for instrumentation.
+	App_component_factory *string
+
+	// list of runtime_resource_overlay modules whose manifests are merged into the app's manifest
+	// along with the manifests of static libraries.  Overlays that set exclude_from_manifest_merge
+	// are skipped.  The <overlay> tag and the package are removed from an overlay's manifest before
+	// it is merged, and relative class names in it are resolved against the overlay's own package.
+	Rro_manifests []string
+
+	// list of split types that must be installed along with the app, set as
+	// android:requiredSplitTypes on <manifest>.
+	Required_split_types []string
+
+	// list of split types provided by the app, set as android:splitTypes on <manifest>.
+	Split_types []string
+
+	// name of the app whose base APK is split into this app, e.g. for a feature split.  The build
+	// fails if the minSdkVersion, targetSdkVersion or SDK version of the splits of an app differ
+	// from each other or from those of the app.
+	Feature_split_of *string
+
+	// If true, fail the build if a static library manifest merged into the app's manifest declares
+	// a package other than the app's.
+	Enforce_static_lib_manifest_packages *bool
+
+	// list of packages that static library manifests may declare when
+	// enforce_static_lib_manifest_packages is set.
+	Allowed_static_lib_manifest_packages []string
+
+	// If true, write the packages declared by the static library manifests merged into the app's
+	// manifest to a file as aapt2 --extra-packages flags.  The file is available through the
+	// ".manifest_lib_packages" output tag.
+	Emit_static_lib_manifest_packages *bool
+
+	// If true, write a JSON list of the nodes and attributes that the manifest merger added to or
+	// overrode in the app's manifest to a file.  The file is available through the
+	// ".manifest_merge_changes.json" output tag.
+	Emit_manifest_merge_changes *bool
+
+	// If true, write a copy of the app's merged manifest in which the <uses-permission> tags merged
+	// from static libraries are moved into a section delimited by comments, each following a
+	// comment naming the static library manifests that request it, for tools that only read the
+	// app's own manifest.  The manifest built into the app is not affected.  The file is available
+	// through the ".manifest_lib_permissions.xml" output tag.
+	Emit_manifest_lib_permissions *bool
+
+	// If true, write a JSON list of the activities, services, receivers and providers of the final
+	// manifest of the app, including those merged from static libraries, with their exported
+	// status to a file.  The file is available through the ".component_inventory.json" output tag.
+	Emit_manifest_component_inventory *bool
+
+	// If true, fail the build if a component is declared more than once by the app's manifest and
+	// the static library manifests merged into it.  Declarations with tools: attributes modify a
+	// component declared elsewhere and don't count.
+	Check_duplicate_manifest_components *bool
+
+	// If true, fail the build if an android:icon or android:roundIcon in the final manifest refers
+	// to a resource of the app that isn't in its compiled resource table, so that launchers don't
+	// silently fall back to another icon.
+	Check_icon_resources *bool
+
+	// package that runtime_resource_overlay modules overlaying the app target.  If set, fail the
+	// build unless the app's resources declare an <overlayable> and the final package of the app is
+	// the given package.
+	Overlayable_package *string
+
+	// If true, fail the build if the app requires a <uses-library> that the product's
+	// ManifestUsesLibraryCertificates restricts to apps signed with a different certificate.
+	Enforce_uses_library_certificates *bool
+
+	// If true, fail the build if a module in uses_libs or optional_uses_libs has a manifest that is
+	// built as the manifest of an app rather than of a library, as the platform only loads a
+	// <uses-library> declared by a library.
+	Check_uses_library_modules *bool
+
+	// If set, sets android:theme on <application> to the given @style/... reference if the manifest
+	// does not declare a theme.
+	Default_theme *string
+
+	// If true, default_theme overrides the android:theme declared in the manifest.
+	Override_theme *bool
+
+	// list of "<activity class name>:<@style/... reference>" entries that force android:theme on
+	// individual activities, including activities merged from static libraries.  The build fails
+	// if an activity is not declared.
+	Theme_activities []string
+
+	// list of "<activity class name>:<attribute>=<value>" entries that force window attributes on
+	// individual activities, including activities merged from static libraries.  Only colorMode,
+	// rotationAnimation, windowLayoutInDisplayCutoutMode and windowSoftInputMode can be set, and
+	// their values are validated.  The build fails if an activity is not declared.
+	Window_attributes_activities []string
+
+	// If set, sets android:fullBackupContent on <application> to the given @xml/... reference,
+	// overriding the value in the manifest.  The rules are only used by Android 11 and lower; an
+	// android:dataExtractionRules attribute in the manifest is left as is and is used by later
+	// versions, so both may be set.
+	Full_backup_content *string
+
+	// If set, adds an android.content.APP_RESTRICTIONS <meta-data> tag to <application> referring
+	// to the given @xml/... resource, which declares the restrictions that a device policy
+	// controller can set for the app.  A tag already declared in the manifest is left as is.
+	App_restrictions *string
+
+	// If set, forces android:restoreAnyVersion on <application> to the given value, overriding the
+	// value in the manifest.  Apps that must accept backups made by newer versions of themselves
+	// set this to true.
+	Restore_any_version *bool
+
+	// If set, forces android:crossProfile on <application> to the given value, overriding the
+	// value in the manifest.
+	Cross_profile *bool
+
+	// If set, forces android:allowNativeHeapPointerTagging on <application> to the given value,
+	// overriding the value in the manifest.  Apps that are incompatible with tagged heap pointers
+	// set this to false.
+	Allow_native_heap_pointer_tagging *bool
+
+	// If set, forces android:largeHeap on <application> to the given value, overriding the value in
+	// the manifest.
+	Large_heap *bool
+
+	// If set, forces android:resizeableActivity on <application> to the given value, overriding the
+	// value in the manifest.  The attribute has no effect on apps targeting SDK versions older than
+	// 24, and a warning is printed for them.
+	Resizeable_activity *bool
+
+	// list of "<activity class name>:<true|false>" entries that force android:resizeableActivity on
+	// individual activities, including activities merged from static libraries.
+	Resizeable_activities []string
+
+	// If set, forces android:requestRawExternalStorageAccess on <application> to the given value,
+	// overriding the value in the manifest.  The attribute has no effect on apps targeting SDK
+	// versions older than 30, and a warning is printed for them.
+	Request_raw_external_storage_access *bool
+
+	// If set, forces android:preserveLegacyExternalStorage on <application> to the given value,
+	// overriding the value in the manifest, so that an app upgraded to target scoped storage keeps
+	// the legacy storage view until it is reinstalled.  The attribute has no effect on apps
+	// targeting SDK versions older than 30, and a warning is printed for them.
+	Preserve_legacy_external_storage *bool
+
+	// If set, forces android:extractNativeLibs on <application> to the given value instead of the
+	// value derived from min_sdk_version and use_embedded_native_libs.  Native libraries can't be
+	// kept uncompressed in the APK for a min_sdk_version lower than 23, so false is rejected then.
+	Extract_native_libs *bool
+
+	// list of SHA-256 digests of the signing certificates of hosts that are trusted to embed the
+	// app's activities, set as android:knownActivityEmbeddingCerts on <application>.  When more
+	// than one digest is listed they are added to the app's resources as a string array.
+	Known_activity_embedding_certs []string
+
+	// If true, generates an xml/soong_locale_config.xml resource listing the locales of the
+	// module's values-<locale> resource directories and sets android:localeConfig on
+	// <application> to refer to it.
+	Generate_locale_config *bool
+
+	// If set, forces android:hardwareAccelerated on <application> to the given value, overriding
+	// the value in the manifest.
+	Hardware_accelerated *bool
+
+	// list of "<activity class name>:<true|false>" entries that force android:hardwareAccelerated
+	// on individual activities, including activities merged from static libraries.
+	Hardware_accelerated_activities []string
+
+	// If set, forces android:directBootAware on <application> to the given value, overriding the
+	// value in the manifest.
+	Direct_boot_aware *bool
+
+	// If set, forces android:allowClearUserData on <application> to the given value, overriding
+	// the value in the manifest.  Only honored by the platform for system apps.
+	Allow_clear_user_data *bool
+
+	// If set, forces android:persistent on <application> to the given value, overriding the value
+	// in the manifest.  Only privileged apps may set it to true.
+	Persistent *bool
+
+	// If set, forces android:multiArch on <application> to the given value, overriding the value
+	// in the manifest.  Apps with multiArch set to true get the native libraries of all the ABIs
+	// they support extracted, rather than only those of the primary ABI.
+	Multi_arch *bool
+
+	// If set, forces android:killAfterRestore on <application> to the given value, overriding the
+	// value in the manifest.  Apps with killAfterRestore set to false keep running after a full
+	// system restore of their data.
+	Kill_after_restore *bool
+
+	// list of "<component class name>:<true|false>" entries that force android:directBootAware on
+	// individual activities, services, receivers and providers, including components merged from
+	// static libraries.  The build fails if a component is not declared.
+	Direct_boot_aware_components []string
+
+	// list of "<component class name>:<@string/... reference>" entries that force android:label on
+	// individual activities, services, receivers and providers, including components merged from
+	// static libraries.  The build fails if a component is not declared.
+	Label_components []string
+
+	// list of "<component class name>:<@string/... reference>" entries that force
+	// android:description on individual activities, services, receivers and providers, including
+	// components merged from static libraries.  The build fails if a component is not declared.
+	Description_components []string
+
+	// If set, forces android:uiOptions on <application> to the given value, overriding the value
+	// in the manifest.  Must be "none" or "splitActionBarWhenNarrow".
+	Ui_options *string
+
+	// list of "<activity class name>:<ui options>" entries that force android:uiOptions on
+	// individual activities, including activities merged from static libraries.
+	Ui_options_activities []string
+
+	// list of "<activity class name>:<true|false>" entries that force
+	// android:enableOnBackInvokedCallback on individual activities, including activities merged
+	// from static libraries.  The build fails if an activity is not declared.
+	On_back_invoked_callback_activities []string
+
+	// list of "<permission>:<flags>" entries that set android:usesPermissionFlags on the
+	// <uses-permission> tags requesting the permission in the merged manifest, e.g.
+	// "android.permission.BLUETOOTH_SCAN:neverForLocation".  It is an error if the merged manifest
+	// doesn't request the permission, unless add_missing_uses_permissions is set.
+	Uses_permission_flags []string
+
+	// If true, permissions in uses_permission_flags that the merged manifest doesn't request are
+	// added to it as <uses-permission> tags.
+	Add_missing_uses_permissions *bool
+
+	// list of permissions whose <uses-permission> tags in the merged manifest get
+	// android:maxSdkVersion and android:usesPermissionFlags set together.  It is an error if the
+	// merged manifest doesn't request a permission.
+	Uses_permission_modifications []usesPermissionModificationProperties
+
+	// list of permissions whose <uses-permission> tags in the merged manifest get
+	// android:usesPermissionFlags="neverForLocation", e.g. "android.permission.BLUETOOTH_SCAN".
+	// It is an error if the merged manifest doesn't request a permission.
+	Never_for_location_permissions []string
+
+	// If set, sets android:versionCode on <manifest> to the given number, overriding the value in
+	// the manifest.  Cannot be used with version_code_file.
+	Version_code *string
+
+	// If set, sets android:versionCode on <manifest> to the contents of the given file when the
+	// manifest is fixed, for version codes that are only known at build time.  The build fails if
+	// the file doesn't hold a number.
+	Version_code_file *string `android:"path"`
+
+	// If set, sets android:versionName on <manifest> to the given value, overriding the value in
+	// the manifest.
+	Version_name *string
+
+	// If set, sets android:installLocation on <manifest> to the given value, overriding the value
+	// in the manifest.  Must be one of "auto", "internalOnly" or "preferExternal".
+	Install_location *string
+
+	// If set, forces android:isSplitRequired on <manifest> to the given value, overriding the value
+	// in the manifest.  Base APKs that can't be installed without their splits set it to true.
+	Is_split_required *bool
+
+	// If set, sets android:sharedUserMaxSdkVersion on <manifest>, so that the android:sharedUserId
+	// declared by the manifest only applies to devices running the given API level or older.  The
+	// manifest must declare android:sharedUserId.
+	Shared_user_max_sdk_version *string
+
+	// If set, sets android:sharedUserLabel on <manifest> to the given @string/... reference, so that
+	// the apps sharing a user id show a consistent label.  The manifest must declare
+	// android:sharedUserId.
+	Shared_user_label *string
+
+	// list of "<attribute>:<value>" entries that set android: attributes of <application>, e.g.
+	// "banner:@drawable/banner", overriding the values in the manifest.  Attributes that are
+	// managed by the build system or by other properties can't be set.
+	Application_attributes []string
+
+	// list of <property> tags to add to <application>, replacing tags with the same name in the
+	// manifest.
+	Application_properties []applicationPropertyProperties
+
+	// If set, forces android:attributionsAreUserVisible on <application> to the given value,
+	// overriding the value in the manifest.
+	Attributions_are_user_visible *bool
+
+	// list of <attribution> tags to add to <manifest>, replacing tags with the same tag in the
+	// manifest.
+	Attributions []attributionProperties
+
+	// list of <screen> tags to add to the <compatible-screens> tag of the manifest, restricting the
+	// app to the given screen configurations.  Used by legacy compatibility variants.
+	Compatible_screens []compatibleScreenProperties
+
+	// list of SDK libraries to add <uses-sdk-library> tags for to <application>, replacing tags
+	// with the same name in the manifest.
+	Uses_sdk_libraries []usesSdkLibraryProperties
+
+	// Controls launcher activities in the merged manifest, i.e. activities with an intent filter
+	// in the android.intent.category.LAUNCHER category, that don't declare android:exported.
+	// "fix" sets android:exported="true" on them, and "validate" fails the build instead.
+	Launcher_activities_exported *string
+
+	// Controls receivers in the merged manifest with an intent filter for a broadcast that isn't in
+	// the product's ManifestProtectedBroadcasts, and so can be sent by other apps, that don't
+	// declare android:exported.  "fix" sets android:exported="true" on them, and "validate" fails
+	// the build instead.
+	Broadcast_receivers_exported *string
+
+	// If set, sets android:taskAffinity="" on activities in the merged manifest, so that they
+	// can't be moved into the task of another app.  "exported" only clears it on exported
+	// activities and "all" on every activity.
+	Clear_task_affinity *string
+
+	// list of providers to set android:grantUriPermissions="true" on and to add
+	// <grant-uri-permission> tags to, including providers merged from static libraries.  The build
+	// fails if a provider is not declared.
+	Provider_uri_permission_grants []providerUriPermissionGrantProperties
+
+	// list of class names of components to set android:enabled="false" on, including components
+	// merged from static libraries.  The build fails if a component is not declared.
+	Disabled_components []string
+
+	// If true, fail the build if an exported component in the merged manifest is guarded by a
+	// permission that the manifest declares with a protection level weaker than signature.
+	Enforce_signature_protected_components *bool
+
+	// If true, fail the build if a <provider> in the merged manifest doesn't declare a non-empty
+	// android:authorities.
+	Check_provider_authorities *bool
+
+	// If true, fail the build if a FileProvider in the merged manifest, i.e. a <provider> whose
+	// class name ends with FileProvider, declares android:grantUriPermissions="true" but no
+	// android.support.FILE_PROVIDER_PATHS <meta-data> tag pointing at its paths.
+	Check_file_provider_paths *bool
+
+	// If true, fail the build if the merged manifest contains a component gated behind a feature
+	// flag that the product's ManifestEnabledFeatureFlags doesn't enable.  A component is gated
+	// behind a flag by an android.soong.FEATURE_FLAG <meta-data> tag naming the flag.
+	Check_component_feature_flags *bool
+
+	// If true, fail the build if an exported <service> in the merged manifest isn't guarded by an
+	// android:permission, either its own or the one of <application>, unless it is listed in
+	// open_exported_services.
+	Check_exported_service_permissions *bool
+
+	// list of class names of exported services that are intentionally not guarded by a permission
+	// and are allowed by check_exported_service_permissions.
+	Open_exported_services []string
+
+	// If true, fail the build if an exported <provider> in the merged manifest isn't guarded for
+	// both reading and writing, either by an android:permission, its own or the one of
+	// <application>, or by both android:readPermission and android:writePermission, unless it is
+	// listed in open_exported_providers.
+	Check_exported_provider_permissions *bool
+
+	// list of class names of exported providers that are intentionally not guarded by a permission
+	// and are allowed by check_exported_provider_permissions.
+	Open_exported_providers []string
+
+	// If true, print a warning for components of the same kind in the merged manifest that declare
+	// intent filters with the same non-default android:priority for a common action, as the order
+	// in which they are chosen is undefined.
+	Check_intent_filter_priorities *bool
+
+	// If true, fail the build if the final manifest is structurally invalid, e.g. because an element
+	// is nested in the wrong parent or is declared more than once where only one is allowed.
+	Check_manifest_structure *bool
+
+	// If true, fail the build if the final manifest declares both android:sharedUserId and an
+	// android:targetSandboxVersion of 2 or higher, which the package manager refuses to install.
+	Check_target_sandbox_version *bool
+
+	// If set, fail the build if the merged manifest, including the permissions requested by static
+	// libraries, declares more than the given number of <uses-permission> and
+	// <uses-permission-sdk-23> tags.
+	Uses_permission_budget *int64
+
+	// If true, fail the build if the targetSdkVersion of the merged manifest differs from the one
+	// that the build system computed for the app, e.g. because a static library or the app's own
+	// manifest declares another one.
+	Check_merged_target_sdk_version *bool
+
+	// If true, fail the build if the minSdkVersion of the final manifest differs from the one that
+	// the build system computed for the app, e.g. because the app's own manifest or a static library
+	// declares a higher one that the manifest fixer doesn't lower.
+	Check_merged_min_sdk_version *bool
+
+	// If set, fail the build if the final manifest of the app differs from the given file.  The
+	// manifests are compared in canonical form, so differences in formatting, attribute order and
+	// comments are ignored.
+	Golden_manifest *string `android:"path"`
+
+	// If set, write a JSON report of the permissions and components that the final manifest of the
+	// app adds or removes relative to the given manifest of a prior release, and of the changes to
+	// its SDK versions.  The manifests are compared in canonical form.  The file is available
+	// through the ".manifest_compat_report.json" output tag.
+	Baseline_manifest *string `android:"path"`
+}
+
+type applicationPropertyProperties struct {
+	// the android:name of the property.
+	Name *string
+
+	// the android:value of the property.  Cannot be set together with resource.
+	Value *string
+
+	// the android:resource of the property, e.g. "@xml/foo".  Cannot be set together with value.
+	Resource *string
+}
+
+type providerUriPermissionGrantProperties struct {
+	// the class name of the provider.
+	Provider *string
+
+	// list of android:path values of <grant-uri-permission> tags to add to the provider.
+	Paths []string
+
+	// list of android:pathPrefix values of <grant-uri-permission> tags to add to the provider.
+	Path_prefixes []string
+
+	// list of android:pathPattern values of <grant-uri-permission> tags to add to the provider.
+	Path_patterns []string
+}
+
+// uriPermissionGrantsForManifestFixer validates the provider_uri_permission_grants property and
+// returns the grants sorted by provider.
+func uriPermissionGrantsForManifestFixer(ctx android.ModuleContext,
+	props []providerUriPermissionGrantProperties) []ManifestUriPermissionGrant {
+
+	var ret []ManifestUriPermissionGrant
+	seen := make(map[string]bool)
+	for _, p := range props {
+		provider := proptools.String(p.Provider)
+		if !isValidManifestClassName(provider) {
+			ctx.PropertyErrorf("provider_uri_permission_grants", "invalid provider %q, must be a class name",
+				provider)
+			continue
+		}
+		if seen[provider] {
+			ctx.PropertyErrorf("provider_uri_permission_grants", "duplicate provider %q", provider)
+			continue
+		}
+		seen[provider] = true
+		if len(p.Paths)+len(p.Path_prefixes)+len(p.Path_patterns) == 0 {
+			ctx.PropertyErrorf("provider_uri_permission_grants", "no paths set for provider %q", provider)
+			continue
+		}
+		for _, grant := range []struct {
+			attr   string
+			values []string
+		}{
+			{"path", p.Paths},
+			{"pathPrefix", p.Path_prefixes},
+			{"pathPattern", p.Path_patterns},
+		} {
+			for _, value := range grant.values {
+				ret = append(ret, ManifestUriPermissionGrant{Provider: provider, Attr: grant.attr, Value: value})
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Provider < ret[j].Provider })
+	return ret
+}
+
+type usesPermissionModificationProperties struct {
+	// the android:name of the permission.
+	Name *string
+
+	// the android:maxSdkVersion to set on the <uses-permission> tags requesting the permission.
+	Max_sdk_version *string
+
+	// the android:usesPermissionFlags to set on the <uses-permission> tags requesting the
+	// permission, e.g. "neverForLocation".
+	Flags *string
+}
+
+var manifestUsesPermissionFlagsRegexp = regexp.MustCompile(`^[a-zA-Z]+(\|[a-zA-Z]+)*$`)
+
+// permissionModificationsForManifestFixer validates the uses_permission_modifications property
+// and returns the modifications sorted by permission.  Permissions can't also be listed in
+// uses_permission_flags, as one would overwrite the flags of the other.
+func permissionModificationsForManifestFixer(ctx android.ModuleContext,
+	props []usesPermissionModificationProperties,
+	usesPermissionFlags map[string]string) []ManifestPermissionModification {
+
+	var ret []ManifestPermissionModification
+	seen := make(map[string]bool)
+	for _, p := range props {
+		modification := ManifestPermissionModification{
+			Name:          proptools.String(p.Name),
+			MaxSdkVersion: proptools.String(p.Max_sdk_version),
+			Flags:         proptools.String(p.Flags),
+		}
+		if !isValidManifestClassName(modification.Name) {
+			ctx.PropertyErrorf("uses_permission_modifications", "invalid permission %q", modification.Name)
+			continue
+		}
+		if seen[modification.Name] {
+			ctx.PropertyErrorf("uses_permission_modifications", "duplicate permission %q", modification.Name)
+			continue
+		}
+		seen[modification.Name] = true
+		if _, ok := usesPermissionFlags[modification.Name]; ok {
+			ctx.PropertyErrorf("uses_permission_modifications",
+				"permission %q is also listed in uses_permission_flags", modification.Name)
+			continue
+		}
+		if modification.MaxSdkVersion == "" && modification.Flags == "" {
+			ctx.PropertyErrorf("uses_permission_modifications",
+				"neither max_sdk_version nor flags set for permission %q", modification.Name)
+			continue
+		}
+		if modification.MaxSdkVersion != "" {
+			if v, err := strconv.Atoi(modification.MaxSdkVersion); err != nil || v <= 0 {
+				ctx.PropertyErrorf("uses_permission_modifications",
+					"invalid max_sdk_version %q for permission %q, must be a positive integer",
+					modification.MaxSdkVersion, modification.Name)
+				continue
+			}
+		}
+		if modification.Flags != "" && !manifestUsesPermissionFlagsRegexp.MatchString(modification.Flags) {
+			ctx.PropertyErrorf("uses_permission_modifications",
+				"invalid flags %q for permission %q, must be |-separated flag names",
+				modification.Flags, modification.Name)
+			continue
+		}
+		ret = append(ret, modification)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// neverForLocationPermissionsForManifestFixer validates the never_for_location_permissions
+// property and returns the permissions sorted.  Permissions can't also be listed in
+// uses_permission_flags or uses_permission_modifications, as one would overwrite the flags of the
+// other.
+func neverForLocationPermissionsForManifestFixer(ctx android.ModuleContext, permissions []string,
+	usesPermissionFlags map[string]string, modifications []ManifestPermissionModification) []string {
+
+	modified := make(map[string]bool)
+	for _, modification := range modifications {
+		modified[modification.Name] = true
+	}
+
+	var ret []string
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		if !isValidManifestClassName(permission) {
+			ctx.PropertyErrorf("never_for_location_permissions", "invalid permission %q", permission)
+			continue
+		}
+		if seen[permission] {
+			ctx.PropertyErrorf("never_for_location_permissions", "duplicate permission %q", permission)
+			continue
+		}
+		seen[permission] = true
+		if _, ok := usesPermissionFlags[permission]; ok {
+			ctx.PropertyErrorf("never_for_location_permissions",
+				"permission %q is also listed in uses_permission_flags", permission)
+			continue
+		}
+		if modified[permission] {
+			ctx.PropertyErrorf("never_for_location_permissions",
+				"permission %q is also listed in uses_permission_modifications", permission)
+			continue
+		}
+		ret = append(ret, permission)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+type usesSdkLibraryProperties struct {
+	// the android:name of the SDK library.
+	Name *string
+
+	// the android:versionMajor of the SDK library.
+	Version_major *string
+
+	// the android:certDigest of the SDK library, the SHA-256 digest of its signing certificate as
+	// 64 hex digits, optionally separated by colons.
+	Cert_digest *string
+}
+
+// usesSdkLibrariesForManifestFixer validates the uses_sdk_libraries property and returns the
+// libraries sorted by name, with the cert digests upper-cased and without colons.
+func usesSdkLibrariesForManifestFixer(ctx android.ModuleContext,
+	props []usesSdkLibraryProperties) []ManifestUsesSdkLibrary {
+
+	var ret []ManifestUsesSdkLibrary
+	seen := make(map[string]bool)
+	for _, p := range props {
+		library := ManifestUsesSdkLibrary{
+			Name:         proptools.String(p.Name),
+			VersionMajor: proptools.String(p.Version_major),
+			CertDigest:   strings.ToUpper(strings.ReplaceAll(proptools.String(p.Cert_digest), ":", "")),
+		}
+		if !isValidManifestClassName(library.Name) {
+			ctx.PropertyErrorf("uses_sdk_libraries", "invalid name %q", library.Name)
+			continue
+		}
+		if seen[library.Name] {
+			ctx.PropertyErrorf("uses_sdk_libraries", "duplicate SDK library %q", library.Name)
+			continue
+		}
+		seen[library.Name] = true
+		if _, err := strconv.ParseUint(library.VersionMajor, 10, 64); err != nil {
+			ctx.PropertyErrorf("uses_sdk_libraries", "invalid version_major %q of %q, must be a "+
+				"non-negative integer", library.VersionMajor, library.Name)
+			continue
+		}
+		if !manifestCertDigestRegexp.MatchString(library.CertDigest) {
+			ctx.PropertyErrorf("uses_sdk_libraries", "invalid cert_digest %q of %q, must be a "+
+				"SHA-256 digest of 64 hex digits", proptools.String(p.Cert_digest), library.Name)
+			continue
+		}
+		ret = append(ret, library)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+type attributionProperties struct {
+	// the android:tag of the attribution.
+	Tag *string
+
+	// the android:label of the attribution, a @string/... reference.
+	Label *string
+}
+
+// attributionsForManifestFixer validates the attributions property and returns the attributions
+// sorted by tag.
+func attributionsForManifestFixer(ctx android.ModuleContext,
+	props []attributionProperties) []ManifestAttribution {
+
+	var ret []ManifestAttribution
+	seen := make(map[string]bool)
+	for _, p := range props {
+		attribution := ManifestAttribution{
+			Tag:   proptools.String(p.Tag),
+			Label: proptools.String(p.Label),
+		}
+		if attribution.Tag == "" {
+			ctx.PropertyErrorf("attributions", "tag must be set")
+			continue
+		}
+		if !manifestStringReferenceRegexp.MatchString(attribution.Label) {
+			ctx.PropertyErrorf("attributions", "label of %q must be a @string/... reference, got %q",
+				attribution.Tag, attribution.Label)
+			continue
+		}
+		if seen[attribution.Tag] {
+			ctx.PropertyErrorf("attributions", "duplicate attribution %q", attribution.Tag)
+			continue
+		}
+		seen[attribution.Tag] = true
+		ret = append(ret, attribution)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Tag < ret[j].Tag })
+	return ret
+}
+
+type compatibleScreenProperties struct {
+	// the android:screenSize of the screen, one of "small", "normal", "large" or "xlarge".
+	Size *string
+
+	// the android:screenDensity of the screen, one of "ldpi", "mdpi", "hdpi", "xhdpi", "xxhdpi",
+	// "xxxhdpi" or a density in dpi such as "480".
+	Density *string
+}
+
+var manifestScreenSizes = []string{"small", "normal", "large", "xlarge"}
+
+var manifestScreenDensities = []string{"ldpi", "mdpi", "hdpi", "xhdpi", "xxhdpi", "xxxhdpi"}
+
+// compatibleScreensForManifestFixer validates the compatible_screens property and returns the
+// screens sorted by size and density.
+func compatibleScreensForManifestFixer(ctx android.ModuleContext,
+	props []compatibleScreenProperties) []ManifestCompatibleScreen {
+
+	var ret []ManifestCompatibleScreen
+	seen := make(map[ManifestCompatibleScreen]bool)
+	for _, p := range props {
+		screen := ManifestCompatibleScreen{
+			Size:    proptools.String(p.Size),
+			Density: proptools.String(p.Density),
+		}
+		if !android.InList(screen.Size, manifestScreenSizes) {
+			ctx.PropertyErrorf("compatible_screens", "invalid size %q, must be one of %q",
+				screen.Size, manifestScreenSizes)
+			continue
+		}
+		if dpi, err := strconv.Atoi(screen.Density); !android.InList(screen.Density, manifestScreenDensities) &&
+			(err != nil || dpi <= 0) {
+			ctx.PropertyErrorf("compatible_screens", "invalid density %q, must be one of %q or a "+
+				"positive number of dpi", screen.Density, manifestScreenDensities)
+			continue
+		}
+		if seen[screen] {
+			ctx.PropertyErrorf("compatible_screens", "duplicate screen %s:%s", screen.Size, screen.Density)
+			continue
+		}
+		seen[screen] = true
+		ret = append(ret, screen)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Size != ret[j].Size {
+			return ret[i].Size < ret[j].Size
+		}
+		return ret[i].Density < ret[j].Density
+	})
+	return ret
+}
+
+// applicationPropertiesForManifestFixer validates the application_properties property and returns
+// the properties sorted by name.
+func applicationPropertiesForManifestFixer(ctx android.ModuleContext,
+	props []applicationPropertyProperties) []ManifestProperty {
+
+	var ret []ManifestProperty
+	seen := make(map[string]bool)
+	for _, p := range props {
+		prop := ManifestProperty{
+			Name:     proptools.String(p.Name),
+			Value:    proptools.String(p.Value),
+			Resource: proptools.String(p.Resource),
+		}
+		if prop.Name == "" {
+			ctx.PropertyErrorf("application_properties", "name must be set")
+			continue
+		}
+		if (p.Value == nil) == (p.Resource == nil) {
+			ctx.PropertyErrorf("application_properties", "exactly one of value and resource must be set for %q",
+				prop.Name)
+			continue
+		}
+		if p.Resource != nil && !strings.HasPrefix(prop.Resource, "@") {
+			ctx.PropertyErrorf("application_properties", "resource of %q must be a resource reference, got %q",
+				prop.Name, prop.Resource)
+			continue
+		}
+		if seen[prop.Name] {
+			ctx.PropertyErrorf("application_properties", "duplicate property %q", prop.Name)
+			continue
+		}
+		seen[prop.Name] = true
+		ret = append(ret, prop)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// parseManifestComponentValues parses a list property of "<class name>:<value>" entries into a
+// map from class name to value.
+func parseManifestComponentValues(ctx android.ModuleContext, property string, entries []string) map[string]string {
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, value, found := strings.Cut(entry, ":")
+		if !found || !isValidManifestClassName(name) || value == "" {
+			ctx.PropertyErrorf(property, "invalid entry %q, must be \"<class name>:<value>\"", entry)
+			continue
+		}
+		if _, exists := values[name]; exists {
+			ctx.PropertyErrorf(property, "duplicate entry for %q", name)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// parseManifestComponentBools is like parseManifestComponentValues for "true" and "false" values.
+func parseManifestComponentBools(ctx android.ModuleContext, property string, entries []string) map[string]bool {
+	bools := make(map[string]bool, len(entries))
+	for name, value := range parseManifestComponentValues(ctx, property, entries) {
+		if value != "true" && value != "false" {
+			ctx.PropertyErrorf(property, "invalid value %q for %q, must be \"true\" or \"false\"", value, name)
+			continue
+		}
+		bools[name] = value == "true"
+	}
+	return bools
+}
+
+// manifestActivityWindowAttributes are the window attributes that window_attributes_activities can
+// set on activities, mapped to their accepted values.  android:windowSoftInputMode accepts a
+// "|"-separated combination of its values.
+var manifestActivityWindowAttributes = map[string][]string{
+	"colorMode":                       {"default", "hdr", "wideColorGamut"},
+	"rotationAnimation":               {"crossfade", "jumpcut", "rotate", "seamless"},
+	"windowLayoutInDisplayCutoutMode": {"always", "default", "never", "shortEdges"},
+	"windowSoftInputMode": {"adjustNothing", "adjustPan", "adjustResize", "adjustUnspecified",
+		"stateAlwaysHidden", "stateAlwaysVisible", "stateHidden", "stateUnchanged",
+		"stateUnspecified", "stateVisible"},
+}
+
+// activityWindowAttributesForManifestFixer validates the window_attributes_activities property
+// and returns the window attributes to set, keyed by activity class name and attribute name.
+func activityWindowAttributesForManifestFixer(ctx android.ModuleContext,
+	entries []string) map[string]map[string]string {
+
+	const property = "window_attributes_activities"
+	ret := make(map[string]map[string]string)
+	for _, entry := range entries {
+		name, attrValue, _ := strings.Cut(entry, ":")
+		attr, value, found := strings.Cut(attrValue, "=")
+		if !found || !isValidManifestClassName(name) || value == "" {
+			ctx.PropertyErrorf(property, "invalid entry %q, must be \"<class name>:<attribute>=<value>\"",
+				entry)
+			continue
+		}
+		accepted, ok := manifestActivityWindowAttributes[attr]
+		if !ok {
+			ctx.PropertyErrorf(property, "invalid attribute %q for %q, must be one of %q",
+				attr, name, android.SortedKeys(manifestActivityWindowAttributes))
+			continue
+		}
+		tokens := []string{value}
+		if attr == "windowSoftInputMode" {
+			tokens = strings.Split(value, "|")
+		}
+		valid := true
+		for _, token := range tokens {
+			if !android.InList(token, accepted) {
+				ctx.PropertyErrorf(property, "invalid %s %q for %q, must be one of %q",
+					attr, token, name, accepted)
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+		if ret[name] == nil {
+			ret[name] = make(map[string]string)
+		}
+		if _, exists := ret[name][attr]; exists {
+			ctx.PropertyErrorf(property, "duplicate %s for %q", attr, name)
+			continue
+		}
+		ret[name][attr] = value
+	}
+	return ret
+}
+
+// parseManifestComponentStrings is like parseManifestComponentValues for @string/... references.
+func parseManifestComponentStrings(ctx android.ModuleContext, property string, entries []string) map[string]string {
+	values := parseManifestComponentValues(ctx, property, entries)
+	for _, name := range android.SortedKeys(values) {
+		if value := values[name]; !manifestStringReferenceRegexp.MatchString(value) {
+			ctx.PropertyErrorf(property, "invalid value %q for %q, must be a @string/... reference",
+				value, name)
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// setManifestFixerParams fills in the fields of params that are controlled by the
+// appManifestProperties of an app and the product configuration.
+func (p *appManifestProperties) setManifestFixerParams(ctx android.ModuleContext, params *ManifestFixerParams) {
+	params.RemoveMetaData = p.Remove_meta_data
+	if proptools.Bool(p.Test_suites_meta_data) {
+		if test, ok := ctx.Module().(androidTestApp); ok {
+			params.TestSuites = android.SortedUniqueStrings(test.testSuites())
+		}
+	}
+	params.AllowBackup = allowBackupForManifestFixer(ctx, p.Allow_backup)
+	params.GwpAsanMode = proptools.String(p.Gwp_asan_mode)
+	params.BackupAgent = proptools.String(p.Backup_agent)
+	params.ZygotePreloadName = proptools.String(p.Zygote_preload_name)
+	params.AppComponentFactory = proptools.String(p.App_component_factory)
+	params.RequiredSplitTypes = splitTypesForManifestFixer(ctx, "required_split_types", p.Required_split_types)
+	params.SplitTypes = splitTypesForManifestFixer(ctx, "split_types", p.Split_types)
+	params.DefaultTheme = proptools.String(p.Default_theme)
+	params.OverrideTheme = proptools.Bool(p.Override_theme)
+	params.FullBackupContent = proptools.String(p.Full_backup_content)
+	params.AppRestrictions = proptools.String(p.App_restrictions)
+	params.RestoreAnyVersion = p.Restore_any_version
+	params.CrossProfile = p.Cross_profile
+	params.AllowNativeHeapPointerTagging = p.Allow_native_heap_pointer_tagging
+	params.LargeHeap = p.Large_heap
+	params.ResizeableActivity = p.Resizeable_activity
+	params.RequestRawExternalStorageAccess = p.Request_raw_external_storage_access
+	params.PreserveLegacyExternalStorage = p.Preserve_legacy_external_storage
+	params.ExtractNativeLibsOverride = p.Extract_native_libs
+	params.KnownActivityEmbeddingCerts = knownActivityEmbeddingCertsForManifestFixer(ctx,
+		p.Known_activity_embedding_certs)
+	params.GenerateLocaleConfig = proptools.Bool(p.Generate_locale_config)
+	params.HardwareAccelerated = p.Hardware_accelerated
+	params.DirectBootAware = p.Direct_boot_aware
+	params.AllowClearUserData = p.Allow_clear_user_data
+	params.Persistent = p.Persistent
+	params.MultiArch = p.Multi_arch
+	params.KillAfterRestore = p.Kill_after_restore
+	if p.Ui_options != nil {
+		if !android.InList(*p.Ui_options, manifestUiOptions) {
+			ctx.PropertyErrorf("ui_options", "invalid value %q, must be one of %q", *p.Ui_options, manifestUiOptions)
+		} else {
+			params.UiOptions = *p.Ui_options
+		}
+	}
+	params.VersionCode = proptools.String(p.Version_code)
+	if p.Version_code_file != nil {
+		if p.Version_code != nil {
+			ctx.PropertyErrorf("version_code_file", "cannot be set together with version_code")
+		}
+		params.VersionCodeFile = android.PathForModuleSrc(ctx, *p.Version_code_file)
+	}
+	params.VersionName = proptools.String(p.Version_name)
+	params.InstallLocation = proptools.String(p.Install_location)
+	params.IsSplitRequired = p.Is_split_required
+	params.SharedUserMaxSdkVersion = proptools.String(p.Shared_user_max_sdk_version)
+	params.SharedUserLabel = proptools.String(p.Shared_user_label)
+	params.ApplicationAttributes = applicationAttributesForManifestFixer(ctx, p.Application_attributes)
+	params.ApplicationProperties = applicationPropertiesForManifestFixer(ctx, p.Application_properties)
+	params.AttributionsAreUserVisible = p.Attributions_are_user_visible
+	params.Attributions = attributionsForManifestFixer(ctx, p.Attributions)
+	params.CompatibleScreens = compatibleScreensForManifestFixer(ctx, p.Compatible_screens)
+	params.UsesSdkLibraries = usesSdkLibrariesForManifestFixer(ctx, p.Uses_sdk_libraries)
+	for _, component := range p.Disabled_components {
+		if !isValidManifestClassName(component) {
+			ctx.PropertyErrorf("disabled_components", "invalid component %q, must be a class name", component)
+		}
+	}
+	params.DisabledComponents = p.Disabled_components
+	params.UriPermissionGrants = uriPermissionGrantsForManifestFixer(ctx, p.Provider_uri_permission_grants)
+	switch mode := proptools.String(p.Launcher_activities_exported); mode {
+	case "", "validate":
+	case "fix":
+		params.ExportLauncherActivities = true
+	default:
+		ctx.PropertyErrorf("launcher_activities_exported", "invalid value %q, must be \"fix\" or \"validate\"", mode)
+	}
+	switch mode := proptools.String(p.Broadcast_receivers_exported); mode {
+	case "", "validate":
+	case "fix":
+		params.ExportBroadcastReceivers = true
+	default:
+		ctx.PropertyErrorf("broadcast_receivers_exported", "invalid value %q, must be \"fix\" or \"validate\"", mode)
+	}
+	switch mode := proptools.String(p.Clear_task_affinity); mode {
+	case "", "exported", "all":
+		params.ClearTaskAffinity = mode
+	default:
+		ctx.PropertyErrorf("clear_task_affinity", "invalid value %q, must be \"exported\" or \"all\"", mode)
+	}
+	params.ActivityHardwareAccelerated = parseManifestComponentBools(ctx,
+		"hardware_accelerated_activities", p.Hardware_accelerated_activities)
+	params.ComponentDirectBootAware = parseManifestComponentBools(ctx,
+		"direct_boot_aware_components", p.Direct_boot_aware_components)
+	params.ComponentLabel = parseManifestComponentStrings(ctx, "label_components", p.Label_components)
+	params.ComponentDescription = parseManifestComponentStrings(ctx,
+		"description_components", p.Description_components)
+	params.ActivityResizeableActivity = parseManifestComponentBools(ctx,
+		"resizeable_activities", p.Resizeable_activities)
+	params.ActivityOnBackInvokedCallback = parseManifestComponentBools(ctx,
+		"on_back_invoked_callback_activities", p.On_back_invoked_callback_activities)
+	params.ActivityUiOptions = parseManifestComponentValues(ctx,
+		"ui_options_activities", p.Ui_options_activities)
+	for _, name := range android.SortedKeys(params.ActivityUiOptions) {
+		if value := params.ActivityUiOptions[name]; !android.InList(value, manifestUiOptions) {
+			ctx.PropertyErrorf("ui_options_activities", "invalid value %q for %q, must be one of %q",
+				value, name, manifestUiOptions)
+			delete(params.ActivityUiOptions, name)
+		}
+	}
+	params.ActivityTheme = parseManifestComponentValues(ctx, "theme_activities", p.Theme_activities)
+	for _, name := range android.SortedKeys(params.ActivityTheme) {
+		if value := params.ActivityTheme[name]; !manifestStyleReferenceRegexp.MatchString(value) {
+			ctx.PropertyErrorf("theme_activities", "invalid theme %q for %q, must be a @style/... reference",
+				value, name)
+			delete(params.ActivityTheme, name)
+		}
+	}
+	params.ActivityWindowAttributes = activityWindowAttributesForManifestFixer(ctx,
+		p.Window_attributes_activities)
+	params.UsesPermissionFlags = parseManifestComponentValues(ctx,
+		"uses_permission_flags", p.Uses_permission_flags)
+	params.AddMissingUsesPermissions = proptools.Bool(p.Add_missing_uses_permissions)
+	params.PermissionModifications = permissionModificationsForManifestFixer(ctx,
+		p.Uses_permission_modifications, params.UsesPermissionFlags)
+	params.NeverForLocationPermissions = neverForLocationPermissionsForManifestFixer(ctx,
+		p.Never_for_location_permissions, params.UsesPermissionFlags, params.PermissionModifications)
+}
+
+// setManifestCheckParams fills in the fields of params that are controlled by the
+// appManifestProperties of an app.
+func (p *appManifestProperties) setManifestCheckParams(ctx android.ModuleContext, params *ManifestCheckParams) {
+	params.EnforceSignatureProtectedComponents = proptools.Bool(p.Enforce_signature_protected_components)
+	params.CheckLauncherActivitiesExported = proptools.String(p.Launcher_activities_exported) == "validate"
+	params.CheckBroadcastReceiversExported = proptools.String(p.Broadcast_receivers_exported) == "validate"
+	params.CheckProviderAuthorities = proptools.Bool(p.Check_provider_authorities)
+	params.CheckFileProviderPaths = proptools.Bool(p.Check_file_provider_paths)
+	params.CheckComponentFeatureFlags = proptools.Bool(p.Check_component_feature_flags)
+	params.CheckIntentFilterPriorities = proptools.Bool(p.Check_intent_filter_priorities)
+	params.CheckExportedServicePermissions = proptools.Bool(p.Check_exported_service_permissions)
+	params.CheckStructure = proptools.Bool(p.Check_manifest_structure)
+	params.CheckTargetSandboxVersion = proptools.Bool(p.Check_target_sandbox_version)
+	if p.Uses_permission_budget != nil {
+		if *p.Uses_permission_budget < 0 {
+			ctx.PropertyErrorf("uses_permission_budget", "must not be negative, got %d", *p.Uses_permission_budget)
+		} else {
+			params.UsesPermissionBudget = proptools.IntPtr(int(*p.Uses_permission_budget))
+		}
+	}
+	for _, service := range p.Open_exported_services {
+		if !isValidManifestClassName(service) {
+			ctx.PropertyErrorf("open_exported_services", "invalid service %q, must be a class name", service)
+		}
+	}
+	params.OpenExportedServices = p.Open_exported_services
+	params.CheckExportedProviderPermissions = proptools.Bool(p.Check_exported_provider_permissions)
+	for _, provider := range p.Open_exported_providers {
+		if !isValidManifestClassName(provider) {
+			ctx.PropertyErrorf("open_exported_providers", "invalid provider %q, must be a class name", provider)
+		}
+	}
+	params.OpenExportedProviders = p.Open_exported_providers
+	if p.Golden_manifest != nil {
+		params.GoldenManifest = android.PathForModuleSrc(ctx, *p.Golden_manifest)
+	}
+}
+
+// rroManifestsForManifestMerger returns the manifests of the runtime_resource_overlay modules
+// listed in rro_manifests that should be merged into the app's manifest, with their <overlay>
+// tag and package removed.  Overlays that target a package other than packageName, the package
+// the app is renamed to if any, are rejected.
+func rroManifestsForManifestMerger(ctx android.ModuleContext, packageName string) android.Paths {
+	var manifests android.Paths
+	ctx.VisitDirectDepsWithTag(rroManifestTag, func(module android.Module) {
+		name := ctx.OtherModuleName(module)
+		rro, ok := module.(*RuntimeResourceOverlay)
+		if !ok {
+			ctx.PropertyErrorf("rro_manifests", "%q is not a runtime_resource_overlay module", name)
+			return
+		}
+		if Bool(rro.properties.Exclude_from_manifest_merge) {
+			return
+		}
+		if target := proptools.String(rro.overridableProperties.Target_package_name); target != "" &&
+			packageName != "" && target != packageName {
+			ctx.PropertyErrorf("rro_manifests", "%q targets package %q, not %q", name, target, packageName)
+			return
+		}
+
+		stripped := android.PathForModuleOut(ctx, "rro_manifests", name, "AndroidManifest.xml")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        manifestFixerRule,
+			Description: "strip overlay manifest",
+			Input:       rro.manifestPath,
+			Output:      stripped,
+			Args: map[string]string{
+				"args": "--strip-overlay",
+			},
+		})
+		manifests = append(manifests, stripped)
+	})
+	return manifests
+}
+
+// allowBackupForManifestFixer applies the product's android:allowBackup policy to the value of the
+// allow_backup property.
+func allowBackupForManifestFixer(ctx android.ModuleContext, allowBackup *bool) *bool {
+	productDefault := ctx.Config().ManifestAllowBackupDefault()
+	if allowBackup == nil {
+		return productDefault
+	}
+	if *allowBackup && productDefault != nil && !*productDefault &&
+		!android.InList(ctx.ModuleName(), ctx.Config().ManifestAllowBackupAllowlist()) {
+		ctx.PropertyErrorf("allow_backup", "android:allowBackup=\"true\" is not allowed by the product, "+
+			"add %q to ManifestAllowBackupAllowlist if it is intentional", ctx.ModuleName())
+	}
+	return allowBackup
+}
+
 type ManifestFixerParams struct {
-	SdkContext                     android.SdkContext
-	ClassLoaderContexts            dexpreopt.ClassLoaderContextMap
-	IsLibrary                      bool
-	DefaultManifestVersion         string
-	UseEmbeddedNativeLibs          bool
-	UsesNonSdkApis                 bool
-	UseEmbeddedDex                 bool
-	HasNoCode                      bool
-	TestOnly                       bool
-	LoggingParent                  string
-	EnforceDefaultTargetSdkVersion bool
+	SdkContext                      android.SdkContext
+	ClassLoaderContexts             dexpreopt.ClassLoaderContextMap
+	IsLibrary                       bool
+	DefaultManifestVersion          string
+	UseEmbeddedNativeLibs           bool
+	ExtractNativeLibsOverride       *bool
+	UsesNonSdkApis                  bool
+	UseEmbeddedDex                  bool
+	HasNoCode                       bool
+	TestOnly                        bool
+	LoggingParent                   string
+	EnforceDefaultTargetSdkVersion  bool
+	RemoveMetaData                  []string
+	TestSuites                      []string
+	AllowBackup                     *bool
+	MarkFinal                       bool
+	GwpAsanMode                     string
+	BackupAgent                     string
+	ZygotePreloadName               string
+	AppComponentFactory             string
+	RequiredSplitTypes              []string
+	SplitTypes                      []string
+	DefaultTheme                    string
+	OverrideTheme                   bool
+	FullBackupContent               string
+	AppRestrictions                 string
+	RestoreAnyVersion               *bool
+	CrossProfile                    *bool
+	AllowNativeHeapPointerTagging   *bool
+	LargeHeap                       *bool
+	ResizeableActivity              *bool
+	ActivityResizeableActivity      map[string]bool
+	RequestRawExternalStorageAccess *bool
+	PreserveLegacyExternalStorage   *bool
+	KnownActivityEmbeddingCerts     []string
+	GenerateLocaleConfig            bool
+	HardwareAccelerated             *bool
+	ActivityHardwareAccelerated     map[string]bool
+	DirectBootAware                 *bool
+	ComponentDirectBootAware        map[string]bool
+	ComponentLabel                  map[string]string
+	ComponentDescription            map[string]string
+	AllowClearUserData              *bool
+	Persistent                      *bool
+	MultiArch                       *bool
+	KillAfterRestore                *bool
+	UiOptions                       string
+	ActivityUiOptions               map[string]string
+	ActivityTheme                   map[string]string
+	ActivityWindowAttributes        map[string]map[string]string
+	ActivityOnBackInvokedCallback   map[string]bool
+	UsesPermissionFlags             map[string]string
+	AddMissingUsesPermissions       bool
+	PermissionModifications         []ManifestPermissionModification
+	NeverForLocationPermissions     []string
+	VersionCode                     string
+	VersionCodeFile                 android.Path
+	VersionName                     string
+	InstallLocation                 string
+	IsSplitRequired                 *bool
+	SharedUserMaxSdkVersion         string
+	SharedUserLabel                 string
+	ApplicationAttributes           map[string]string
+	ApplicationProperties           []ManifestProperty
+	AttributionsAreUserVisible      *bool
+	Attributions                    []ManifestAttribution
+	CompatibleScreens               []ManifestCompatibleScreen
+	UsesSdkLibraries                []ManifestUsesSdkLibrary
+	OmitCompileSdkVersion           bool
+	NumericSdkVersions              bool
+	StripAllToolsAttributes         bool
+	CanonicalizeNamespaces          bool
+	DisabledComponents              []string
+	ExportLauncherActivities        bool
+	ExportBroadcastReceivers        bool
+	ClearTaskAffinity               string
+	UriPermissionGrants             []ManifestUriPermissionGrant
+}
+
+// manifestManagedApplicationAttributes are the android: attributes of <application> that are set
+// by the build system or by dedicated properties, and so can't be set with
+// application_attributes.
+var manifestManagedApplicationAttributes = []string{
+	"allowBackup",
+	"allowClearUserData",
+	"allowNativeHeapPointerTagging",
+	"appComponentFactory",
+	"attributionsAreUserVisible",
+	"backupAgent",
+	"crossProfile",
+	"debuggable",
+	"directBootAware",
+	"extractNativeLibs",
+	"fullBackupContent",
+	"gwpAsanMode",
+	"hardwareAccelerated",
+	"hasCode",
+	"killAfterRestore",
+	"knownActivityEmbeddingCerts",
+	"largeHeap",
+	"localeConfig",
+	"multiArch",
+	"persistent",
+	"preserveLegacyExternalStorage",
+	"requestRawExternalStorageAccess",
+	"resizeableActivity",
+	"restoreAnyVersion",
+	"testOnly",
+	"theme",
+	"uiOptions",
+	"useEmbeddedDex",
+	"usesNonSdkApi",
+	"zygotePreloadName",
+}
+
+// applicationAttributesForManifestFixer parses the application_attributes property and rejects
+// the attributes that are managed elsewhere.
+func applicationAttributesForManifestFixer(ctx android.ModuleContext, entries []string) map[string]string {
+	attributes := parseManifestComponentValues(ctx, "application_attributes", entries)
+	for _, name := range android.SortedKeys(attributes) {
+		if android.InList(name, manifestManagedApplicationAttributes) {
+			ctx.PropertyErrorf("application_attributes",
+				"android:%s is managed by the build system and can't be set", name)
+			delete(attributes, name)
+		}
+	}
+	return attributes
+}
+
+// ManifestProperty is a <property> tag added to <application>.  Exactly one of Value and Resource
+// is set.
+type ManifestProperty struct {
+	Name     string
+	Value    string
+	Resource string
+}
+
+// ManifestUriPermissionGrant is a <grant-uri-permission> tag added to a provider, with Attr set to
+// Value.
+type ManifestUriPermissionGrant struct {
+	Provider string
+	Attr     string
+	Value    string
+}
+
+// ManifestPermissionModification sets android:maxSdkVersion and android:usesPermissionFlags
+// together on the <uses-permission> tags requesting a permission.  Empty fields are left as is.
+type ManifestPermissionModification struct {
+	Name          string
+	MaxSdkVersion string
+	Flags         string
+}
+
+// ManifestUsesSdkLibrary is a <uses-sdk-library> tag added to <application>.
+type ManifestUsesSdkLibrary struct {
+	Name         string
+	VersionMajor string
+	CertDigest   string
+}
+
+// ManifestAttribution is an <attribution> tag added to <manifest>.
+type ManifestAttribution struct {
+	Tag   string
+	Label string
+}
+
+// ManifestCompatibleScreen is a <screen> tag added to the <compatible-screens> tag of <manifest>.
+type ManifestCompatibleScreen struct {
+	Size    string
+	Density string
+}
+
+// splitTypesForManifestFixer validates the split type names in the given property and returns
+// them sorted and deduplicated.
+func splitTypesForManifestFixer(ctx android.ModuleContext, property string, splitTypes []string) []string {
+	for _, splitType := range splitTypes {
+		if splitType == "" || strings.ContainsAny(splitType, ", \t") {
+			ctx.PropertyErrorf(property, "invalid split type %q, must be non-empty and must not "+
+				"contain commas or whitespace", splitType)
+		}
+	}
+	return android.SortedUniqueStrings(splitTypes)
+}
+
+// manifestUiOptions are the values accepted for android:uiOptions.
+var manifestUiOptions = []string{"none", "splitActionBarWhenNarrow"}
+
+// manifestClassNameRegexp matches the class names accepted in AndroidManifest.xml attributes,
+// either fully qualified or relative to the package name when starting with a '.'.
+var manifestClassNameRegexp = regexp.MustCompile(`^\.?[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// manifestAttributeNameRegexp matches the names of android: attributes without the prefix.
+var manifestAttributeNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+var manifestStyleReferenceRegexp = regexp.MustCompile(`^@([A-Za-z0-9_.]+:)?style/[A-Za-z0-9_.]+$`)
+
+var manifestStringReferenceRegexp = regexp.MustCompile(`^@([A-Za-z0-9_.]+:)?string/[A-Za-z0-9_.]+$`)
+
+var manifestXmlReferenceRegexp = regexp.MustCompile(`^@xml/[A-Za-z0-9_]+$`)
+
+var manifestCertDigestRegexp = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+// knownActivityEmbeddingCertsArray is the name of the string array resource that is generated for
+// apps with more than one known activity embedding cert, as the manifest attribute can only hold a
+// single digest inline.
+const knownActivityEmbeddingCertsArray = "soong_known_activity_embedding_certs"
+
+// knownActivityEmbeddingCertsForManifestFixer validates the known activity embedding cert digests
+// and returns them upper-cased and sorted, matching the form used by the PackageManager.
+func knownActivityEmbeddingCertsForManifestFixer(ctx android.ModuleContext, certs []string) []string {
+	var ret []string
+	for _, cert := range certs {
+		if !manifestCertDigestRegexp.MatchString(cert) {
+			ctx.ModuleErrorf("invalid knownActivityEmbeddingCerts digest %q, must be a SHA-256 digest "+
+				"of 64 hex digits", cert)
+			continue
+		}
+		ret = append(ret, strings.ToUpper(cert))
+	}
+	return android.SortedUniqueStrings(ret)
+}
+
+// knownActivityEmbeddingCertsResource writes a values resource declaring the string array that
+// android:knownActivityEmbeddingCerts refers to when there is more than one digest.  It returns
+// the resource directory and the values file in it.
+func knownActivityEmbeddingCertsResource(ctx android.ModuleContext, certs []string) (android.Path, android.Path) {
+	dir := android.PathForModuleGen(ctx, "known_activity_embedding_certs", "res")
+	file := android.PathForModuleGen(ctx, "known_activity_embedding_certs", "res", "values",
+		"known_activity_embedding_certs.xml")
+
+	var content strings.Builder
+	content.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n")
+	fmt.Fprintf(&content, "    <string-array name=\"%s\" translatable=\"false\">\n", knownActivityEmbeddingCertsArray)
+	for _, cert := range certs {
+		fmt.Fprintf(&content, "        <item>%s</item>\n", cert)
+	}
+	content.WriteString("    </string-array>\n</resources>\n")
+	android.WriteFileRule(ctx, file, content.String())
+
+	return dir, file
+}
+
+// localeConfigResource is the name of the xml resource that is generated for
+// android:localeConfig when generate_locale_config is set.
+const localeConfigResource = "soong_locale_config"
+
+var manifestLocaleLanguageRegexp = regexp.MustCompile(`^[a-z]{2,3}$`)
+var manifestLocaleRegionRegexp = regexp.MustCompile(`^r[A-Z]{2}$`)
+
+// localeFromResourceQualifiers returns the BCP 47 language tag for the locale qualifiers of a
+// values resource directory name, e.g. "fr" for values-fr, "en-GB" for values-en-rGB and
+// "sr-Latn" for values-b+sr+Latn.  It returns "" if the directory has no locale qualifier.
+func localeFromResourceQualifiers(dirName string) string {
+	qualifiers := strings.Split(dirName, "-")
+	if len(qualifiers) < 2 || qualifiers[0] != "values" {
+		return ""
+	}
+	qualifiers = qualifiers[1:]
+	// The mobile country and network codes precede the locale.
+	for len(qualifiers) > 0 && (strings.HasPrefix(qualifiers[0], "mcc") ||
+		strings.HasPrefix(qualifiers[0], "mnc")) {
+		qualifiers = qualifiers[1:]
+	}
+	if len(qualifiers) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(qualifiers[0], "b+") {
+		return strings.ReplaceAll(strings.TrimPrefix(qualifiers[0], "b+"), "+", "-")
+	}
+	if !manifestLocaleLanguageRegexp.MatchString(qualifiers[0]) {
+		return ""
+	}
+	if len(qualifiers) > 1 && manifestLocaleRegionRegexp.MatchString(qualifiers[1]) {
+		return qualifiers[0] + "-" + strings.TrimPrefix(qualifiers[1], "r")
+	}
+	return qualifiers[0]
+}
+
+// localeConfigResourceFile writes an xml resource declaring the locale-config that
+// android:localeConfig refers to, listing the locales of the values resource directories the
+// given resource files are in.  It returns the resource directory and the xml file in it.
+func localeConfigResourceFile(ctx android.ModuleContext, resFiles android.Paths) (android.Path, android.Path) {
+	dir := android.PathForModuleGen(ctx, "locale_config", "res")
+	file := android.PathForModuleGen(ctx, "locale_config", "res", "xml", localeConfigResource+".xml")
+
+	var locales []string
+	for _, resFile := range resFiles {
+		if locale := localeFromResourceQualifiers(filepath.Base(filepath.Dir(resFile.String()))); locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	locales = android.SortedUniqueStrings(locales)
+	if len(locales) == 0 {
+		ctx.PropertyErrorf("generate_locale_config", "no values-<locale> resource directories found")
+	}
+
+	var content strings.Builder
+	content.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	content.WriteString("<locale-config xmlns:android=\"http://schemas.android.com/apk/res/android\">\n")
+	for _, locale := range locales {
+		fmt.Fprintf(&content, "    <locale android:name=\"%s\"/>\n", locale)
+	}
+	content.WriteString("</locale-config>\n")
+	android.WriteFileRule(ctx, file, content.String())
+
+	return dir, file
+}
+
+func isValidManifestClassName(name string) bool {
+	return manifestClassNameRegexp.MatchString(name)
+}
+
+// usesLibrariesFromManifestFixerArgs returns the names passed to manifest_fixer.py via
+// --uses-library and via --optional-uses-library, each in the order they will appear in the
+// manifest.
+func usesLibrariesFromManifestFixerArgs(args []string) (required []string, optional []string) {
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--uses-library":
+			required = append(required, args[i+1])
+			i++
+		case "--optional-uses-library":
+			optional = append(optional, args[i+1])
+			i++
+		}
+	}
+	return required, optional
+}
+
+// checkUsesLibrariesOrder reports an error if the required or the optional <uses-library> tags
+// that manifest_fixer.py will add are not in the order that dexpreopt expects, i.e. the order
+// of ClassLoaderContextMap.UsesLibs() that is also passed to manifest_check.py to verify the
+// merged manifest.  The PackageManager constructs the class loader context on device in manifest
+// order, and a mismatch with the build-time context causes dexpreopted code to be rejected at
+// runtime.
+func checkUsesLibrariesOrder(ctx android.ModuleContext, args []string, clcMap dexpreopt.ClassLoaderContextMap) {
+	expectedRequired, expectedOptional := clcMap.UsesLibs()
+	required, optional := usesLibrariesFromManifestFixerArgs(args)
+
+	if all := append(slices.Clone(required), optional...); len(android.FirstUniqueStrings(all)) != len(all) {
+		ctx.ModuleErrorf("duplicate <uses-library> in manifest: %q", all)
+	} else if !slices.Equal(required, expectedRequired) {
+		ctx.ModuleErrorf("<uses-library> order in manifest %q does not match class loader context order %q",
+			required, expectedRequired)
+	} else if !slices.Equal(optional, expectedOptional) {
+		ctx.ModuleErrorf("optional <uses-library> order in manifest %q does not match class loader "+
+			"context order %q", optional, expectedOptional)
+	}
+}
+
+// checkUsesLibrariesBacked reports an error for the libraries in the class loader context, both
+// the <uses-library> tags that manifest_fixer.py will add and the compatibility libraries, that are
+// not resolved to a dependency on a library module that builds a dex jar, e.g. because only the
+// stubs of the library are available.  The manifest or dexpreopt would then reference a library
+// that the build doesn't produce.  The dependencies are followed through the dependencies that
+// propagate class loader contexts, as addCLCFromDep does.
+func checkUsesLibrariesBacked(ctx android.ModuleContext, clcMap dexpreopt.ClassLoaderContextMap) {
+	backed := make(map[string]bool)
+	visited := make(map[android.Module]bool)
+	ctx.WalkDeps(func(child, parent android.Module) bool {
+		depTag := ctx.OtherModuleDependencyTag(child)
+		if _, ok := depTag.(usesLibraryDependencyTag); !ok && !IsLibDepTag(depTag) && depTag != staticLibTag {
+			return false
+		}
+		if lib, ok := child.(UsesLibraryDependency); ok && lib.DexJarBuildPath(ctx).Valid() {
+			name := android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(child))
+			if ulib, ok := child.(ProvidesUsesLib); ok && ulib.ProvidesUsesLib() != nil {
+				name = *ulib.ProvidesUsesLib()
+			}
+			backed[name] = true
+		}
+		if visited[child] {
+			return false
+		}
+		visited[child] = true
+		return true
+	})
+
+	for _, sdkVer := range android.SortedKeys(clcMap) {
+		for _, clc := range clcMap[sdkVer] {
+			if backed[clc.Name] {
+				continue
+			}
+			if sdkVer == dexpreopt.AnySdkVersion {
+				ctx.ModuleErrorf("<uses-library> %q in manifest is not backed by a library that is built", clc.Name)
+			} else {
+				ctx.ModuleErrorf("compatibility library %q for SDK %d in class loader context is not backed "+
+					"by a library that is built", clc.Name, sdkVer)
+			}
+		}
+	}
+}
+
+// checkUsesLibraryCertificates reports an error for the required <uses-library> tags that
+// manifest_fixer.py will add for libraries that the product's ManifestUsesLibraryCertificates
+// restricts to apps signed with a certificate other than certificate, which was resolved by
+// processMainCert from certPropValue.  Optional libraries are not checked, the platform doesn't
+// load them for apps that don't satisfy their restrictions.
+func checkUsesLibraryCertificates(ctx android.ModuleContext, clcMap dexpreopt.ClassLoaderContextMap,
+	certPropValue string, certificate Certificate) {
+
+	restrictions := make(map[string]string)
+	for _, entry := range ctx.Config().ManifestUsesLibraryCertificates() {
+		lib, cert, found := strings.Cut(entry, ":")
+		if !found || lib == "" || cert == "" {
+			ctx.ModuleErrorf("invalid ManifestUsesLibraryCertificates entry %q, must be "+
+				"<library>:<certificate>", entry)
+			continue
+		}
+		restrictions[lib] = cert
+	}
+
+	required, _ := clcMap.UsesLibs()
+	defaultDir := ctx.Config().DefaultAppCertificateDir(ctx)
+	for _, lib := range required {
+		cert, ok := restrictions[lib]
+		if !ok {
+			continue
+		}
+		var matches bool
+		if module := android.SrcIsModule(cert); module != "" {
+			// An android_app_certificate module, compare with the module the app is signed with.
+			matches = android.SrcIsModule(certPropValue) == module
+		} else {
+			// A certificate from the default certificate directory, resolved the same way as
+			// processMainCert does.
+			expected := Certificate{
+				Pem: defaultDir.Join(ctx, cert+".x509.pem"),
+				Key: defaultDir.Join(ctx, cert+".pk8"),
+			}
+			matches = !certificate.presigned &&
+				certificate.Pem.String() == expected.Pem.String() &&
+				certificate.Key.String() == expected.Key.String()
+		}
+		if !matches {
+			ctx.ModuleErrorf("<uses-library> %q may only be used by apps signed with the %q "+
+				"certificate, but the app is signed with %q", lib, cert, certificate.Pem.String())
+		}
+	}
+}
+
+// checkUsesLibraryModules reports an error for the modules in uses_libs and optional_uses_libs
+// whose AndroidManifest.xml was built as the manifest of an app rather than of a library.  Modules
+// without a manifest built by the manifest fixer, e.g. java_sdk_library, are not checked.
+func checkUsesLibraryModules(ctx android.ModuleContext) {
+	ctx.VisitDirectDeps(func(m android.Module) {
+		tag, ok := ctx.OtherModuleDependencyTag(m).(usesLibraryDependencyTag)
+		if !ok {
+			return
+		}
+		info, ok := android.OtherModuleProvider(ctx, m, ManifestMetadataInfoProvider)
+		if !ok || info.IsLibrary {
+			return
+		}
+		property := "uses_libs"
+		if tag.optional {
+			property = "optional_uses_libs"
+		}
+		ctx.PropertyErrorf(property, "module %q is not a library, its manifest is built as the "+
+			"manifest of an app", android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(m)))
+	})
+}
+
+// classLoaderContextLibsWithoutUsesLibs returns the sorted names of the libraries in the class
+// loader context if none of them becomes a <uses-library> tag in the manifest, which happens when
+// the context only holds compatibility libraries for older SDK versions.  It returns nil otherwise.
+func classLoaderContextLibsWithoutUsesLibs(clcMap dexpreopt.ClassLoaderContextMap) []string {
+	required, optional := clcMap.UsesLibs()
+	if len(required) > 0 || len(optional) > 0 {
+		return nil
+	}
+	var libs []string
+	for _, clcs := range clcMap {
+		for _, clc := range clcs {
+			libs = append(libs, clc.Name)
+		}
+	}
+	return android.SortedUniqueStrings(libs)
 }
 
 // Uses manifest_fixer.py to inject minSdkVersion, etc. into an AndroidManifest.xml
 func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 	params ManifestFixerParams) android.Path {
+	fixedManifest, _ := manifestFixerWithArgs(ctx, manifest, params)
+	return fixedManifest
+}
+
+// manifestFixerWithArgs is like ManifestFixer, and also returns the arguments that are passed to
+// manifest_fixer.py.
+func manifestFixerWithArgs(ctx android.ModuleContext, manifest android.Path,
+	params ManifestFixerParams) (android.Path, []string) {
 	var args []string
 
 	if params.IsLibrary {
@@ -106,7 +1712,14 @@ func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 		if err != nil {
 			ctx.ModuleErrorf("invalid minSdkVersion: %s", err)
 		}
-		if minSdkVersion.FinalOrFutureInt() >= 23 {
+		if override := params.ExtractNativeLibsOverride; override != nil {
+			if minSdkVersion.FinalOrFutureInt() < 23 && (!*override || params.UseEmbeddedNativeLibs) {
+				ctx.ModuleErrorf("module attempted to store uncompressed native libraries, but minSdkVersion=%s doesn't support it",
+					minSdkVersion.String())
+			} else {
+				args = append(args, fmt.Sprintf("--extract-native-libs=%v", *override))
+			}
+		} else if minSdkVersion.FinalOrFutureInt() >= 23 {
 			args = append(args, fmt.Sprintf("--extract-native-libs=%v", !params.UseEmbeddedNativeLibs))
 		} else if params.UseEmbeddedNativeLibs {
 			ctx.ModuleErrorf("module attempted to store uncompressed native libraries, but minSdkVersion=%s doesn't support it",
@@ -133,6 +1746,20 @@ func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 		for _, usesLib := range optionalUsesLibs {
 			args = append(args, "--optional-uses-library", usesLib)
 		}
+
+		if ctx.Config().ManifestCheckUsesLibrariesOrder() {
+			checkUsesLibrariesOrder(ctx, args, params.ClassLoaderContexts)
+		}
+		if ctx.Config().ManifestStrictUsesLibraries() {
+			checkUsesLibrariesBacked(ctx, params.ClassLoaderContexts)
+		}
+
+		if ctx.Config().IsEnvTrue("SOONG_WARN_MANIFEST_CLASS_LOADER_CONTEXT_MISMATCH") {
+			// Opt-in, as apps that only use compatibility libraries legitimately get no tags.
+			if libs := classLoaderContextLibsWithoutUsesLibs(params.ClassLoaderContexts); len(libs) > 0 {
+				args = append(args, "--warn-missing-uses-libraries", strings.Join(libs, ","))
+			}
+		}
 	}
 
 	if params.HasNoCode {
@@ -146,13 +1773,183 @@ func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 	if params.LoggingParent != "" {
 		args = append(args, "--logging-parent", params.LoggingParent)
 	}
+
+	if len(params.TestSuites) > 0 {
+		args = append(args, "--test-suites-meta-data", strings.Join(params.TestSuites, ","))
+	}
+
+	if params.AllowBackup != nil {
+		args = append(args, fmt.Sprintf("--allow-backup=%v", *params.AllowBackup))
+	}
+
+	if params.HardwareAccelerated != nil {
+		args = append(args, fmt.Sprintf("--hardware-accelerated=%v", *params.HardwareAccelerated))
+	}
+
+	if params.DirectBootAware != nil {
+		args = append(args, fmt.Sprintf("--direct-boot-aware=%v", *params.DirectBootAware))
+	}
+
+	if params.AllowClearUserData != nil {
+		args = append(args, fmt.Sprintf("--allow-clear-user-data=%v", *params.AllowClearUserData))
+	}
+
+	if params.Persistent != nil {
+		args = append(args, fmt.Sprintf("--persistent=%v", *params.Persistent))
+	}
+
+	if params.MultiArch != nil {
+		args = append(args, fmt.Sprintf("--multi-arch=%v", *params.MultiArch))
+	}
+
+	if params.KillAfterRestore != nil {
+		args = append(args, fmt.Sprintf("--kill-after-restore=%v", *params.KillAfterRestore))
+	}
+
+	if params.UiOptions != "" {
+		args = append(args, "--ui-options", params.UiOptions)
+	}
+
+	if params.RestoreAnyVersion != nil {
+		args = append(args, fmt.Sprintf("--restore-any-version=%v", *params.RestoreAnyVersion))
+	}
+
+	if params.CrossProfile != nil {
+		args = append(args, fmt.Sprintf("--cross-profile=%v", *params.CrossProfile))
+	}
+
+	if params.AllowNativeHeapPointerTagging != nil {
+		args = append(args, fmt.Sprintf("--allow-native-heap-pointer-tagging=%v",
+			*params.AllowNativeHeapPointerTagging))
+	}
+
+	if params.LargeHeap != nil {
+		args = append(args, fmt.Sprintf("--large-heap=%v", *params.LargeHeap))
+	}
+
+	if params.ResizeableActivity != nil {
+		args = append(args, fmt.Sprintf("--resizeable-activity=%v", *params.ResizeableActivity))
+	}
+
+	if params.RequestRawExternalStorageAccess != nil {
+		args = append(args, fmt.Sprintf("--request-raw-external-storage-access=%v",
+			*params.RequestRawExternalStorageAccess))
+	}
+
+	if params.PreserveLegacyExternalStorage != nil {
+		args = append(args, fmt.Sprintf("--preserve-legacy-external-storage=%v",
+			*params.PreserveLegacyExternalStorage))
+	}
+
+	if params.AttributionsAreUserVisible != nil {
+		args = append(args, fmt.Sprintf("--attributions-are-user-visible=%v", *params.AttributionsAreUserVisible))
+	}
+
+	for _, attribution := range params.Attributions {
+		args = append(args, "--attribution", proptools.ShellEscape(attribution.Tag+"="+attribution.Label))
+	}
+
+	for _, screen := range params.CompatibleScreens {
+		args = append(args, "--compatible-screen", screen.Size+":"+screen.Density)
+	}
+
+	for _, library := range params.UsesSdkLibraries {
+		args = append(args, "--uses-sdk-library",
+			proptools.ShellEscape(library.Name+"="+library.VersionMajor+":"+library.CertDigest))
+	}
+
+	for _, name := range android.SortedKeys(params.ApplicationAttributes) {
+		args = append(args, "--application-attribute",
+			proptools.ShellEscape(name+"="+params.ApplicationAttributes[name]))
+	}
+
+	if params.BackupAgent != "" {
+		if !isValidManifestClassName(params.BackupAgent) {
+			ctx.ModuleErrorf("invalid backupAgent %q, must be a class name", params.BackupAgent)
+		}
+		args = append(args, "--backup-agent", params.BackupAgent)
+	}
+
+	if params.ZygotePreloadName != "" {
+		if !isValidManifestClassName(params.ZygotePreloadName) {
+			ctx.ModuleErrorf("invalid zygotePreloadName %q, must be a class name", params.ZygotePreloadName)
+		}
+		args = append(args, "--zygote-preload-name", params.ZygotePreloadName)
+	}
+
+	if params.AppComponentFactory != "" {
+		if !isValidManifestClassName(params.AppComponentFactory) {
+			ctx.ModuleErrorf("invalid appComponentFactory %q, must be a class name", params.AppComponentFactory)
+		}
+		args = append(args, "--app-component-factory", params.AppComponentFactory)
+	}
+
+	if params.GwpAsanMode != "" {
+		switch params.GwpAsanMode {
+		case "always", "never", "default":
+			args = append(args, "--gwp-asan-mode", params.GwpAsanMode)
+		default:
+			ctx.ModuleErrorf("invalid gwpAsanMode %q, must be one of \"always\", \"never\" or \"default\"",
+				params.GwpAsanMode)
+		}
+	}
+
+	if params.DefaultTheme != "" {
+		if !manifestStyleReferenceRegexp.MatchString(params.DefaultTheme) {
+			ctx.ModuleErrorf("invalid default theme %q, must be a @style/... reference", params.DefaultTheme)
+		}
+		args = append(args, "--default-theme", params.DefaultTheme)
+		if params.OverrideTheme {
+			args = append(args, "--override-theme")
+		}
+	}
+
+	if params.FullBackupContent != "" {
+		if !manifestXmlReferenceRegexp.MatchString(params.FullBackupContent) {
+			ctx.ModuleErrorf("invalid fullBackupContent %q, must be a @xml/... reference",
+				params.FullBackupContent)
+		}
+		args = append(args, "--full-backup-content", params.FullBackupContent)
+	}
+
+	if params.AppRestrictions != "" {
+		if !manifestXmlReferenceRegexp.MatchString(params.AppRestrictions) {
+			ctx.ModuleErrorf("invalid app restrictions %q, must be a @xml/... reference",
+				params.AppRestrictions)
+		}
+		args = append(args, "--app-restrictions", params.AppRestrictions)
+	}
+
+	switch certs := params.KnownActivityEmbeddingCerts; len(certs) {
+	case 0:
+	case 1:
+		args = append(args, "--known-activity-embedding-certs", certs[0])
+	default:
+		args = append(args, "--known-activity-embedding-certs", "@array/"+knownActivityEmbeddingCertsArray)
+	}
+
+	if params.GenerateLocaleConfig {
+		args = append(args, "--locale-config", "@xml/"+localeConfigResource)
+	}
+
+	if len(params.RequiredSplitTypes) > 0 {
+		args = append(args, "--required-split-types", strings.Join(params.RequiredSplitTypes, ","))
+	}
+
+	if len(params.SplitTypes) > 0 {
+		args = append(args, "--split-types", strings.Join(params.SplitTypes, ","))
+	}
+
 	var deps android.Paths
 	var argsMapper = make(map[string]string)
 
 	if params.SdkContext != nil {
 		targetSdkVersion := targetSdkVersionForManifestFixer(ctx, params)
 
-		if useApiFingerprint, fingerprintTargetSdkVersion, fingerprintDeps :=
+		if params.NumericSdkVersions {
+			targetSdkVersion = numericSdkVersionForManifestFixer(ctx, "targetSdkVersion",
+				params.SdkContext.TargetSdkVersion(ctx))
+		} else if useApiFingerprint, fingerprintTargetSdkVersion, fingerprintDeps :=
 			UseApiFingerprint(ctx); useApiFingerprint && ctx.ModuleName() != "framework-res" {
 			targetSdkVersion = fingerprintTargetSdkVersion
 			deps = append(deps, fingerprintDeps)
@@ -170,7 +1967,10 @@ func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 			ctx.ModuleErrorf("invalid ReplaceMaxSdkVersionPlaceholder: %s", err)
 		}
 
-		if useApiFingerprint, fingerprintMinSdkVersion, fingerprintDeps :=
+		if params.NumericSdkVersions {
+			minSdkVersion = numericSdkVersionForManifestFixer(ctx, "minSdkVersion",
+				params.SdkContext.MinSdkVersion(ctx))
+		} else if useApiFingerprint, fingerprintMinSdkVersion, fingerprintDeps :=
 			UseApiFingerprint(ctx); useApiFingerprint && ctx.ModuleName() != "framework-res" {
 			minSdkVersion = fingerprintMinSdkVersion
 			deps = append(deps, fingerprintDeps)
@@ -182,32 +1982,808 @@ func ManifestFixer(ctx android.ModuleContext, manifest android.Path,
 		args = append(args, "--minSdkVersion ", minSdkVersion)
 		args = append(args, "--replaceMaxSdkVersionPlaceholder ", strconv.Itoa(replaceMaxSdkVersionPlaceholder.FinalOrFutureInt()))
 		args = append(args, "--raise-min-sdk-version")
+		if ctx.Config().IsEnvTrue("SOONG_WARN_MANIFEST_MIN_SDK_VERSION_MISMATCH") {
+			// Opt-in, as many manifests still declare a minSdkVersion that Soong overrides.
+			args = append(args, "--warn-min-sdk-version-mismatch")
+		}
 	}
 	if params.DefaultManifestVersion != "" {
 		args = append(args, "--override-placeholder-version", params.DefaultManifestVersion)
 	}
 
+	if params.VersionCode != "" {
+		if _, err := strconv.ParseUint(params.VersionCode, 10, 64); err != nil {
+			ctx.ModuleErrorf("invalid versionCode %q, must be a non-negative integer", params.VersionCode)
+		}
+		args = append(args, "--version-code", params.VersionCode)
+	} else if params.VersionCodeFile != nil {
+		args = append(args, "--version-code-file", params.VersionCodeFile.String())
+		deps = append(deps, params.VersionCodeFile)
+	}
+
+	if params.VersionName != "" {
+		args = append(args, "--version-name", proptools.ShellEscape(params.VersionName))
+	}
+
+	if params.InstallLocation != "" {
+		switch params.InstallLocation {
+		case "auto", "internalOnly", "preferExternal":
+			args = append(args, "--install-location", params.InstallLocation)
+		default:
+			ctx.ModuleErrorf("invalid installLocation %q, must be one of \"auto\", \"internalOnly\" or "+
+				"\"preferExternal\"", params.InstallLocation)
+		}
+	}
+
+	if params.IsSplitRequired != nil {
+		args = append(args, fmt.Sprintf("--is-split-required=%v", *params.IsSplitRequired))
+	}
+
+	if params.SharedUserMaxSdkVersion != "" {
+		apiLevel, err := android.ApiLevelFromUser(ctx, params.SharedUserMaxSdkVersion)
+		if err != nil {
+			ctx.ModuleErrorf("invalid sharedUserMaxSdkVersion: %s", err)
+		} else if apiLevel.IsPreview() {
+			ctx.ModuleErrorf("invalid sharedUserMaxSdkVersion %q, must be a released API level",
+				params.SharedUserMaxSdkVersion)
+		} else {
+			args = append(args, "--shared-user-max-sdk-version", apiLevel.String())
+		}
+	}
+
+	if params.SharedUserLabel != "" {
+		if !manifestStringReferenceRegexp.MatchString(params.SharedUserLabel) {
+			ctx.ModuleErrorf("invalid sharedUserLabel %q, must be a @string/... reference", params.SharedUserLabel)
+		}
+		args = append(args, "--shared-user-label", params.SharedUserLabel)
+	}
+
+	for _, prop := range params.ApplicationProperties {
+		if prop.Resource != "" {
+			args = append(args, "--application-resource-property", prop.Name+"="+prop.Resource)
+		} else {
+			args = append(args, "--application-property", proptools.ShellEscape(prop.Name+"="+prop.Value))
+		}
+	}
+
+	if params.OmitCompileSdkVersion {
+		args = append(args, "--remove-compile-sdk-version")
+	}
+
+	if params.MarkFinal {
+		args = append(args, "--mark-final")
+	}
+
+	if canonicalManifestFixerArgsEnabled(ctx.Config()) {
+		args = canonicalManifestFixerArgs(args)
+	}
+
 	fixedManifest := android.PathForModuleOut(ctx, "manifest_fixer", "AndroidManifest.xml")
 	argsMapper["args"] = strings.Join(args, " ")
 
+	// The warnings file isn't part of the returned arguments, which only describe the fixes.
+	var implicitOutputs android.WritablePaths
+	if manifestWarningsEnabled(ctx.Config()) {
+		warnings := manifestFixerWarningsFile(ctx)
+		argsMapper["args"] += " --warnings-output " + warnings.String()
+		implicitOutputs = append(implicitOutputs, warnings)
+	}
+
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        manifestFixerRule,
-		Description: "fix manifest",
-		Input:       manifest,
-		Implicits:   deps,
-		Output:      fixedManifest,
-		Args:        argsMapper,
+		Rule:            manifestFixerRule,
+		Description:     "fix manifest",
+		Input:           manifest,
+		Implicits:       deps,
+		Output:          fixedManifest,
+		ImplicitOutputs: implicitOutputs,
+		Args:            argsMapper,
+	})
+
+	return fixedManifest.WithoutRel(), args
+}
+
+// canonicalManifestFixerArgsEnabled returns true if the arguments of manifest_fixer.py should be
+// sorted into a canonical order, so that reordering the code that builds them doesn't change the
+// commands of the manifest fixer rules.  Opt-in, as enabling it changes the command of every rule.
+func canonicalManifestFixerArgsEnabled(config android.Config) bool {
+	return config.IsEnvTrue("SOONG_CANONICAL_MANIFEST_FIXER_ARGS")
+}
+
+// canonicalManifestFixerArgs returns args with the flags of manifest_fixer.py stably sorted by
+// name, each flag followed by the values that followed it in args.  manifest_fixer.py applies its
+// fixes in a fixed order whatever the order of its flags, and the occurrences of a repeated flag
+// keep their relative order, so the sorted arguments fix the manifest the same way.
+func canonicalManifestFixerArgs(args []string) []string {
+	var groups [][]string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") || len(groups) == 0 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], arg)
+	}
+
+	flagName := func(group []string) string {
+		name, _, _ := strings.Cut(strings.TrimSpace(group[0]), "=")
+		return name
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return flagName(groups[i]) < flagName(groups[j])
+	})
+
+	ret := make([]string, 0, len(args))
+	for _, group := range groups {
+		ret = append(ret, group...)
+	}
+	return ret
+}
+
+// manifestFixerWarningsFile returns the file that manifest_fixer.py writes the warnings about the
+// manifest of the module to when manifest warnings are collected.
+func manifestFixerWarningsFile(ctx android.ModuleContext) android.WritablePath {
+	return android.PathForModuleOut(ctx, "manifest_fixer", "warnings.txt")
+}
+
+// manifestPostMergeFixerWarningsFile returns the file that manifest_fixer.py writes the warnings
+// about the merged manifest of the module to when manifest warnings are collected.
+func manifestPostMergeFixerWarningsFile(ctx android.ModuleContext) android.WritablePath {
+	return android.PathForModuleOut(ctx, "manifest_fixer_post_merge", "warnings.txt")
+}
+
+// manifestWarningsFile returns the file holding all the warnings that manifest_fixer.py printed
+// about the manifest of the module, including those of the post-merge run if there was one.
+func manifestWarningsFile(ctx android.ModuleContext, postMerge bool) android.Path {
+	warnings := manifestFixerWarningsFile(ctx)
+	if !postMerge {
+		return warnings
+	}
+	merged := android.PathForModuleOut(ctx, "manifest_warnings", "warnings.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.Cat,
+		Description: "merge manifest warnings",
+		Inputs:      android.Paths{warnings, manifestPostMergeFixerWarningsFile(ctx)},
+		Output:      merged,
+	})
+	return merged
+}
+
+// manifestPostMergeFixer uses manifest_fixer.py to apply the fixes that must see the entries
+// contributed by static libraries to the merged AndroidManifest.xml of an app.  It returns the
+// input manifest unchanged if there is nothing to fix, and the arguments that are passed to
+// manifest_fixer.py.
+func manifestPostMergeFixer(ctx android.ModuleContext, manifest android.Path,
+	params ManifestFixerParams) (android.Path, []string) {
+	var args []string
+
+	for _, name := range android.FirstUniqueStrings(params.RemoveMetaData) {
+		args = append(args, "--remove-meta-data", name)
+	}
+
+	if params.ExportLauncherActivities {
+		args = append(args, "--export-launcher-activities")
+	}
+
+	if params.ExportBroadcastReceivers {
+		args = append(args, "--export-broadcast-receivers")
+		for _, broadcast := range ctx.Config().ManifestProtectedBroadcasts() {
+			args = append(args, "--protected-broadcast", broadcast)
+		}
+	}
+
+	if params.ClearTaskAffinity != "" {
+		args = append(args, "--clear-task-affinity", params.ClearTaskAffinity)
+	}
+
+	for _, grant := range params.UriPermissionGrants {
+		args = append(args, "--grant-uri-permission",
+			proptools.ShellEscape(grant.Provider+"="+grant.Attr+":"+grant.Value))
+	}
+
+	for _, component := range android.SortedUniqueStrings(params.DisabledComponents) {
+		args = append(args, "--disable-component", component)
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityHardwareAccelerated) {
+		args = append(args, "--activity-hardware-accelerated",
+			fmt.Sprintf("%s=%v", name, params.ActivityHardwareAccelerated[name]))
+	}
+
+	for _, name := range android.SortedKeys(params.ComponentDirectBootAware) {
+		args = append(args, "--component-direct-boot-aware",
+			fmt.Sprintf("%s=%v", name, params.ComponentDirectBootAware[name]))
+	}
+
+	for _, name := range android.SortedKeys(params.ComponentLabel) {
+		args = append(args, "--component-label", name+"="+params.ComponentLabel[name])
+	}
+
+	for _, name := range android.SortedKeys(params.ComponentDescription) {
+		args = append(args, "--component-description", name+"="+params.ComponentDescription[name])
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityResizeableActivity) {
+		args = append(args, "--activity-resizeable-activity",
+			fmt.Sprintf("%s=%v", name, params.ActivityResizeableActivity[name]))
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityOnBackInvokedCallback) {
+		args = append(args, "--activity-on-back-invoked-callback",
+			fmt.Sprintf("%s=%v", name, params.ActivityOnBackInvokedCallback[name]))
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityUiOptions) {
+		args = append(args, "--activity-ui-options", name+"="+params.ActivityUiOptions[name])
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityTheme) {
+		args = append(args, "--activity-theme", name+"="+params.ActivityTheme[name])
+	}
+
+	for _, name := range android.SortedKeys(params.ActivityWindowAttributes) {
+		attrs := params.ActivityWindowAttributes[name]
+		for _, attr := range android.SortedKeys(attrs) {
+			args = append(args, "--activity-window-attribute",
+				proptools.ShellEscape(name+"="+attr+"="+attrs[attr]))
+		}
+	}
+
+	for _, name := range android.SortedKeys(params.UsesPermissionFlags) {
+		args = append(args, "--uses-permission-flags", name+"="+params.UsesPermissionFlags[name])
+	}
+	if len(params.UsesPermissionFlags) > 0 && params.AddMissingUsesPermissions {
+		args = append(args, "--add-missing-uses-permissions")
+	}
+
+	for _, modification := range params.PermissionModifications {
+		args = append(args, "--modify-uses-permission",
+			proptools.ShellEscape(modification.Name+"="+modification.MaxSdkVersion+":"+modification.Flags))
+	}
+	for _, permission := range params.NeverForLocationPermissions {
+		args = append(args, "--modify-uses-permission",
+			proptools.ShellEscape(permission+"=:neverForLocation"))
+	}
+
+	if params.CanonicalizeNamespaces {
+		args = append(args, "--canonicalize-namespaces")
+	}
+
+	if params.StripAllToolsAttributes {
+		args = append(args, "--strip-tools-attributes")
+	}
+
+	if len(args) == 0 {
+		return manifest, nil
+	}
+
+	if canonicalManifestFixerArgsEnabled(ctx.Config()) {
+		args = canonicalManifestFixerArgs(args)
+	}
+
+	fixedManifest := android.PathForModuleOut(ctx, "manifest_fixer_post_merge", "AndroidManifest.xml")
+	argsMapper := map[string]string{
+		"args": strings.Join(args, " "),
+	}
+
+	// As for the pre-merge fixes, the warnings file isn't part of the returned arguments.
+	var implicitOutputs android.WritablePaths
+	if manifestWarningsEnabled(ctx.Config()) {
+		warnings := manifestPostMergeFixerWarningsFile(ctx)
+		argsMapper["args"] += " --warnings-output " + warnings.String()
+		implicitOutputs = append(implicitOutputs, warnings)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            manifestFixerRule,
+		Description:     "fix merged manifest",
+		Input:           manifest,
+		Output:          fixedManifest,
+		ImplicitOutputs: implicitOutputs,
+		Args:            argsMapper,
 	})
 
-	return fixedManifest.WithoutRel()
+	return fixedManifest.WithoutRel(), args
+}
+
+// ManifestMetadataInfo is provided by modules whose AndroidManifest.xml is built by the manifest
+// pipeline, so that consumers don't need to parse the manifest or know how the build system
+// derives its values.
+type ManifestMetadataInfo struct {
+	// The merged and fixed manifest.
+	Manifest android.Path
+
+	// The package of the final app when it differs from the package declared in Manifest, i.e.
+	// after aapt2 renames it or the manifest merger applies an applicationId.  Empty otherwise.
+	PackageName string
+
+	// Whether the manifest belongs to a library, in which case it is merged into the manifests of
+	// the apps that use it.
+	IsLibrary bool
+
+	// Whether the module is a test app, or the manifest fixer marks its manifest android:testOnly.
+	TestOnly bool
+
+	// The name of the app that this app is a split of, or empty if it is a base app.
+	SplitOf string
+
+	// The minSdkVersion and targetSdkVersion that the manifest fixer injects, and the SDK version the
+	// module is compiled against.  Empty if the module has no SDK context.
+	MinSdkVersion     string
+	TargetSdkVersion  string
+	CompileSdkVersion string
+
+	// A file holding the fully qualified android:name of <application> in the final manifest of an
+	// app, or nothing if it declares none.  Nil for libraries.
+	ApplicationClass android.Path
+
+	// A file holding the warnings that manifest_fixer.py printed about the manifest, one per line,
+	// or nil if manifest warnings are not collected.
+	WarningsFile android.Path
+
+	// The required and optional <uses-library> tags added by the manifest fixer.
+	UsesLibraries         []string
+	OptionalUsesLibraries []string
+
+	// The arguments passed to manifest_fixer.py, in order, for the manifest of the module and for
+	// the merged manifest.  Values that are computed when the manifest is built, e.g. API
+	// fingerprints, appear as the shell expressions that compute them.
+	FixerArgs          []string
+	PostMergeFixerArgs []string
+}
+
+var ManifestMetadataInfoProvider = blueprint.NewProvider[ManifestMetadataInfo]()
+
+// manifestMetadata returns the ManifestMetadataInfo for a manifest fixed with the given params.
+// Errors in the SDK versions are reported by ManifestFixer and are ignored here.
+func manifestMetadata(ctx android.ModuleContext, manifest android.Path,
+	params ManifestFixerParams) ManifestMetadataInfo {
+
+	info := ManifestMetadataInfo{
+		Manifest:  manifest,
+		IsLibrary: params.IsLibrary,
+		TestOnly:  params.TestOnly,
+	}
+	if _, ok := ctx.Module().(androidTestApp); ok {
+		info.TestOnly = true
+	}
+	if params.SdkContext != nil {
+		info.TargetSdkVersion = targetSdkVersionForManifestFixer(ctx, params)
+		info.MinSdkVersion, _ = params.SdkContext.MinSdkVersion(ctx).EffectiveVersionString(ctx)
+		info.CompileSdkVersion = params.SdkContext.SdkVersion(ctx).ApiLevel.String()
+		if params.NumericSdkVersions {
+			info.TargetSdkVersion = numericSdkVersionForManifestFixer(ctx, "targetSdkVersion",
+				params.SdkContext.TargetSdkVersion(ctx))
+			info.MinSdkVersion = numericSdkVersionForManifestFixer(ctx, "minSdkVersion",
+				params.SdkContext.MinSdkVersion(ctx))
+		}
+	}
+	if params.ClassLoaderContexts != nil {
+		info.UsesLibraries, info.OptionalUsesLibraries = params.ClassLoaderContexts.UsesLibs()
+	}
+	return info
+}
+
+// ManifestCheckParams holds the checks that manifest_check.py performs on the merged manifest of
+// an app.
+type ManifestCheckParams struct {
+	// The package that the <instrumentation> tags of a test must target, or the manifest of the app
+	// that declares it.  Used for tests that set instrumentation_for.
+	InstrumentationTargetPackage  string
+	InstrumentationTargetManifest android.Path
+
+	// The attributes that the <instrumentation> tags of a test must declare, mapped to their
+	// expected value, or to "" if any value is accepted.
+	RequiredInstrumentationAttributes map[string]string
+
+	// Whether exported components guarded by a permission declared in the manifest must use a
+	// signature protection level.
+	EnforceSignatureProtectedComponents bool
+
+	// Whether launcher activities must declare android:exported.
+	CheckLauncherActivitiesExported bool
+
+	// Whether receivers for broadcasts that aren't protected must declare android:exported.
+	CheckBroadcastReceiversExported bool
+
+	// Whether providers must declare android:authorities.
+	CheckProviderAuthorities bool
+
+	// Whether FileProviders granting URI permissions must declare their paths.
+	CheckFileProviderPaths bool
+
+	// Whether components gated behind a feature flag must only be present when the product
+	// enables the flag.
+	CheckComponentFeatureFlags bool
+
+	// Whether to warn about intent filters that compete with the same priority.
+	CheckIntentFilterPriorities bool
+
+	// Whether exported services must be guarded by a permission, and the services that are allowed
+	// not to be.
+	CheckExportedServicePermissions bool
+	OpenExportedServices            []string
+
+	// Whether exported providers must be guarded by permissions, and the providers that are allowed
+	// not to be.
+	CheckExportedProviderPermissions bool
+	OpenExportedProviders            []string
+
+	// Whether the elements of the manifest must be nested in their expected parents.
+	CheckStructure bool
+
+	// Whether the manifest must not combine a shared user id with a sandbox version of 2 or higher.
+	CheckTargetSandboxVersion bool
+
+	// The maximum number of permissions that the merged manifest may request, or nil if it isn't
+	// checked.
+	UsesPermissionBudget *int
+
+	// The targetSdkVersion that the merged manifest must declare, or empty if it isn't checked.
+	TargetSdkVersion string
+
+	// The minSdkVersion that the merged manifest must declare, or empty if it isn't checked.
+	MinSdkVersion string
+
+	// The package that the merged manifest must declare, or empty if it isn't checked.
+	ExpectedPackage string
+
+	// A manifest that the merged manifest must match in canonical form.
+	GoldenManifest android.Path
+}
+
+// manifestPostMergeCheck uses manifest_check.py to validate the merged AndroidManifest.xml of an
+// app.  It returns the checked manifest, or the input manifest if there is nothing to check.
+func manifestPostMergeCheck(ctx android.ModuleContext, manifest android.Path,
+	params ManifestCheckParams) android.Path {
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("manifest_check")
+	hasChecks := false
+
+	if params.TargetSdkVersion != "" {
+		cmd.FlagWithArg("--expected-target-sdk-version ", params.TargetSdkVersion)
+		hasChecks = true
+	}
+
+	if params.MinSdkVersion != "" {
+		cmd.FlagWithArg("--expected-min-sdk-version ", params.MinSdkVersion)
+		hasChecks = true
+	}
+
+	if params.ExpectedPackage != "" {
+		cmd.FlagWithArg("--expected-package ", params.ExpectedPackage)
+		hasChecks = true
+	}
+
+	if params.InstrumentationTargetPackage != "" {
+		cmd.FlagWithArg("--instrumentation-target-package ", params.InstrumentationTargetPackage)
+		hasChecks = true
+	} else if params.InstrumentationTargetManifest != nil {
+		cmd.FlagWithInput("--instrumentation-target-manifest ", params.InstrumentationTargetManifest)
+		hasChecks = true
+	}
+
+	for _, attr := range android.SortedKeys(params.RequiredInstrumentationAttributes) {
+		if value := params.RequiredInstrumentationAttributes[attr]; value != "" {
+			attr += "=" + value
+		}
+		cmd.FlagWithArg("--required-instrumentation-attribute ", proptools.ShellEscape(attr))
+		hasChecks = true
+	}
+
+	if params.EnforceSignatureProtectedComponents {
+		cmd.Flag("--enforce-signature-protected-components")
+		hasChecks = true
+	}
+
+	if params.CheckLauncherActivitiesExported {
+		cmd.Flag("--check-launcher-activities-exported")
+		hasChecks = true
+	}
+
+	if params.CheckBroadcastReceiversExported {
+		cmd.Flag("--check-broadcast-receivers-exported")
+		for _, broadcast := range ctx.Config().ManifestProtectedBroadcasts() {
+			cmd.FlagWithArg("--protected-broadcast ", broadcast)
+		}
+		hasChecks = true
+	}
+
+	if params.CheckProviderAuthorities {
+		cmd.Flag("--check-provider-authorities")
+		hasChecks = true
+	}
+
+	if params.CheckFileProviderPaths {
+		cmd.Flag("--check-file-provider-paths")
+		hasChecks = true
+	}
+
+	if params.CheckComponentFeatureFlags {
+		cmd.Flag("--check-component-feature-flags")
+		for _, flag := range ctx.Config().ManifestEnabledFeatureFlags() {
+			cmd.FlagWithArg("--enabled-feature-flag ", flag)
+		}
+		hasChecks = true
+	}
+
+	if params.CheckIntentFilterPriorities {
+		cmd.Flag("--check-intent-filter-priorities")
+		hasChecks = true
+	}
+
+	if params.CheckExportedServicePermissions {
+		cmd.Flag("--check-exported-service-permissions")
+		for _, service := range params.OpenExportedServices {
+			cmd.FlagWithArg("--open-exported-service ", service)
+		}
+		hasChecks = true
+	}
+
+	if params.CheckExportedProviderPermissions {
+		cmd.Flag("--check-exported-provider-permissions")
+		for _, provider := range params.OpenExportedProviders {
+			cmd.FlagWithArg("--open-exported-provider ", provider)
+		}
+		hasChecks = true
+	}
+
+	if params.CheckStructure {
+		cmd.Flag("--check-structure")
+		hasChecks = true
+	}
+
+	if params.CheckTargetSandboxVersion {
+		cmd.Flag("--check-target-sandbox-version")
+		hasChecks = true
+	}
+
+	if params.UsesPermissionBudget != nil {
+		cmd.FlagWithArg("--uses-permission-budget ", strconv.Itoa(*params.UsesPermissionBudget))
+		hasChecks = true
+	}
+
+	if deprecated := ctx.Config().ManifestDeprecatedPermissions(); len(deprecated) > 0 {
+		for _, entry := range deprecated {
+			permission, replacement, found := strings.Cut(entry, ":")
+			if !found || permission == "" || replacement == "" {
+				ctx.ModuleErrorf("invalid ManifestDeprecatedPermissions entry %q, must be "+
+					"\"<permission>:<replacement>\"", entry)
+				continue
+			}
+			cmd.FlagWithArg("--deprecated-permission ", permission+"="+replacement)
+		}
+		if ctx.Config().ManifestDeprecatedPermissionsWarnOnly() {
+			cmd.Flag("--deprecated-permissions-warn-only")
+		}
+		hasChecks = true
+	}
+
+	if params.GoldenManifest != nil {
+		cmd.FlagWithInput("--golden-manifest ", params.GoldenManifest)
+		hasChecks = true
+	}
+
+	if threshold := ctx.Config().ManifestSharedUserIdWarnTargetSdkVersion(); threshold != nil {
+		cmd.FlagWithArg("--shared-user-id-warn-target-sdk-version ", strconv.Itoa(*threshold)).
+			FlagWithArg("--module-name ", ctx.ModuleName())
+		hasChecks = true
+	}
+
+	if !hasChecks {
+		return manifest
+	}
+
+	checkedManifest := android.PathForModuleOut(ctx, "manifest_post_merge_check", "AndroidManifest.xml")
+	cmd.FlagWithOutput("-o ", checkedManifest).Input(manifest)
+	rule.Build("manifest_post_merge_check", "check merged manifest")
+
+	return checkedManifest
+}
+
+// manifestFingerprint writes a hash of the canonical form of manifest to a file.  Manifests that
+// only differ in formatting have the same fingerprint.
+func manifestFingerprint(ctx android.ModuleContext, manifest android.Path) android.Path {
+	fingerprint := android.PathForModuleOut(ctx, "manifest_fingerprint", "fingerprint.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithOutput("--fingerprint ", fingerprint).
+		Input(manifest)
+	rule.Build("manifest_fingerprint", "manifest fingerprint")
+
+	return fingerprint
+}
+
+// checkManifestIconResources uses manifest_check.py to verify that the icon resources referenced
+// by the final manifest of an app are in the R.txt produced when its resources were linked, and
+// returns a stamp file to be used as a dependency of the APK.
+func checkManifestIconResources(ctx android.ModuleContext, manifest, rTxt android.Path) android.Path {
+	stamp := android.PathForModuleOut(ctx, "manifest_icon_resources", "check.stamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		Flag("--check-icon-resources").
+		FlagWithInput("--r-txt ", rTxt).
+		Input(manifest)
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build("manifest_icon_resources", "check manifest icon resources")
+
+	return stamp
+}
+
+// checkManifestOverlayable uses manifest_check.py to verify that the values resources of an app
+// declare an <overlayable> and that the package of manifest, or appPackage if the package is
+// renamed, is the package that overlays target.
+func checkManifestOverlayable(ctx android.ModuleContext, manifest android.Path, resourceFiles android.Paths,
+	overlayablePackage, appPackage string) android.Path {
+
+	var valuesFiles android.Paths
+	for _, file := range resourceFiles {
+		if file.Ext() == ".xml" && strings.HasPrefix(filepath.Base(filepath.Dir(file.String())), "values") {
+			valuesFiles = append(valuesFiles, file)
+		}
+	}
+
+	stamp := android.PathForModuleOut(ctx, "manifest_overlayable", "check.stamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("manifest_check").
+		FlagWithArg("--overlayable-package ", overlayablePackage).
+		FlagForEachInput("--resource-file ", valuesFiles)
+	if appPackage != "" {
+		cmd.FlagWithArg("--app-package ", appPackage)
+	}
+	cmd.Input(manifest)
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build("manifest_overlayable", "check overlayable package")
+
+	return stamp
 }
 
 type ManifestMergerParams struct {
 	staticLibManifests android.Paths
 	isLibrary          bool
 	packageName        string
+	checkLibPackages   bool
+	allowedLibPackages []string
+
+	checkDuplicateComponents bool
+}
+
+// checkLibManifestPackages uses manifest_check.py to verify that the static library manifests
+// declare the same package as the main manifest, and returns a stamp file to be used as a
+// validation of the merge.
+func checkLibManifestPackages(ctx android.ModuleContext, manifest android.Path,
+	params ManifestMergerParams) android.Path {
+
+	stamp := android.PathForModuleOut(ctx, "manifest_merger", "lib_packages.stamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("manifest_check").
+		Flag("--check-lib-packages").
+		FlagForEachInput("--lib-manifest ", params.staticLibManifests).
+		FlagForEachArg("--allowed-lib-package ", android.SortedUniqueStrings(params.allowedLibPackages))
+	if params.packageName != "" {
+		cmd.FlagWithArg("--app-package ", params.packageName)
+	}
+	cmd.Input(manifest)
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build("manifest_lib_packages", "check static library manifest packages")
+
+	return stamp
+}
+
+// checkDuplicateManifestComponents uses manifest_check.py to verify that no component is declared
+// by more than one of the manifests that are merged, and returns a stamp file to be used as a
+// validation of the merge.
+func checkDuplicateManifestComponents(ctx android.ModuleContext, manifest android.Path,
+	params ManifestMergerParams) android.Path {
+
+	stamp := android.PathForModuleOut(ctx, "manifest_merger", "duplicate_components.stamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		Flag("--check-duplicate-components").
+		FlagForEachInput("--lib-manifest ", params.staticLibManifests).
+		Input(manifest)
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build("manifest_duplicate_components", "check duplicate manifest components")
+
+	return stamp
+}
+
+// manifestLibPackages uses manifest_check.py to write the packages declared by the static library
+// manifests that are merged into manifest, other than the package of manifest itself, to a file
+// as aapt2 --extra-packages flags.
+func manifestLibPackages(ctx android.ModuleContext, manifest android.Path,
+	params ManifestMergerParams) android.Path {
+
+	libPackages := android.PathForModuleOut(ctx, "manifest_merger", "lib_packages.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("manifest_check").
+		FlagForEachInput("--lib-manifest ", params.staticLibManifests).
+		FlagWithOutput("--lib-packages-output ", libPackages)
+	if params.packageName != "" {
+		cmd.FlagWithArg("--app-package ", params.packageName)
+	}
+	cmd.Input(manifest)
+	rule.Build("manifest_lib_packages_list", "list static library manifest packages")
+
+	return libPackages
+}
+
+// manifestApplicationClass uses manifest_check.py to write the fully qualified android:name of
+// <application> in manifest to a file.
+func manifestApplicationClass(ctx android.ModuleContext, manifest android.Path) android.Path {
+	applicationClass := android.PathForModuleOut(ctx, "manifest_application_class", "application_class.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithOutput("--application-class-output ", applicationClass).
+		Input(manifest)
+	rule.Build("manifest_application_class", "extract manifest application class")
+
+	return applicationClass
 }
 
+// manifestComponentInventory uses manifest_check.py to write a JSON list of the components
+// declared by manifest with their exported status to a file.
+func manifestComponentInventory(ctx android.ModuleContext, manifest android.Path) android.Path {
+	inventory := android.PathForModuleOut(ctx, "manifest_component_inventory", "component_inventory.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithOutput("--component-inventory-output ", inventory).
+		Input(manifest)
+	rule.Build("manifest_component_inventory", "list manifest components")
+
+	return inventory
+}
+
+// manifestCompatReport uses manifest_check.py to write a JSON report of the permissions,
+// components and SDK versions of manifest that changed relative to baseline.
+func manifestCompatReport(ctx android.ModuleContext, manifest, baseline android.Path) android.Path {
+	report := android.PathForModuleOut(ctx, "manifest_compat_report", "compat_report.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithInput("--baseline-manifest ", baseline).
+		FlagWithOutput("--compat-report-output ", report).
+		Input(manifest)
+	rule.Build("manifest_compat_report", "compare manifest with baseline")
+
+	return report
+}
+
+// manifestMergeChanges uses manifest_check.py to write a JSON list of the nodes and attributes of
+// mergedManifest that were added or overridden relative to manifest, the main manifest passed to
+// the manifest merger.
+func manifestMergeChanges(ctx android.ModuleContext, manifest, mergedManifest android.Path) android.Path {
+	changes := android.PathForModuleOut(ctx, "manifest_merger", "merge_changes.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithInput("--unmerged-manifest ", manifest).
+		FlagWithOutput("--merge-changes-output ", changes).
+		Input(mergedManifest)
+	rule.Build("manifest_merge_changes", "list manifest merge changes")
+
+	return changes
+}
+
+// manifestLibPermissions uses manifest_check.py to write a copy of mergedManifest in which the
+// <uses-permission> tags that manifest, the main manifest passed to the manifest merger, doesn't
+// request are moved into a section annotated with the static library manifests requesting them.
+func manifestLibPermissions(ctx android.ModuleContext, manifest, mergedManifest android.Path,
+	staticLibManifests android.Paths) android.Path {
+
+	sectioned := android.PathForModuleOut(ctx, "manifest_merger", "lib_permissions.xml")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("manifest_check").
+		FlagWithInput("--unmerged-manifest ", manifest).
+		FlagForEachInput("--lib-manifest ", staticLibManifests).
+		FlagWithOutput("--lib-permissions-output ", sectioned).
+		Input(mergedManifest)
+	rule.Build("manifest_lib_permissions", "section static library manifest permissions")
+
+	return sectioned
+}
+
+// manifestMerger merges the manifests of the static libraries into the manifest of the module.
+// manifest-merger has no mode that annotates the merged nodes with the manifest they came from, so
+// the merged manifest doesn't record provenance; check_duplicate_manifest_components reports which
+// manifests declare a duplicated component.
 func manifestMerger(ctx android.ModuleContext, manifest android.Path,
 	params ManifestMergerParams) android.Path {
 
@@ -222,12 +2798,21 @@ func manifestMerger(ctx android.ModuleContext, manifest android.Path,
 		args = append(args, "--property PACKAGE="+packageName)
 	}
 
+	var validations android.Paths
+	if params.checkLibPackages {
+		validations = append(validations, checkLibManifestPackages(ctx, manifest, params))
+	}
+	if params.checkDuplicateComponents {
+		validations = append(validations, checkDuplicateManifestComponents(ctx, manifest, params))
+	}
+
 	mergedManifest := android.PathForModuleOut(ctx, "manifest_merger", "AndroidManifest.xml")
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        manifestMergerRule,
 		Description: "merge manifest",
 		Input:       manifest,
 		Implicits:   params.staticLibManifests,
+		Validations: validations,
 		Output:      mergedManifest,
 		Args: map[string]string{
 			"libs": android.JoinWithPrefix(params.staticLibManifests.Strings(), "--libs "),
@@ -0,0 +1,265 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func registerAndroidManifestSingleton(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("android_manifest", androidManifestSingletonFactory)
+}
+
+// manifestFingerprintsEnabled returns true if apps should write a fingerprint of their final
+// AndroidManifest.xml, which are collected into a build-wide report.
+func manifestFingerprintsEnabled(config android.Config) bool {
+	return config.IsEnvTrue("SOONG_MANIFEST_FINGERPRINTS")
+}
+
+// manifestWarningsEnabled returns true if manifest_fixer.py should write the warnings about the
+// manifests of apps and libraries to files, which are collected into a build-wide report.
+func manifestWarningsEnabled(config android.Config) bool {
+	return config.IsEnvTrue("SOONG_MANIFEST_WARNINGS")
+}
+
+// manifestFingerprintProvider is implemented by modules that may write a fingerprint of their
+// final AndroidManifest.xml.
+type manifestFingerprintProvider interface {
+	manifestFingerprintFile() android.OptionalPath
+}
+
+// androidManifestSingleton generates the build-wide reports about the AndroidManifest.xml files
+// of the apps in the build.
+type androidManifestSingleton struct{}
+
+func androidManifestSingletonFactory() android.Singleton {
+	return &androidManifestSingleton{}
+}
+
+func (s *androidManifestSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if manifestFingerprintsEnabled(ctx.Config()) {
+		s.buildManifestFingerprints(ctx)
+	}
+	if manifestWarningsEnabled(ctx.Config()) {
+		s.buildManifestWarnings(ctx)
+	}
+	if ctx.Config().ManifestRejectPreviewTargetSdkInUserBuilds() && !ctx.Config().Debuggable() {
+		s.checkPreviewTargetSdkVersions(ctx)
+	}
+	if ctx.Config().ManifestRejectTestOnlyProductPackagesInUserBuilds() && !ctx.Config().Debuggable() {
+		s.checkTestOnlyProductPackages(ctx)
+	}
+	s.checkSplitSdkVersions(ctx)
+}
+
+// checkSplitSdkVersions fails the build if the splits of an app, e.g. its feature splits, declare
+// a minSdkVersion, targetSdkVersion or SDK version that differs from that of the base app or of
+// the other splits.  Package manager refuses to install such a set of APKs.
+func (s *androidManifestSingleton) checkSplitSdkVersions(ctx android.SingletonContext) {
+	sdkVersions := make(map[string]string)
+	splits := make(map[string][]string)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, ManifestMetadataInfoProvider)
+		if !ok || info.IsLibrary {
+			return
+		}
+		name := ctx.ModuleName(module)
+		sdkVersions[name] = fmt.Sprintf("minSdkVersion %s, targetSdkVersion %s, compileSdkVersion %s",
+			info.MinSdkVersion, info.TargetSdkVersion, info.CompileSdkVersion)
+		if info.SplitOf != "" {
+			splits[info.SplitOf] = append(splits[info.SplitOf], name)
+		}
+	})
+
+	for _, base := range android.SortedKeys(splits) {
+		members := android.SortedUniqueStrings(splits[base])
+		if _, ok := sdkVersions[base]; ok {
+			members = append([]string{base}, members...)
+		}
+		consistent := true
+		for _, member := range members[1:] {
+			if sdkVersions[member] != sdkVersions[members[0]] {
+				consistent = false
+			}
+		}
+		if consistent {
+			continue
+		}
+		var lines []string
+		for _, member := range members {
+			lines = append(lines, member+": "+sdkVersions[member])
+		}
+		ctx.Errorf("splits of app %q must declare the same SDK versions as the app:\n    %s",
+			base, strings.Join(lines, "\n    "))
+	}
+}
+
+// checkTestOnlyProductPackages fails a user build if the product installs a test-only app, either
+// a test app or an app whose manifest is marked android:testOnly.  The packages the product
+// installs are only known to Make, which lists them in product_packages.txt, so the check is a
+// rule that droidcore depends on.
+func (s *androidManifestSingleton) checkTestOnlyProductPackages(ctx android.SingletonContext) {
+	var testOnly []string
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, ManifestMetadataInfoProvider)
+		if ok && !info.IsLibrary && info.TestOnly {
+			testOnly = append(testOnly, ctx.ModuleName(module))
+		}
+	})
+	if len(testOnly) == 0 {
+		return
+	}
+
+	// The same product_packages.txt that dexpreopt reads, see dexpreopter.dexpreopt.
+	productPackages := android.PathForArbitraryOutput(ctx, "target", "product",
+		ctx.Config().DeviceName(), "product_packages.txt")
+	timestamp := android.PathForOutput(ctx, "manifest_test_only_product_packages.stamp")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("if grep -F -x").
+		FlagForEachArg("-e ", android.SortedUniqueStrings(testOnly)).
+		Input(productPackages).
+		Text("; then echo").
+		Text(proptools.ShellEscape("error: the test-only apps above must not be installed by user " +
+			"builds, remove them from PRODUCT_PACKAGES")).
+		Text(">&2; exit 1; fi")
+	rule.Command().Text("touch").Output(timestamp)
+	rule.Build("manifest_test_only_product_packages", "check test-only product packages")
+
+	ctx.Phony("check-manifest-test-only-product-packages", timestamp)
+	ctx.Phony("droidcore", android.PathForPhony(ctx, "check-manifest-test-only-product-packages"))
+}
+
+// isPreviewTargetSdkVersion returns true if targetSdkVersion is the codename of an unreleased SDK,
+// "current" while the platform SDK is not final, or the 10000 placeholder used for modules
+// targeting an unreleased SDK.  An unset targetSdkVersion is not a preview.
+func isPreviewTargetSdkVersion(config android.Config, targetSdkVersion string) bool {
+	switch targetSdkVersion {
+	case "":
+		return false
+	case "current":
+		return !config.PlatformSdkFinal()
+	case strconv.Itoa(android.FutureApiLevel.FinalOrFutureInt()):
+		return true
+	}
+	apiLevel, err := android.ApiLevelFromUserWithConfig(config, targetSdkVersion)
+	return err == nil && apiLevel.IsPreview()
+}
+
+// checkPreviewTargetSdkVersions fails a user build if the manifest of an app targets a preview
+// SDK.  Unbundled builds and apps that are part of MTS target preview SDKs by design, and are
+// exempt.
+func (s *androidManifestSingleton) checkPreviewTargetSdkVersions(ctx android.SingletonContext) {
+	if ctx.Config().UnbundledBuildApps() {
+		return
+	}
+
+	allowlist := ctx.Config().ManifestPreviewTargetSdkAllowlist()
+	var offenders []string
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, ManifestMetadataInfoProvider)
+		if !ok || info.IsLibrary || !isPreviewTargetSdkVersion(ctx.Config(), info.TargetSdkVersion) {
+			return
+		}
+		name := ctx.ModuleName(module)
+		if android.InList(name, allowlist) || includedInMts(module) {
+			return
+		}
+		offenders = append(offenders, name+" (targetSdkVersion "+info.TargetSdkVersion+")")
+	})
+
+	if len(offenders) > 0 {
+		offenders = android.SortedUniqueStrings(offenders)
+		ctx.Errorf("apps in user builds must not target a preview SDK, add them to "+
+			"ManifestPreviewTargetSdkAllowlist if it is intentional:\n    %s",
+			strings.Join(offenders, "\n    "))
+	}
+}
+
+// buildManifestFingerprints collects the manifest fingerprints of all apps into a report that
+// lists one "<module> <fingerprint>" line per app.  Comparing the reports of two builds shows
+// which manifests have changed.
+func (s *androidManifestSingleton) buildManifestFingerprints(ctx android.SingletonContext) {
+	fingerprints := make(map[string]android.Path)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		if m, ok := module.(manifestFingerprintProvider); ok {
+			if fingerprint := m.manifestFingerprintFile(); fingerprint.Valid() {
+				fingerprints[ctx.ModuleName(module)] = fingerprint.Path()
+			}
+		}
+	})
+
+	names := android.SortedKeys(fingerprints)
+
+	report := android.PathForOutput(ctx, "manifest_fingerprints.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().Text("rm -f").Output(report)
+	for _, name := range names {
+		rule.Command().
+			Text("echo").Text(name).
+			Text("$(cat").Input(fingerprints[name]).Text(")").
+			Text(">>").Text(report.String())
+	}
+	rule.Build("manifest_fingerprints", "manifest fingerprints")
+
+	ctx.Phony("manifest_fingerprints", report)
+}
+
+// buildManifestWarnings collects the warnings that manifest_fixer.py printed about the manifests
+// of all apps and libraries into a report that lists one "<module>: <warning>" line per warning,
+// so that manifest hygiene can be tracked across builds.
+func (s *androidManifestSingleton) buildManifestWarnings(ctx android.SingletonContext) {
+	warnings := make(map[string]android.Path)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+		info, ok := android.SingletonModuleProvider(ctx, module, ManifestMetadataInfoProvider)
+		if ok && info.WarningsFile != nil {
+			warnings[ctx.ModuleName(module)] = info.WarningsFile
+		}
+	})
+
+	report := android.PathForOutput(ctx, "manifest_warnings.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().Text("rm -f").Output(report)
+	for _, name := range android.SortedKeys(warnings) {
+		rule.Command().
+			Text("sed").Textf("'s|^|%s: |'", name).Input(warnings[name]).
+			Text(">>").Text(report.String())
+	}
+	rule.Build("manifest_warnings", "manifest warnings")
+
+	ctx.Phony("manifest_warnings", report)
+}
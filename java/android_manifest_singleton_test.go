@@ -0,0 +1,337 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"testing"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func TestManifestFingerprints(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+			manifest: "foo/AndroidManifest.xml",
+		}
+
+		android_app {
+			name: "bar",
+			sdk_version: "current",
+			srcs: ["bar/bar.java"],
+			manifest: "bar/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_MANIFEST_FINGERPRINTS": "true",
+		}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common").Output("manifest_fingerprint/fingerprint.txt")
+	android.AssertStringDoesContain(t, "foo fingerprint command", foo.RuleParams.Command,
+		"out/soong/.intermediates/foo/android_common/manifest_fixer/AndroidManifest.xml")
+
+	report := result.SingletonForTests("android_manifest").Output("manifest_fingerprints.txt")
+	implicits := report.Implicits.RelativeToTop().Strings()
+	android.AssertStringListContains(t, "report inputs", implicits,
+		"out/soong/.intermediates/foo/android_common/manifest_fingerprint/fingerprint.txt")
+	android.AssertStringListContains(t, "report inputs", implicits,
+		"out/soong/.intermediates/bar/android_common/manifest_fingerprint/fingerprint.txt")
+}
+
+func TestManifestFingerprintsDisabled(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common").MaybeOutput("manifest_fingerprint/fingerprint.txt")
+	android.AssertBoolEquals(t, "fingerprint rule exists", false, foo.Rule != nil)
+	report := result.SingletonForTests("android_manifest").MaybeOutput("manifest_fingerprints.txt")
+	android.AssertBoolEquals(t, "report rule exists", false, report.Rule != nil)
+}
+
+func TestManifestWarnings(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+		}
+
+		android_library {
+			name: "bar",
+			sdk_version: "current",
+			srcs: ["bar/bar.java"],
+			manifest: "bar/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_MANIFEST_WARNINGS": "true",
+		}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "foo manifest fixer args", foo.Args["args"],
+		"--warnings-output out/soong/.intermediates/foo/android_common/manifest_fixer/warnings.txt")
+	android.AssertStringListContains(t, "foo manifest fixer outputs",
+		foo.ImplicitOutputs.RelativeToTop().Strings(),
+		"out/soong/.intermediates/foo/android_common/manifest_fixer/warnings.txt")
+
+	report := result.SingletonForTests("android_manifest").Output("manifest_warnings.txt")
+	implicits := report.Implicits.RelativeToTop().Strings()
+	android.AssertStringListContains(t, "report inputs", implicits,
+		"out/soong/.intermediates/foo/android_common/manifest_fixer/warnings.txt")
+	android.AssertStringListContains(t, "report inputs", implicits,
+		"out/soong/.intermediates/bar/android_common/manifest_fixer/warnings.txt")
+	android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "'s|^|bar: |'")
+}
+
+func TestManifestWarningsPostMerge(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+			resizeable_activities: ["com.android.foo.Main:false"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_MANIFEST_WARNINGS": "true",
+		}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	postMerge := foo.Output("manifest_fixer_post_merge/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "foo post-merge manifest fixer args", postMerge.Args["args"],
+		"--warnings-output out/soong/.intermediates/foo/android_common/manifest_fixer_post_merge/warnings.txt")
+	android.AssertStringListContains(t, "foo post-merge manifest fixer outputs",
+		postMerge.ImplicitOutputs.RelativeToTop().Strings(),
+		"out/soong/.intermediates/foo/android_common/manifest_fixer_post_merge/warnings.txt")
+
+	merged := foo.Output("manifest_warnings/warnings.txt")
+	android.AssertPathsRelativeToTopEquals(t, "foo merged warnings inputs",
+		[]string{
+			"out/soong/.intermediates/foo/android_common/manifest_fixer/warnings.txt",
+			"out/soong/.intermediates/foo/android_common/manifest_fixer_post_merge/warnings.txt",
+		},
+		merged.Inputs)
+
+	report := result.SingletonForTests("android_manifest").Output("manifest_warnings.txt")
+	android.AssertStringListContains(t, "report inputs", report.Implicits.RelativeToTop().Strings(),
+		"out/soong/.intermediates/foo/android_common/manifest_warnings/warnings.txt")
+}
+
+func TestManifestWarningsDisabled(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesNotContain(t, "foo manifest fixer args", foo.Args["args"], "--warnings-output")
+	report := result.SingletonForTests("android_manifest").MaybeOutput("manifest_warnings.txt")
+	android.AssertBoolEquals(t, "report rule exists", false, report.Rule != nil)
+}
+
+func TestManifestPreviewTargetSdkInUserBuild(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+		}
+
+		android_app {
+			name: "bar",
+			sdk_version: "current",
+			srcs: ["bar/bar.java"],
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+		}
+	`
+
+	userBuild := func(allowlist []string) android.FixturePreparer {
+		return android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Debuggable = proptools.BoolPtr(false)
+			variables.ManifestRejectPreviewTargetSdkInUserBuilds = proptools.BoolPtr(true)
+			// framework-res targets the platform SDK, which is a preview in tests.
+			variables.ManifestPreviewTargetSdkAllowlist = append([]string{"framework-res"}, allowlist...)
+		})
+	}
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		userBuild([]string{"bar"}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`must not target a preview SDK(.|\n)*foo \(targetSdkVersion S\)`,
+	})).RunTestWithBp(t, bp)
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		userBuild([]string{"foo", "bar"}),
+	).RunTestWithBp(t, bp)
+}
+
+func TestManifestUnsetTargetSdkInUserBuild(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["foo/foo.java"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Debuggable = proptools.BoolPtr(false)
+			variables.ManifestRejectPreviewTargetSdkInUserBuilds = proptools.BoolPtr(true)
+			variables.Platform_sdk_final = proptools.BoolPtr(true)
+			variables.Platform_sdk_codename = proptools.StringPtr("REL")
+			variables.Platform_sdk_version = proptools.IntPtr(30)
+			variables.Platform_version_active_codenames = nil
+		}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_common").Module()
+	info, _ := android.SingletonModuleProvider(result, foo, ManifestMetadataInfoProvider)
+	android.AssertStringEquals(t, "foo targetSdkVersion", "30", info.TargetSdkVersion)
+}
+
+func TestIsPreviewTargetSdkVersion(t *testing.T) {
+	// The platform SDK is not final in the test config, S and Tiramisu are active codenames.
+	config := android.TestConfig(t.TempDir(), nil, "", nil)
+
+	testCases := []struct {
+		targetSdkVersion string
+		expected         bool
+	}{
+		{targetSdkVersion: "", expected: false},
+		{targetSdkVersion: "30", expected: false},
+		{targetSdkVersion: "10000", expected: true},
+		{targetSdkVersion: "current", expected: true},
+		{targetSdkVersion: "S", expected: true},
+		{targetSdkVersion: "R", expected: false},
+	}
+
+	for _, test := range testCases {
+		android.AssertBoolEquals(t, fmt.Sprintf("isPreviewTargetSdkVersion(%q)", test.targetSdkVersion),
+			test.expected, isPreviewTargetSdkVersion(config, test.targetSdkVersion))
+	}
+}
+
+func TestManifestSplitSdkVersions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "base",
+			sdk_version: "current",
+			min_sdk_version: "29",
+			target_sdk_version: "33",
+			srcs: ["base/base.java"],
+		}
+
+		android_app {
+			name: "feature",
+			sdk_version: "current",
+			min_sdk_version: "29",
+			target_sdk_version: "%s",
+			srcs: ["feature/feature.java"],
+			feature_split_of: "base",
+		}
+	`
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`splits of app "base" must declare the same SDK versions(.|\n)*feature: minSdkVersion 29, targetSdkVersion 34`,
+	})).RunTestWithBp(t, fmt.Sprintf(bp, "34"))
+
+	PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, fmt.Sprintf(bp, "33"))
+}
+
+func TestManifestTestOnlyProductPackagesInUserBuild(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			sdk_version: "current",
+			srcs: ["foo/foo.java"],
+		}
+
+		android_test {
+			name: "foo_test",
+			sdk_version: "current",
+			srcs: ["foo/foo_test.java"],
+		}
+	`
+
+	userBuild := func(debuggable bool) android.FixturePreparer {
+		return android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Debuggable = proptools.BoolPtr(debuggable)
+			variables.ManifestRejectTestOnlyProductPackagesInUserBuilds = proptools.BoolPtr(true)
+		})
+	}
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		userBuild(false),
+	).RunTestWithBp(t, bp)
+
+	check := result.SingletonForTests("android_manifest").Output("manifest_test_only_product_packages.stamp")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"grep -F -x -e foo_test out/target/product/test_device/product_packages.txt")
+	android.AssertStringDoesNotContain(t, "check command", check.RuleParams.Command, "-e foo ")
+
+	info, _ := android.SingletonModuleProvider(result, result.ModuleForTests("foo_test", "android_common").Module(),
+		ManifestMetadataInfoProvider)
+	android.AssertBoolEquals(t, "foo_test is test-only", true, info.TestOnly)
+
+	result = android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		userBuild(true),
+	).RunTestWithBp(t, bp)
+	check = result.SingletonForTests("android_manifest").MaybeOutput("manifest_test_only_product_packages.stamp")
+	android.AssertBoolEquals(t, "check rule exists in debuggable builds", false, check.Rule != nil)
+
+	result = PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	check = result.SingletonForTests("android_manifest").MaybeOutput("manifest_test_only_product_packages.stamp")
+	android.AssertBoolEquals(t, "check rule exists without opt-in", false, check.Rule != nil)
+}
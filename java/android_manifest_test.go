@@ -15,9 +15,14 @@
 package java
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/google/blueprint/proptools"
+
 	"android/soong/android"
+	"android/soong/dexpreopt"
 )
 
 func TestManifestMerger(t *testing.T) {
@@ -133,3 +138,3660 @@ func TestManifestValuesApplicationIdSetsPackageName(t *testing.T) {
 		manifestMergerRule.Args["args"],
 		"--property PACKAGE=new_package_name")
 }
+
+func TestManifestFixerRemoveMetaData(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["direct"],
+			remove_meta_data: ["com.example.SDK_INIT", "com.example.ABSENT"],
+		}
+
+		android_library {
+			name: "direct",
+			sdk_version: "current",
+			srcs: ["direct/direct.java"],
+			manifest: "direct/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	postMergeFixer := app.Output("manifest_fixer_post_merge/AndroidManifest.xml")
+	android.AssertPathRelativeToTopEquals(t, "post merge fixer input",
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml",
+		postMergeFixer.Input)
+	android.AssertStringEquals(t, "post merge fixer args",
+		"--remove-meta-data com.example.SDK_INIT --remove-meta-data com.example.ABSENT",
+		postMergeFixer.Args["args"])
+	android.AssertStringDoesNotContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"], "--remove-meta-data")
+}
+
+func TestManifestFixerAllowBackup(t *testing.T) {
+	testCases := []struct {
+		name          string
+		allowBackup   string
+		allowlist     []string
+		expectedArgs  string
+		expectedError string
+	}{
+		{
+			name:         "forced false",
+			expectedArgs: "--allow-backup=false",
+		},
+		{
+			name:         "allowed true",
+			allowBackup:  "allow_backup: true,",
+			allowlist:    []string{"app"},
+			expectedArgs: "--allow-backup=true",
+		},
+		{
+			name:          "disallowed true",
+			allowBackup:   "allow_backup: true,",
+			expectedError: `allow_backup: android:allowBackup="true" is not allowed by the product`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.allowBackup + `
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := android.GroupFixturePreparers(
+				PrepareForTestWithJavaDefaultModules,
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.ManifestAllowBackupDefault = proptools.BoolPtr(false)
+					variables.ManifestAllowBackupAllowlist = tc.allowlist
+				}),
+			).ExtendWithErrorHandler(errorHandler).RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerMarkFinal(t *testing.T) {
+	bp := `
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			srcs: ["lib/lib.java"],
+			manifest: "lib/AndroidManifest.xml",
+			mark_manifest_final: true,
+		}
+
+		android_library {
+			name: "other_lib",
+			sdk_version: "current",
+			srcs: ["other_lib/lib.java"],
+			manifest: "other_lib/AndroidManifest.xml",
+		}
+
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			mark_manifest_final: true,
+			remove_meta_data: ["foo"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	lib := result.ModuleForTests("lib", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "lib manifest fixer args", lib.Args["args"], "--mark-final")
+
+	otherLib := result.ModuleForTests("other_lib", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesNotContain(t, "other_lib manifest fixer args", otherLib.Args["args"], "--mark-final")
+
+	// The post-merge fixes of an app are still applied to its marked manifest.
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "app manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"], "--mark-final")
+	postMerge := app.Output("manifest_fixer_post_merge/AndroidManifest.xml")
+	android.AssertStringEquals(t, "app post-merge manifest fixer args", "--remove-meta-data foo",
+		postMerge.Args["args"])
+	android.AssertPathRelativeToTopEquals(t, "app post-merge manifest fixer input",
+		"out/soong/.intermediates/app/android_common/manifest_fixer/AndroidManifest.xml", postMerge.Input)
+}
+
+func TestManifestFixerGwpAsanMode(t *testing.T) {
+	testCases := []struct {
+		mode          string
+		expectedArgs  string
+		expectedError string
+	}{
+		{mode: "always", expectedArgs: "--gwp-asan-mode always"},
+		{mode: "never", expectedArgs: "--gwp-asan-mode never"},
+		{mode: "default", expectedArgs: "--gwp-asan-mode default"},
+		{mode: "sometimes", expectedError: `invalid gwpAsanMode "sometimes"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					gwp_asan_mode: "` + tc.mode + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerInstallLocation(t *testing.T) {
+	testCases := []struct {
+		location      string
+		expectedArgs  string
+		expectedError string
+	}{
+		{location: "preferExternal", expectedArgs: "--install-location preferExternal"},
+		{location: "external", expectedError: `invalid installLocation "external"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.location, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					install_location: "` + tc.location + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerIsSplitRequired(t *testing.T) {
+	testCases := []struct {
+		name            string
+		isSplitRequired string
+		expectedArgs    string
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:            "true",
+			isSplitRequired: "is_split_required: true,",
+			expectedArgs:    "--is-split-required=true",
+		},
+		{
+			name:            "false",
+			isSplitRequired: "is_split_required: false,",
+			expectedArgs:    "--is-split-required=false",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.isSplitRequired + `
+				}
+			`
+
+			result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+			args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			if tc.expectedArgs == "" {
+				android.AssertStringDoesNotContain(t, "manifest fixer args", args, "--is-split-required")
+			} else {
+				android.AssertStringDoesContain(t, "manifest fixer args", args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestManifestFixerBackupAgent(t *testing.T) {
+	testCases := []struct {
+		backupAgent   string
+		expectedError string
+	}{
+		{backupAgent: ".Backup"},
+		{backupAgent: "com.example.app.Backup"},
+		{backupAgent: "com..Backup", expectedError: `invalid backupAgent "com..Backup"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.backupAgent, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					backup_agent: "` + tc.backupAgent + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"],
+				"--backup-agent "+tc.backupAgent)
+		})
+	}
+}
+
+func TestManifestFixerZygotePreloadName(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			zygote_preload_name: "%s",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t,
+		fmt.Sprintf(bp, "com.example.app.ZygotePreload"))
+
+	manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"],
+		"--zygote-preload-name com.example.app.ZygotePreload")
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid zygotePreloadName "com.example.app/ZygotePreload"`)).
+		RunTestWithBp(t, fmt.Sprintf(bp, "com.example.app/ZygotePreload"))
+}
+
+func TestManifestFixerAppComponentFactory(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			app_component_factory: "%s",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t,
+		fmt.Sprintf(bp, "com.example.instrumentation.WrapperFactory"))
+
+	manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"],
+		"--app-component-factory com.example.instrumentation.WrapperFactory")
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid appComponentFactory "com.example.instrumentation.Wrapper Factory"`)).
+		RunTestWithBp(t, fmt.Sprintf(bp, "com.example.instrumentation.Wrapper Factory"))
+}
+
+func TestManifestFixerSharedUserMaxSdkVersion(t *testing.T) {
+	testCases := []struct {
+		version       string
+		expectedArgs  string
+		expectedError string
+	}{
+		{version: "32", expectedArgs: "--shared-user-max-sdk-version 32"},
+		{version: "R", expectedArgs: "--shared-user-max-sdk-version 30"},
+		{version: "Tiramisu", expectedError: `invalid sharedUserMaxSdkVersion "Tiramisu", must be a released API level`},
+		{version: "foo", expectedError: `invalid sharedUserMaxSdkVersion`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					shared_user_max_sdk_version: "` + tc.version + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerSharedUserLabel(t *testing.T) {
+	testCases := []struct {
+		label         string
+		expectedArgs  string
+		expectedError string
+	}{
+		{label: "@string/shared_label", expectedArgs: "--shared-user-label @string/shared_label"},
+		{label: "@android:string/foo", expectedArgs: "--shared-user-label @android:string/foo"},
+		{label: "Shared", expectedError: `invalid sharedUserLabel "Shared", must be a @string/... reference`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.label, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					shared_user_label: "` + tc.label + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerUsesLibrariesOrder(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "bar",
+			srcs: ["a.java"],
+			api_packages: ["bar"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "baz",
+			srcs: ["a.java"],
+			api_packages: ["baz"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: [
+				"foo",
+				"bar",
+			],
+			optional_uses_libs: ["baz"],
+			sdk_version: "current",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo", "bar", "baz"),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestCheckUsesLibrariesOrder = proptools.BoolPtr(true)
+		}),
+	).RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--uses-library foo --uses-library bar --optional-uses-library baz")
+
+	app := result.ModuleForTests("app", "android_common").Module().(*AndroidApp)
+	expectedRequired, expectedOptional := app.classLoaderContexts.UsesLibs()
+	required, optional := usesLibrariesFromManifestFixerArgs(strings.Fields(args))
+	android.AssertDeepEquals(t, "uses-library order", expectedRequired, required)
+	android.AssertDeepEquals(t, "optional uses-library order", expectedOptional, optional)
+}
+
+func TestManifestFixerUsesLibrariesOrderInterleaved(t *testing.T) {
+	// The implementation of qux is added to the class loader context for the libs dependency,
+	// after the optional baz, but manifest_fixer.py adds required libraries before optional ones.
+	// dexpreopt only relies on the order within each group, so this is not an error.
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "baz",
+			srcs: ["a.java"],
+			api_packages: ["baz"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "qux",
+			srcs: ["a.java"],
+			api_packages: ["qux"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: ["foo"],
+			optional_uses_libs: ["baz"],
+			libs: ["qux"],
+			sdk_version: "current",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo", "baz", "qux"),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestCheckUsesLibrariesOrder = proptools.BoolPtr(true)
+		}),
+	).RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--uses-library foo --uses-library qux --optional-uses-library baz")
+}
+
+func TestManifestUsesLibraryCertificates(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "bar",
+			srcs: ["a.java"],
+			api_packages: ["bar"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: ["foo"],
+			optional_uses_libs: ["bar"],
+			sdk_version: "current",
+			enforce_uses_library_certificates: true,
+			%s
+		}
+	`
+
+	restrictions := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.ManifestUsesLibraryCertificates = []string{"foo:platform", "bar:platform"}
+	})
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo", "bar"),
+		restrictions,
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`<uses-library> "foo" may only be used by apps signed with the "platform" certificate`,
+	})).RunTestWithBp(t, fmt.Sprintf(bp, ""))
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo", "bar"),
+		restrictions,
+	).RunTestWithBp(t, fmt.Sprintf(bp, `certificate: "platform",`))
+}
+
+func TestManifestUsesLibraryCertificatesOverride(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: ["foo"],
+			sdk_version: "current",
+			certificate: "platform",
+			enforce_uses_library_certificates: true,
+		}
+
+		android_app_certificate {
+			name: "new_certificate",
+			certificate: "cert/new_cert",
+		}
+	`
+
+	testCases := []struct {
+		name        string
+		restriction string
+		err         string
+	}{
+		{
+			name:        "overridden default certificate",
+			restriction: "foo:platform",
+			err:         `<uses-library> "foo" may only be used by apps signed with the "platform" certificate`,
+		},
+		{
+			name:        "certificate module",
+			restriction: "foo::new_certificate",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			errorHandler := android.FixtureExpectsNoErrors
+			if test.err != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(test.err)
+			}
+			android.GroupFixturePreparers(
+				PrepareForTestWithJavaDefaultModules,
+				PrepareForTestWithJavaSdkLibraryFiles,
+				FixtureWithLastReleaseApis("foo"),
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.CertificateOverrides = []string{"app:new_certificate"}
+					variables.ManifestUsesLibraryCertificates = []string{test.restriction}
+				}),
+			).ExtendWithErrorHandler(errorHandler).RunTestWithBp(t, bp)
+		})
+	}
+}
+
+func TestManifestCheckUsesLibraryModules(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: ["foo"],
+			optional_uses_libs: ["bar"],
+			sdk_version: "current",
+			%s
+		}
+	`
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo"),
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`optional_uses_libs: module "bar" is not a library, its manifest is built as the manifest of an app`,
+	})).RunTestWithBp(t, fmt.Sprintf(bp, "check_uses_library_modules: true,"))
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo"),
+	).RunTestWithBp(t, fmt.Sprintf(bp, ""))
+}
+
+func TestManifestMergerRroManifests(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			rro_manifests: [
+				"foo_rro",
+				"bar_rro",
+			],
+		}
+
+		runtime_resource_overlay {
+			name: "foo_rro",
+			sdk_version: "current",
+			manifest: "foo_rro/AndroidManifest.xml",
+		}
+
+		runtime_resource_overlay {
+			name: "bar_rro",
+			sdk_version: "current",
+			manifest: "bar_rro/AndroidManifest.xml",
+			exclude_from_manifest_merge: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	manifestMergerRule := app.Rule("manifestMerger")
+	android.AssertPathsRelativeToTopEquals(t, "lib manifests",
+		[]string{
+			"out/soong/.intermediates/app/android_common/rro_manifests/foo_rro/AndroidManifest.xml",
+		},
+		manifestMergerRule.Implicits)
+
+	strip := app.Output("rro_manifests/foo_rro/AndroidManifest.xml")
+	android.AssertPathRelativeToTopEquals(t, "overlay manifest",
+		"out/soong/.intermediates/foo_rro/android_common/manifest_fixer/AndroidManifest.xml",
+		strip.Input)
+	android.AssertStringEquals(t, "strip overlay args", "--strip-overlay", strip.Args["args"])
+}
+
+func TestManifestMergerRroManifestsOtherPackage(t *testing.T) {
+	// The overlay declares a package other than the app's, which is removed from the overlay's
+	// manifest before merging.
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			package_name: "com.android.app",
+			rro_manifests: ["foo_rro"],
+		}
+
+		runtime_resource_overlay {
+			name: "foo_rro",
+			sdk_version: "current",
+			manifest: "foo_rro/AndroidManifest.xml",
+			package_name: "com.android.app.overlay",
+			target_package_name: "com.android.app",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	strip := result.ModuleForTests("app", "android_common").Output("rro_manifests/foo_rro/AndroidManifest.xml")
+	android.AssertStringEquals(t, "strip overlay args", "--strip-overlay", strip.Args["args"])
+}
+
+func TestManifestMergerRroManifestsOtherTarget(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			package_name: "com.android.app",
+			rro_manifests: ["foo_rro"],
+		}
+
+		runtime_resource_overlay {
+			name: "foo_rro",
+			sdk_version: "current",
+			manifest: "foo_rro/AndroidManifest.xml",
+			target_package_name: "com.android.other",
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`rro_manifests: "foo_rro" targets package "com.android.other", not "com.android.app"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestMergerRroManifestsNotRro(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			rro_manifests: ["lib"],
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`rro_manifests: "lib" is not a runtime_resource_overlay module`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerSplitTypes(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			required_split_types: ["foo", "bar"],
+			split_types: ["base", "abi"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--required-split-types bar,foo")
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--split-types abi,base")
+}
+
+func TestManifestFixerSplitTypesInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			split_types: ["foo,bar"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`split_types: invalid split type "foo,bar"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerDefaultTheme(t *testing.T) {
+	testCases := []struct {
+		name          string
+		theme         string
+		overrideTheme bool
+		expectedArgs  string
+		expectedError string
+	}{
+		{
+			name:         "default",
+			theme:        "@style/Brand",
+			expectedArgs: "--default-theme @style/Brand",
+		},
+		{
+			name:          "override",
+			theme:         "@android:style/Theme.DeviceDefault",
+			overrideTheme: true,
+			expectedArgs:  "--default-theme @android:style/Theme.DeviceDefault --override-theme",
+		},
+		{
+			name:          "invalid",
+			theme:         "Brand",
+			expectedError: `invalid default theme "Brand"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := fmt.Sprintf(`
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					default_theme: %q,
+					override_theme: %t,
+				}
+			`, tc.theme, tc.overrideTheme)
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			android.AssertStringDoesContain(t, "manifest fixer args", args, tc.expectedArgs)
+			if !tc.overrideTheme {
+				android.AssertStringDoesNotContain(t, "manifest fixer args", args, "--override-theme")
+			}
+		})
+	}
+}
+
+func TestManifestFixerWarnMinSdkVersionMismatch(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			min_sdk_version: "29",
+			srcs: ["app/app.java"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesNotContain(t, "manifest fixer args", args, "--warn-min-sdk-version-mismatch")
+
+	result = android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_WARN_MANIFEST_MIN_SDK_VERSION_MISMATCH": "true",
+		}),
+	).RunTestWithBp(t, bp)
+	args = result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--minSdkVersion  29")
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--warn-min-sdk-version-mismatch")
+}
+
+func TestManifestFixerFullBackupContent(t *testing.T) {
+	testCases := []struct {
+		fullBackupContent string
+		expectedError     string
+	}{
+		{fullBackupContent: "@xml/backup_rules"},
+		{fullBackupContent: "backup_rules", expectedError: `invalid fullBackupContent "backup_rules"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fullBackupContent, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					full_backup_content: "` + tc.fullBackupContent + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"],
+				"--full-backup-content "+tc.fullBackupContent)
+		})
+	}
+}
+
+func TestManifestFixerAppRestrictions(t *testing.T) {
+	testCases := []struct {
+		appRestrictions string
+		expectedError   string
+	}{
+		{appRestrictions: "@xml/app_restrictions"},
+		{appRestrictions: "@string/app_restrictions", expectedError: `invalid app restrictions "@string/app_restrictions"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.appRestrictions, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					app_restrictions: "` + tc.appRestrictions + `",
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"],
+				"--app-restrictions "+tc.appRestrictions)
+		})
+	}
+}
+
+func TestManifestMergerCheckLibPackages(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["lib"],
+			enforce_static_lib_manifest_packages: true,
+			allowed_static_lib_manifest_packages: ["com.android.lib"],
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	manifestMergerRule := app.Rule("manifestMerger")
+	android.AssertPathsRelativeToTopEquals(t, "validations",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_merger/lib_packages.stamp"},
+		manifestMergerRule.Validations)
+
+	cmd := app.Output("manifest_merger/lib_packages.stamp").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--lib-manifest out/soong/.intermediates/lib/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", cmd, "--allowed-lib-package com.android.lib")
+}
+
+func TestManifestMergerCheckDuplicateComponents(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["liba", "libb"],
+			check_duplicate_manifest_components: true,
+		}
+
+		android_library {
+			name: "liba",
+			sdk_version: "current",
+			manifest: "liba/AndroidManifest.xml",
+		}
+
+		android_library {
+			name: "libb",
+			sdk_version: "current",
+			manifest: "libb/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertPathsRelativeToTopEquals(t, "validations",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_merger/duplicate_components.stamp"},
+		app.Rule("manifestMerger").Validations)
+
+	cmd := app.Output("manifest_merger/duplicate_components.stamp").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--check-duplicate-components")
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--lib-manifest out/soong/.intermediates/liba/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--lib-manifest out/soong/.intermediates/libb/android_common/manifest_fixer/AndroidManifest.xml")
+}
+
+func TestManifestMergerEmitLibPackages(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["liba", "libb"],
+			emit_static_lib_manifest_packages: true,
+		}
+
+		android_app {
+			name: "nolibs",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			emit_static_lib_manifest_packages: true,
+		}
+
+		android_library {
+			name: "liba",
+			sdk_version: "current",
+			manifest: "liba/AndroidManifest.xml",
+		}
+
+		android_library {
+			name: "libb",
+			sdk_version: "current",
+			manifest: "libb/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	libPackages, err := app.Module().(*AndroidApp).OutputFiles(".manifest_lib_packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "lib packages",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_merger/lib_packages.txt"}, libPackages)
+
+	cmd := app.Output("manifest_merger/lib_packages.txt").RuleParams.Command
+	android.AssertStringDoesContain(t, "list command", cmd,
+		"--lib-manifest out/soong/.intermediates/liba/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "list command", cmd,
+		"--lib-manifest out/soong/.intermediates/libb/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesNotContain(t, "list command", cmd, "--check-lib-packages")
+
+	nolibs := result.ModuleForTests("nolibs", "android_common")
+	android.AssertStringEquals(t, "lib packages without static libs", "\n",
+		android.ContentFromFileRuleForTests(t, result.TestContext, nolibs.Output("manifest_merger/lib_packages.txt")))
+}
+
+func TestManifestMergerEmitMergeChanges(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["liba"],
+			emit_manifest_merge_changes: true,
+		}
+
+		android_app {
+			name: "nolibs",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			emit_manifest_merge_changes: true,
+		}
+
+		android_library {
+			name: "liba",
+			sdk_version: "current",
+			manifest: "liba/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"liba/AndroidManifest.xml": []byte(`<manifest package="com.android.liba"><application><service android:name=".Service"/></application></manifest>`),
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	changes, err := app.Module().(*AndroidApp).OutputFiles(".manifest_merge_changes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "merge changes",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_merger/merge_changes.json"}, changes)
+
+	cmd := app.Output("manifest_merger/merge_changes.json").RuleParams.Command
+	android.AssertStringDoesContain(t, "list command", cmd,
+		"--unmerged-manifest out/soong/.intermediates/app/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "list command", cmd,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+
+	nolibs := result.ModuleForTests("nolibs", "android_common")
+	android.AssertStringEquals(t, "merge changes without static libs", "[]\n",
+		android.ContentFromFileRuleForTests(t, result.TestContext, nolibs.Output("manifest_merger/merge_changes.json")))
+}
+
+func TestManifestLibPermissions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			static_libs: ["liba"],
+			emit_manifest_lib_permissions: true,
+		}
+
+		android_app {
+			name: "nolibs",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			emit_manifest_lib_permissions: true,
+		}
+
+		android_library {
+			name: "liba",
+			sdk_version: "current",
+			manifest: "liba/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"liba/AndroidManifest.xml": []byte(`<manifest package="com.android.liba"><uses-permission android:name="android.permission.CAMERA"/></manifest>`),
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	sectioned, err := app.Module().(*AndroidApp).OutputFiles(".manifest_lib_permissions.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "lib permissions manifest",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_merger/lib_permissions.xml"}, sectioned)
+
+	cmd := app.Output("manifest_merger/lib_permissions.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "section command", cmd,
+		"--unmerged-manifest out/soong/.intermediates/app/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "section command", cmd,
+		"--lib-manifest out/soong/.intermediates/liba/android_common/manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "section command", cmd,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+
+	// The shipped manifest is still the merged manifest, not the sectioned copy.
+	android.AssertPathRelativeToTopEquals(t, "merged manifest",
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml",
+		app.Module().(*AndroidApp).aapt.mergedManifestFile)
+
+	nolibs := result.ModuleForTests("nolibs", "android_common")
+	cmd = nolibs.Output("manifest_merger/lib_permissions.xml").RuleParams.Command
+	android.AssertStringDoesNotContain(t, "section command without static libs", cmd, "--lib-manifest")
+}
+
+func TestManifestCompatReport(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			baseline_manifest: "app/baseline/AndroidManifest.xml",
+		}
+
+		android_app {
+			name: "nobaseline",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"app/baseline/AndroidManifest.xml": []byte(`<manifest package="com.android.foo"><uses-permission android:name="android.permission.CAMERA"/></manifest>`),
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	report, err := app.Module().(*AndroidApp).OutputFiles(".manifest_compat_report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "compat report",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_compat_report/compat_report.json"}, report)
+
+	cmd := app.Output("manifest_compat_report/compat_report.json").RuleParams.Command
+	android.AssertStringDoesContain(t, "report command", cmd,
+		"--baseline-manifest app/baseline/AndroidManifest.xml")
+
+	nobaseline := result.ModuleForTests("nobaseline", "android_common")
+	rule := nobaseline.MaybeOutput("manifest_compat_report/compat_report.json")
+	android.AssertBoolEquals(t, "report rule exists", false, rule.Rule != nil)
+}
+
+func TestManifestComponentInventory(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			static_libs: ["liba"],
+			emit_manifest_component_inventory: true,
+		}
+
+		android_app {
+			name: "noinventory",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+
+		android_library {
+			name: "liba",
+			sdk_version: "current",
+			manifest: "liba/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"liba/AndroidManifest.xml": []byte(`<manifest package="com.android.liba"><application><service android:name=".Service"/></application></manifest>`),
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	inventory, err := app.Module().(*AndroidApp).OutputFiles(".component_inventory.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "component inventory",
+		[]string{"out/soong/.intermediates/app/android_common/manifest_component_inventory/component_inventory.json"},
+		inventory)
+
+	// The inventory is listed from the merged manifest, so that it includes the components of
+	// static libraries.
+	cmd := app.Output("manifest_component_inventory/component_inventory.json").RuleParams.Command
+	android.AssertStringDoesContain(t, "inventory command", cmd,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+
+	noinventory := result.ModuleForTests("noinventory", "android_common")
+	rule := noinventory.MaybeOutput("manifest_component_inventory/component_inventory.json")
+	android.AssertBoolEquals(t, "inventory rule exists", false, rule.Rule != nil)
+}
+
+func TestManifestFixerKnownActivityEmbeddingCerts(t *testing.T) {
+	certA := strings.Repeat("a", 64)
+	certB := strings.Repeat("B", 64)
+
+	bp := `
+		android_app {
+			name: "single",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			known_activity_embedding_certs: ["` + certA + `"],
+		}
+
+		android_app {
+			name: "multiple",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			known_activity_embedding_certs: ["` + certB + `", "` + certA + `"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	single := result.ModuleForTests("single", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		single.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--known-activity-embedding-certs "+strings.ToUpper(certA))
+
+	multiple := result.ModuleForTests("multiple", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		multiple.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--known-activity-embedding-certs @array/soong_known_activity_embedding_certs")
+	certsRes := android.ContentFromFileRuleForTests(t, result.TestContext,
+		multiple.Output("gen/known_activity_embedding_certs/res/values/known_activity_embedding_certs.xml"))
+	android.AssertStringDoesContain(t, "certs resource", certsRes,
+		"<item>"+strings.ToUpper(certA)+"</item>\n        <item>"+certB+"</item>")
+}
+
+func TestManifestFixerKnownActivityEmbeddingCertsInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			known_activity_embedding_certs: ["1234"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid knownActivityEmbeddingCerts digest "1234"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerGenerateLocaleConfig(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			resource_dirs: ["app/res"],
+			generate_locale_config: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"app/res/values/strings.xml":        nil,
+			"app/res/values-fr/strings.xml":     nil,
+			"app/res/values-en-rGB/strings.xml": nil,
+			"app/res/values-land/dimens.xml":    nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--locale-config @xml/soong_locale_config")
+	localeConfig := android.ContentFromFileRuleForTests(t, result.TestContext,
+		app.Output("gen/locale_config/res/xml/soong_locale_config.xml"))
+	android.AssertStringDoesContain(t, "locale config", localeConfig,
+		"    <locale android:name=\"en-GB\"/>\n    <locale android:name=\"fr\"/>\n</locale-config>")
+}
+
+func TestLocaleFromResourceQualifiers(t *testing.T) {
+	testCases := map[string]string{
+		"values":             "",
+		"values-land":        "",
+		"values-fr":          "fr",
+		"values-en-rGB":      "en-GB",
+		"values-b+sr+Latn":   "sr-Latn",
+		"values-mcc310-es":   "es",
+		"values-fil-night":   "fil",
+		"values-en-rUS-land": "en-US",
+	}
+	for dir, expected := range testCases {
+		android.AssertStringEquals(t, dir, expected, localeFromResourceQualifiers(dir))
+	}
+}
+
+func TestManifestMergedOutputFile(t *testing.T) {
+	bp := `
+		android_app {
+			name: "merged",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "merged/AndroidManifest.xml",
+			static_libs: ["lib"],
+		}
+
+		android_app {
+			name: "unmerged",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			manifest: "unmerged/AndroidManifest.xml",
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	merged, err := result.ModuleForTests("merged", "android_common").Module().(*AndroidApp).OutputFiles(".manifest_merged.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "merged",
+		[]string{"out/soong/.intermediates/merged/android_common/manifest_merger/AndroidManifest.xml"}, merged)
+
+	unmerged, err := result.ModuleForTests("unmerged", "android_common").Module().(*AndroidApp).OutputFiles(".manifest_merged.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "unmerged", []string{"unmerged/AndroidManifest.xml"}, unmerged)
+}
+
+func TestManifestFixerHardwareAccelerated(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			hardware_accelerated: true,
+			hardware_accelerated_activities: ["com.android.app.LegacyActivity:false"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--hardware-accelerated=true")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-hardware-accelerated com.android.app.LegacyActivity=false",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerDirectBootAware(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			direct_boot_aware: true,
+			direct_boot_aware_components: ["com.android.app.SyncService:false"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--direct-boot-aware=true")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--component-direct-boot-aware com.android.app.SyncService=false",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerPersistent(t *testing.T) {
+	testCases := []struct {
+		name          string
+		properties    string
+		expectedArgs  string
+		expectedError string
+	}{
+		{
+			name:         "privileged",
+			properties:   "privileged: true, persistent: true,",
+			expectedArgs: "--persistent=true",
+		},
+		{
+			name:         "not persistent",
+			properties:   "persistent: false,",
+			expectedArgs: "--persistent=false",
+		},
+		{
+			name:          "not privileged",
+			properties:    "persistent: true,",
+			expectedError: `persistent: android:persistent="true" is only allowed for privileged apps`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.properties + `
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.ExtendWithErrorHandler(errorHandler).RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+			manifestFixer := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+			android.AssertStringDoesContain(t, "manifest fixer args", manifestFixer.Args["args"], tc.expectedArgs)
+		})
+	}
+}
+
+func TestManifestFixerThemeActivities(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			theme_activities: [
+				"com.android.app.SettingsActivity:@style/BrandSettings",
+				"com.android.app.MainActivity:@android:style/Theme.DeviceDefault",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-theme com.android.app.MainActivity=@android:style/Theme.DeviceDefault "+
+			"--activity-theme com.android.app.SettingsActivity=@style/BrandSettings",
+		result.ModuleForTests("app", "android_common").Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerThemeActivitiesInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			theme_activities: ["com.android.app.MainActivity:BrandTheme"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`theme_activities: invalid theme "BrandTheme" for "com.android.app.MainActivity"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerWindowAttributesActivities(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			window_attributes_activities: [
+				"com.android.app.MainActivity:windowLayoutInDisplayCutoutMode=shortEdges",
+				"com.android.app.MainActivity:windowSoftInputMode=stateHidden|adjustResize",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-window-attribute com.android.app.MainActivity=windowLayoutInDisplayCutoutMode=shortEdges "+
+			"--activity-window-attribute 'com.android.app.MainActivity=windowSoftInputMode=stateHidden|adjustResize'",
+		result.ModuleForTests("app", "android_common").Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerWindowAttributesActivitiesInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		entry         string
+		expectedError string
+	}{
+		{
+			name:          "attribute",
+			entry:         "com.android.app.MainActivity:enableBlur=true",
+			expectedError: `invalid attribute "enableBlur" for "com.android.app.MainActivity"`,
+		},
+		{
+			name:          "value",
+			entry:         "com.android.app.MainActivity:windowLayoutInDisplayCutoutMode=shortEdge",
+			expectedError: `invalid windowLayoutInDisplayCutoutMode "shortEdge" for "com.android.app.MainActivity"`,
+		},
+		{
+			name:          "entry",
+			entry:         "com.android.app.MainActivity:shortEdges",
+			expectedError: `invalid entry "com.android.app.MainActivity:shortEdges"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					window_attributes_activities: ["` + tc.entry + `"],
+				}
+			`
+
+			PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					"window_attributes_activities: "+tc.expectedError)).
+				RunTestWithBp(t, bp)
+		})
+	}
+}
+
+func TestManifestFixerLabelComponents(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			label_components: ["com.android.app.SyncService:@string/sync_service_label"],
+			description_components: ["com.android.app.SyncService:@string/sync_service_description"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--component-label com.android.app.SyncService=@string/sync_service_label "+
+			"--component-description com.android.app.SyncService=@string/sync_service_description",
+		result.ModuleForTests("app", "android_common").Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerLabelComponentsInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			label_components: ["com.android.app.SyncService:Sync"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`label_components: invalid value "Sync" for "com.android.app.SyncService", must be a @string/... reference`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerOnBackInvokedCallbackActivities(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			on_back_invoked_callback_activities: [
+				"com.android.app.SettingsActivity:true",
+				"com.android.app.LegacyActivity:false",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-on-back-invoked-callback com.android.app.LegacyActivity=false "+
+			"--activity-on-back-invoked-callback com.android.app.SettingsActivity=true",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerResizeableActivity(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			resizeable_activity: true,
+			resizeable_activities: ["com.android.app.CameraActivity:false"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--resizeable-activity=true")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-resizeable-activity com.android.app.CameraActivity=false",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerUiOptions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			ui_options: "splitActionBarWhenNarrow",
+			ui_options_activities: ["com.android.app.MainActivity:none"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--ui-options splitActionBarWhenNarrow")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--activity-ui-options com.android.app.MainActivity=none",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerUiOptionsInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			ui_options: "splitActionBar",
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`ui_options: invalid value "splitActionBar"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerTriStateBoolProperties(t *testing.T) {
+	testCases := []struct {
+		property string
+		flag     string
+	}{
+		{property: "restore_any_version", flag: "--restore-any-version"},
+		{property: "cross_profile", flag: "--cross-profile"},
+		{property: "allow_native_heap_pointer_tagging", flag: "--allow-native-heap-pointer-tagging"},
+		{property: "large_heap", flag: "--large-heap"},
+		{property: "allow_clear_user_data", flag: "--allow-clear-user-data"},
+		{property: "multi_arch", flag: "--multi-arch"},
+		{property: "kill_after_restore", flag: "--kill-after-restore"},
+	}
+
+	for _, tc := range testCases {
+		for _, value := range []string{"true", "false", ""} {
+			name := tc.property + "_" + value
+			if value == "" {
+				name = tc.property + "_unset"
+			}
+			t.Run(name, func(t *testing.T) {
+				property := ""
+				if value != "" {
+					property = tc.property + ": " + value + ","
+				}
+				bp := `
+					android_app {
+						name: "app",
+						sdk_version: "current",
+						srcs: ["app/app.java"],
+						` + property + `
+					}
+				`
+
+				result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+				args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+				if value != "" {
+					android.AssertStringDoesContain(t, "manifest fixer args", args, tc.flag+"="+value)
+				} else {
+					android.AssertStringDoesNotContain(t, "manifest fixer args", args, tc.flag)
+				}
+			})
+		}
+	}
+}
+
+func TestManifestFixerUsesPermissionFlags(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_permission_flags: ["android.permission.BLUETOOTH_SCAN:neverForLocation"],
+		}
+
+		android_app {
+			name: "add_missing",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_permission_flags: ["android.permission.BLUETOOTH_SCAN:neverForLocation"],
+			add_missing_uses_permissions: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--uses-permission-flags android.permission.BLUETOOTH_SCAN=neverForLocation",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+
+	addMissing := result.ModuleForTests("add_missing", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--uses-permission-flags android.permission.BLUETOOTH_SCAN=neverForLocation --add-missing-uses-permissions",
+		addMissing.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerUsesPermissionModifications(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_permission_modifications: [
+				{
+					name: "android.permission.WRITE_EXTERNAL_STORAGE",
+					max_sdk_version: "32",
+					flags: "neverForLocation",
+				},
+				{
+					name: "android.permission.READ_EXTERNAL_STORAGE",
+					max_sdk_version: "32",
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--modify-uses-permission 'android.permission.READ_EXTERNAL_STORAGE=32:' "+
+			"--modify-uses-permission 'android.permission.WRITE_EXTERNAL_STORAGE=32:neverForLocation'",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerNeverForLocationPermissions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			never_for_location_permissions: [
+				"android.permission.BLUETOOTH_SCAN",
+				"android.permission.NEARBY_WIFI_DEVICES",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--modify-uses-permission 'android.permission.BLUETOOTH_SCAN=:neverForLocation' "+
+			"--modify-uses-permission 'android.permission.NEARBY_WIFI_DEVICES=:neverForLocation'",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerNeverForLocationPermissionsConflict(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_permission_flags: ["android.permission.BLUETOOTH_SCAN:neverForLocation"],
+			never_for_location_permissions: ["android.permission.BLUETOOTH_SCAN"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`permission "android.permission.BLUETOOTH_SCAN" is also listed in uses_permission_flags`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerUsesPermissionModificationsInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		modification  string
+		extraProps    string
+		expectedError string
+	}{
+		{
+			name:          "max_sdk_version",
+			modification:  `name: "android.permission.READ_EXTERNAL_STORAGE", max_sdk_version: "S"`,
+			expectedError: `invalid max_sdk_version "S" for permission "android.permission.READ_EXTERNAL_STORAGE"`,
+		},
+		{
+			name:          "flags",
+			modification:  `name: "android.permission.BLUETOOTH_SCAN", flags: "never for location"`,
+			expectedError: `invalid flags "never for location" for permission "android.permission.BLUETOOTH_SCAN"`,
+		},
+		{
+			name:          "empty",
+			modification:  `name: "android.permission.BLUETOOTH_SCAN"`,
+			expectedError: `neither max_sdk_version nor flags set for permission "android.permission.BLUETOOTH_SCAN"`,
+		},
+		{
+			name:          "uses_permission_flags",
+			modification:  `name: "android.permission.BLUETOOTH_SCAN", max_sdk_version: "30"`,
+			extraProps:    `uses_permission_flags: ["android.permission.BLUETOOTH_SCAN:neverForLocation"],`,
+			expectedError: `permission "android.permission.BLUETOOTH_SCAN" is also listed in uses_permission_flags`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					uses_permission_modifications: [{` + testCase.modification + `}],
+					` + testCase.extraProps + `
+				}
+			`
+
+			PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					testCase.expectedError)).
+				RunTestWithBp(t, bp)
+		})
+	}
+}
+
+func TestManifestFixerStripAllToolsAttributes(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			static_libs: ["lib"],
+			strip_all_tools_attributes: true,
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+			static_libs: ["lib2"],
+			strip_all_tools_attributes: true,
+		}
+
+		android_library {
+			name: "lib2",
+			sdk_version: "current",
+			manifest: "lib2/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "app post-merge manifest fixer args", "--strip-tools-attributes",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+
+	lib := result.ModuleForTests("lib", "android_common")
+	libFixer := lib.Output("manifest_fixer_post_merge/AndroidManifest.xml")
+	android.AssertStringEquals(t, "lib post-merge manifest fixer args", "--strip-tools-attributes",
+		libFixer.Args["args"])
+	android.AssertPathRelativeToTopEquals(t, "lib post-merge manifest fixer input",
+		"out/soong/.intermediates/lib/android_common/manifest_merger/AndroidManifest.xml", libFixer.Input)
+
+	// The manifest of the library that is merged into the app keeps its tools: attributes.
+	android.AssertStringDoesContain(t, "app manifest merger libs", app.Rule("manifestMerger").Args["libs"],
+		"--libs out/soong/.intermediates/lib/android_common/manifest_fixer/AndroidManifest.xml")
+}
+
+func TestManifestFixerCanonicalizeNamespaces(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			canonicalize_manifest_namespaces: true,
+			strip_all_tools_attributes: true,
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+			canonicalize_manifest_namespaces: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "app post-merge manifest fixer args",
+		"--canonicalize-namespaces --strip-tools-attributes",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+
+	lib := result.ModuleForTests("lib", "android_common")
+	android.AssertStringEquals(t, "lib post-merge manifest fixer args", "--canonicalize-namespaces",
+		lib.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerApplicationAttributes(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			application_attributes: [
+				"defaultFocusHighlightEnabled:false",
+				"banner:@drawable/banner",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--application-attribute 'banner=@drawable/banner' --application-attribute defaultFocusHighlightEnabled=false")
+}
+
+func TestManifestFixerApplicationAttributesManaged(t *testing.T) {
+	for _, attr := range []string{"extractNativeLibs", "attributionsAreUserVisible"} {
+		t.Run(attr, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					application_attributes: ["` + attr + `:true"],
+				}
+			`
+
+			PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					`application_attributes: android:`+attr+` is managed by the build system`)).
+				RunTestWithBp(t, bp)
+		})
+	}
+}
+
+func TestManifestFixerAttributions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			attributions_are_user_visible: true,
+			attributions: [
+				{
+					tag: "location",
+					label: "@string/location_attribution",
+				},
+				{
+					tag: "contacts",
+					label: "@string/contacts_attribution",
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--attributions-are-user-visible=true "+
+			"--attribution 'contacts=@string/contacts_attribution' "+
+			"--attribution 'location=@string/location_attribution'")
+}
+
+func TestManifestFixerAttributionsInvalidLabel(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			attributions: [
+				{
+					tag: "location",
+					label: "Location",
+				},
+			],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`attributions: label of "location" must be a @string/... reference, got "Location"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerCompatibleScreens(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			compatible_screens: [
+				{
+					size: "small",
+					density: "480",
+				},
+				{
+					size: "normal",
+					density: "ldpi",
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--compatible-screen normal:ldpi --compatible-screen small:480")
+}
+
+func TestManifestFixerCompatibleScreensInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		size          string
+		density       string
+		expectedError string
+	}{
+		{
+			name:          "size",
+			size:          "huge",
+			density:       "mdpi",
+			expectedError: `compatible_screens: invalid size "huge"`,
+		},
+		{
+			name:          "density",
+			size:          "large",
+			density:       "-120",
+			expectedError: `compatible_screens: invalid density "-120"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := fmt.Sprintf(`
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					compatible_screens: [{
+						size: %q,
+						density: %q,
+					}],
+				}
+			`, tc.size, tc.density)
+
+			PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)).
+				RunTestWithBp(t, bp)
+		})
+	}
+}
+
+func TestManifestFixerUsesSdkLibraries(t *testing.T) {
+	digest := strings.Repeat("ab:", 31) + "ab"
+
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_sdk_libraries: [
+				{
+					name: "com.android.sdk.foo",
+					version_major: "2",
+					cert_digest: "` + digest + `",
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--uses-sdk-library 'com.android.sdk.foo=2:"+strings.Repeat("AB", 32)+"'")
+}
+
+func TestManifestFixerUsesSdkLibrariesInvalidCertDigest(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_sdk_libraries: [
+				{
+					name: "com.android.sdk.foo",
+					version_major: "2",
+					cert_digest: "1234",
+				},
+			],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`uses_sdk_libraries: invalid cert_digest "1234" of "com.android.sdk.foo"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerProviderUriPermissionGrants(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			provider_uri_permission_grants: [
+				{
+					provider: "com.android.app.FilesProvider",
+					path_prefixes: ["/shared/"],
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--grant-uri-permission 'com.android.app.FilesProvider=pathPrefix:/shared/'",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerStrictUsesLibraries(t *testing.T) {
+	bp := `
+		java_library {
+			name: "phantom",
+			srcs: ["a.java"],
+			provides_uses_lib: "com.android.phantom",
+			installable: false,
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			libs: ["phantom"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"],
+		"--uses-library com.android.phantom")
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestStrictUsesLibraries = proptools.BoolPtr(true)
+		}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`<uses-library> "com.android.phantom" in manifest is not backed by a library that is built`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerStrictUsesLibrariesMissingModule(t *testing.T) {
+	// Only the stubs of foo are available, its implementation library is missing from the build.
+	bp := `
+		java_sdk_library_import {
+			name: "foo",
+			public: {
+				jars: ["a.jar"],
+			},
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			uses_libs: ["foo"],
+		}
+	`
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestStrictUsesLibraries = proptools.BoolPtr(true)
+		}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`<uses-library> "foo" in manifest is not backed by a library that is built`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerStrictUsesLibrariesCompat(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+	`
+
+	strict := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.ManifestStrictUsesLibraries = proptools.BoolPtr(true)
+	})
+
+	// The compatibility libraries of the default modules are built.
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		strict,
+	).RunTestWithBp(t, bp)
+
+	// Define the compatibility libraries without the default modules, with one that isn't built.
+	compatLibs := ""
+	for _, lib := range append(android.CopyOf(dexpreopt.CompatUsesLibs), dexpreopt.OptionalCompatUsesLibs...) {
+		built := lib != "org.apache.http.legacy"
+		compatLibs += fmt.Sprintf(`
+			java_library {
+				name: "%s",
+				srcs: ["a.java"],
+				sdk_version: "none",
+				system_modules: "stable-core-platform-api-stubs-system-modules",
+				compile_dex: %v,
+				installable: %v,
+			}
+		`, lib, built, built)
+	}
+
+	android.GroupFixturePreparers(
+		PrepareForTestWithJavaBuildComponents,
+		prepareForTestWithFrameworkDeps,
+		dexpreopt.FixtureDisableDexpreoptBootImages(true),
+		dexpreopt.FixtureDisableDexpreopt(true),
+		strict,
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`compatibility library "org.apache.http.legacy" for SDK 28 in class loader context is not backed`,
+	})).RunTestWithBp(t, bp+compatLibs)
+}
+
+func TestClassLoaderContextLibsWithoutUsesLibs(t *testing.T) {
+	compatOnly := dexpreopt.ClassLoaderContextMap{
+		28: {{Name: "org.apache.http.legacy"}},
+		30: {{Name: "android.test.base"}, {Name: "android.test.mock"}},
+	}
+	android.AssertDeepEquals(t, "compatibility libraries only",
+		[]string{"android.test.base", "android.test.mock", "org.apache.http.legacy"},
+		classLoaderContextLibsWithoutUsesLibs(compatOnly))
+
+	withUsesLibs := dexpreopt.ClassLoaderContextMap{
+		28:                      {{Name: "org.apache.http.legacy"}},
+		dexpreopt.AnySdkVersion: {{Name: "foo", Optional: true}},
+	}
+	android.AssertDeepEquals(t, "with uses libraries",
+		[]string(nil), classLoaderContextLibsWithoutUsesLibs(withUsesLibs))
+}
+
+func TestManifestFixerExtractNativeLibsOverride(t *testing.T) {
+	testCases := []struct {
+		name          string
+		properties    string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:       "forced true",
+			properties: `min_sdk_version: "29", use_embedded_native_libs: true, extract_native_libs: true,`,
+			expected:   "--extract-native-libs=true",
+		},
+		{
+			name:       "forced false",
+			properties: `min_sdk_version: "29", use_embedded_native_libs: false, extract_native_libs: false,`,
+			expected:   "--extract-native-libs=false",
+		},
+		{
+			name:       "forced true before 23",
+			properties: `min_sdk_version: "21", extract_native_libs: true,`,
+			expected:   "--extract-native-libs=true",
+		},
+		{
+			name:          "forced false before 23",
+			properties:    `min_sdk_version: "21", extract_native_libs: false,`,
+			expectedError: `module attempted to store uncompressed native libraries, but minSdkVersion=21 doesn't support it`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.properties + `
+				}
+			`
+
+			if tc.expectedError != "" {
+				PrepareForTestWithJavaDefaultModules.
+					ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)).
+					RunTestWithBp(t, bp)
+				return
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+			args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			android.AssertStringDoesContain(t, "manifest fixer args", args, tc.expected)
+		})
+	}
+}
+
+func TestManifestFixerRequestRawExternalStorageAccess(t *testing.T) {
+	testCases := []struct {
+		name     string
+		property string
+		expected string
+	}{
+		{
+			name:     "true",
+			property: "request_raw_external_storage_access: true,",
+			expected: "--request-raw-external-storage-access=true",
+		},
+		{
+			name:     "false",
+			property: "request_raw_external_storage_access: false,",
+			expected: "--request-raw-external-storage-access=false",
+		},
+		{
+			name: "unset",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.property + `
+				}
+			`
+
+			result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+			args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			if tc.expected != "" {
+				android.AssertStringDoesContain(t, "manifest fixer args", args, tc.expected)
+			} else {
+				android.AssertStringDoesNotContain(t, "manifest fixer args", args,
+					"--request-raw-external-storage-access")
+			}
+		})
+	}
+}
+
+func TestManifestFixerPreserveLegacyExternalStorage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		property string
+		expected string
+	}{
+		{
+			name:     "true",
+			property: "preserve_legacy_external_storage: true,",
+			expected: "--preserve-legacy-external-storage=true",
+		},
+		{
+			name:     "false",
+			property: "preserve_legacy_external_storage: false,",
+			expected: "--preserve-legacy-external-storage=false",
+		},
+		{
+			name: "unset",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.property + `
+				}
+			`
+
+			result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+			args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			if tc.expected != "" {
+				android.AssertStringDoesContain(t, "manifest fixer args", args, tc.expected)
+			} else {
+				android.AssertStringDoesNotContain(t, "manifest fixer args", args,
+					"--preserve-legacy-external-storage")
+			}
+		})
+	}
+}
+
+func TestManifestFixerHardwareAcceleratedActivitiesInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			hardware_accelerated_activities: ["com.android.app.LegacyActivity:off"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`hardware_accelerated_activities: invalid value "off" for "com.android.app.LegacyActivity"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestCheckInstrumentationTarget(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "renamed",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			package_name: "com.android.renamed",
+		}
+
+		android_library {
+			name: "helper_lib",
+			sdk_version: "current",
+		}
+
+		android_test_helper_app {
+			name: "helper",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			static_libs: ["helper_lib"],
+			manifest_values: {
+				applicationId: "com.android.helper",
+			},
+		}
+
+		android_test {
+			name: "foo_test",
+			instrumentation_for: "foo",
+			sdk_version: "current",
+		}
+
+		android_test {
+			name: "renamed_test",
+			instrumentation_for: "renamed",
+			sdk_version: "current",
+		}
+
+		android_test {
+			name: "helper_test",
+			instrumentation_for: "helper",
+			sdk_version: "current",
+		}
+
+		android_test {
+			name: "explicit_test",
+			instrumentation_for: "foo",
+			instrumentation_target_package: "com.android.explicit",
+			sdk_version: "current",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	fooTest := result.ModuleForTests("foo_test", "android_common")
+	cmd := fooTest.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--instrumentation-target-manifest out/soong/.intermediates/foo/android_common/manifest_fixer/AndroidManifest.xml")
+
+	renamedTest := result.ModuleForTests("renamed_test", "android_common")
+	cmd = renamedTest.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--instrumentation-target-package com.android.renamed")
+
+	helperTest := result.ModuleForTests("helper_test", "android_common")
+	cmd = helperTest.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--instrumentation-target-package com.android.helper")
+
+	explicitTest := result.ModuleForTests("explicit_test", "android_common")
+	android.AssertPathRelativeToTopEquals(t, "unchecked manifest",
+		"out/soong/.intermediates/explicit_test/android_common/manifest_fixer/AndroidManifest.xml",
+		explicitTest.Module().(*AndroidTest).mergedManifestFile)
+}
+
+func TestManifestCheckInstrumentationAttributes(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_test {
+			name: "foo_test",
+			instrumentation_for: "foo",
+			instrumentation_target_package: "com.android.foo",
+			sdk_version: "current",
+			required_instrumentation_attributes: [
+				"handleProfiling:false",
+				"functionalTest",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("foo_test", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--required-instrumentation-attribute functionalTest --required-instrumentation-attribute handleProfiling=false")
+}
+
+func TestManifestCheckInstrumentationAttributesInvalid(t *testing.T) {
+	bp := `
+		android_test {
+			name: "foo_test",
+			sdk_version: "current",
+			required_instrumentation_attributes: ["handle-profiling"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid entry "handle-profiling", must be "<attribute>" or "<attribute>:<value>"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerVersion(t *testing.T) {
+	bp := `
+		android_app {
+			name: "literal",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			version_code: "42",
+			version_name: "1.2 beta",
+		}
+
+		android_app {
+			name: "file",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			version_code_file: "version_code.txt",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddTextFile("version_code.txt", "42"),
+	).RunTestWithBp(t, bp)
+
+	literal := result.ModuleForTests("literal", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "manifest fixer args", literal.Args["args"],
+		"--version-code 42 --version-name '1.2 beta'")
+
+	file := result.ModuleForTests("file", "android_common").Output("manifest_fixer/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "manifest fixer args", file.Args["args"],
+		"--version-code-file version_code.txt")
+	android.AssertPathsRelativeToTopEquals(t, "implicits", []string{"version_code.txt"}, file.Implicits)
+}
+
+func TestManifestFixerVersionCodeInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			version_code: "1.0",
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid versionCode "1.0"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestCheckDeprecatedPermissions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestDeprecatedPermissions = []string{
+				"android.permission.OLD:android.permission.NEW",
+			}
+			variables.ManifestDeprecatedPermissionsWarnOnly = proptools.BoolPtr(true)
+		}),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	cmd := app.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--deprecated-permission android.permission.OLD=android.permission.NEW")
+	android.AssertStringDoesContain(t, "check command", cmd, "--deprecated-permissions-warn-only")
+}
+
+func TestManifestCheckGoldenManifest(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			golden_manifest: "app/golden/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddTextFile("app/golden/AndroidManifest.xml", ""),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	check := app.Output("manifest_post_merge_check/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"--golden-manifest app/golden/AndroidManifest.xml")
+	android.AssertPathRelativeToTopEquals(t, "final manifest",
+		"out/soong/.intermediates/app/android_common/manifest_post_merge_check/AndroidManifest.xml",
+		app.Module().(*AndroidApp).mergedManifestFile)
+}
+
+func TestManifestFixerClearTaskAffinity(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			clear_task_affinity: "exported",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args", "--clear-task-affinity exported",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestFixerClearTaskAffinityInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			clear_task_affinity: "launcher",
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`clear_task_affinity: invalid value "launcher"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestLauncherActivitiesExported(t *testing.T) {
+	bp := `
+		android_app {
+			name: "fix",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			launcher_activities_exported: "fix",
+		}
+
+		android_app {
+			name: "validate",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			launcher_activities_exported: "validate",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	fix := result.ModuleForTests("fix", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args", "--export-launcher-activities",
+		fix.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+	android.AssertBoolEquals(t, "check rule exists", false,
+		fix.MaybeOutput("manifest_post_merge_check/AndroidManifest.xml").Rule != nil)
+
+	validate := result.ModuleForTests("validate", "android_common")
+	android.AssertBoolEquals(t, "post-merge fixer rule exists", false,
+		validate.MaybeOutput("manifest_fixer_post_merge/AndroidManifest.xml").Rule != nil)
+	android.AssertStringDoesContain(t, "check command",
+		validate.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command,
+		"--check-launcher-activities-exported")
+}
+
+func TestManifestBroadcastReceiversExported(t *testing.T) {
+	bp := `
+		android_app {
+			name: "fix",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			broadcast_receivers_exported: "fix",
+		}
+
+		android_app {
+			name: "validate",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			broadcast_receivers_exported: "validate",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestProtectedBroadcasts = []string{"android.intent.action.BOOT_COMPLETED"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	fix := result.ModuleForTests("fix", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--export-broadcast-receivers --protected-broadcast android.intent.action.BOOT_COMPLETED",
+		fix.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+	android.AssertBoolEquals(t, "check rule exists", false,
+		fix.MaybeOutput("manifest_post_merge_check/AndroidManifest.xml").Rule != nil)
+
+	validate := result.ModuleForTests("validate", "android_common")
+	android.AssertBoolEquals(t, "post-merge fixer rule exists", false,
+		validate.MaybeOutput("manifest_fixer_post_merge/AndroidManifest.xml").Rule != nil)
+	android.AssertStringDoesContain(t, "check command",
+		validate.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command,
+		"--check-broadcast-receivers-exported --protected-broadcast android.intent.action.BOOT_COMPLETED")
+}
+
+func TestManifestCheckSharedUserIdWarning(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestSharedUserIdWarnTargetSdkVersion = proptools.IntPtr(33)
+		}),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	cmd := app.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--shared-user-id-warn-target-sdk-version 33 --module-name app")
+}
+
+func TestManifestFixerApplicationProperties(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			application_properties: [
+				{
+					name: "android.window.PROPERTY_B",
+					resource: "@xml/b",
+				},
+				{
+					name: "android.window.PROPERTY_A",
+					value: "true",
+				},
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args,
+		"--application-property android.window.PROPERTY_A=true "+
+			"--application-resource-property android.window.PROPERTY_B=@xml/b")
+}
+
+func TestManifestFixerApplicationPropertiesInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			application_properties: [
+				{
+					name: "android.window.PROPERTY_A",
+					value: "true",
+					resource: "@xml/a",
+				},
+			],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`exactly one of value and resource must be set for "android.window.PROPERTY_A"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestFixerOmitCompileSdkVersion(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+
+		android_app {
+			name: "omit",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			omit_compile_sdk_version: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringDoesNotContain(t, "manifest fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"], "--remove-compile-sdk-version")
+	android.AssertStringDoesNotContain(t, "aapt2 link flags",
+		app.Output("package-res.apk").Args["flags"], "--no-compile-sdk-metadata")
+
+	omit := result.ModuleForTests("omit", "android_common")
+	android.AssertStringDoesContain(t, "manifest fixer args",
+		omit.Output("manifest_fixer/AndroidManifest.xml").Args["args"], "--remove-compile-sdk-version")
+	android.AssertStringDoesContain(t, "aapt2 link flags",
+		omit.Output("package-res.apk").Args["flags"], "--no-compile-sdk-metadata")
+}
+
+func TestManifestFixerNumericSdkVersions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+
+		android_app {
+			name: "numeric",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			numeric_sdk_versions: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Platform_sdk_codename = proptools.StringPtr("Tiramisu")
+			variables.Platform_sdk_version = proptools.IntPtr(32)
+			variables.Platform_version_active_codenames = []string{"Tiramisu"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	args := app.Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--targetSdkVersion  Tiramisu")
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--minSdkVersion  Tiramisu")
+	android.AssertStringDoesNotContain(t, "aapt2 link flags",
+		app.Output("package-res.apk").Args["flags"], "--compile-sdk-version-name")
+
+	numeric := result.ModuleForTests("numeric", "android_common")
+	args = numeric.Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--targetSdkVersion  10000")
+	android.AssertStringDoesContain(t, "manifest fixer args", args, "--minSdkVersion  10000")
+	android.AssertStringDoesContain(t, "aapt2 link flags",
+		numeric.Output("package-res.apk").Args["flags"], "--compile-sdk-version-name 10000")
+}
+
+func TestManifestCheckSignatureProtectedComponents(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+		}
+
+		android_app {
+			name: "enforced",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			enforce_signature_protected_components: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertPathRelativeToTopEquals(t, "unchecked manifest",
+		"out/soong/.intermediates/app/android_common/manifest_fixer/AndroidManifest.xml",
+		app.Module().(*AndroidApp).mergedManifestFile)
+
+	enforced := result.ModuleForTests("enforced", "android_common")
+	cmd := enforced.Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--enforce-signature-protected-components")
+}
+
+func TestManifestCheckProviderAuthorities(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_provider_authorities: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--check-provider-authorities")
+}
+
+func TestManifestCheckFileProviderPaths(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			static_libs: ["lib"],
+			check_file_provider_paths: true,
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddTextFile("lib/AndroidManifest.xml",
+			`<manifest package="com.android.lib"><application><provider `+
+				`android:name="androidx.core.content.FileProvider" android:grantUriPermissions="true" `+
+				`android:authorities="com.android.lib.files"/></application></manifest>`),
+	).RunTestWithBp(t, bp)
+
+	// The FileProvider of the static library is checked in the merged manifest.
+	check := result.ModuleForTests("app", "android_common").Output("manifest_post_merge_check/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command, "--check-file-provider-paths")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+}
+
+func TestManifestCheckComponentFeatureFlags(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_component_feature_flags: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ManifestEnabledFeatureFlags = []string{"new_ui", "cloud_sync"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--check-component-feature-flags --enabled-feature-flag new_ui --enabled-feature-flag cloud_sync")
+}
+
+func TestManifestCheckUsesPermissionBudget(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			static_libs: ["lib"],
+			uses_permission_budget: 2,
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--uses-permission-budget 2")
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+
+	bp = `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			uses_permission_budget: -1,
+		}
+	`
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`uses_permission_budget: must not be negative, got -1`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestCheckExportedServicePermissions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_exported_service_permissions: true,
+			open_exported_services: ["com.android.foo.OpenService"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--check-exported-service-permissions --open-exported-service com.android.foo.OpenService")
+}
+
+func TestManifestCheckOpenExportedServicesInvalid(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_exported_service_permissions: true,
+			open_exported_services: ["com.android.foo.Open Service"],
+		}
+	`
+
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid service "com.android.foo.Open Service", must be a class name`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestManifestCheckExportedProviderPermissions(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_exported_provider_permissions: true,
+			open_exported_providers: ["com.android.foo.OpenProvider"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd,
+		"--check-exported-provider-permissions --open-exported-provider com.android.foo.OpenProvider")
+}
+
+func TestManifestCheckStructure(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_manifest_structure: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--check-structure")
+}
+
+func TestManifestCheckTargetSandboxVersion(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_target_sandbox_version: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--check-target-sandbox-version")
+}
+
+func TestManifestCheckIconResources(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_icon_resources: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	check := app.Output("manifest_icon_resources/check.stamp")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"--check-icon-resources --r-txt out/soong/.intermediates/app/android_common/R.txt")
+	android.AssertStringListContains(t, "apk implicits",
+		app.Output("app-unsigned.apk").Implicits.RelativeToTop().Strings(),
+		"out/soong/.intermediates/app/android_common/manifest_icon_resources/check.stamp")
+}
+
+func TestManifestCheckOverlayable(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			resource_dirs: ["app/res"],
+			overlayable_package: "com.android.app",
+		}
+
+		override_android_app {
+			name: "override_app",
+			base: "app",
+			package_name: "com.android.override",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.MockFS{
+			"app/res/values/overlayable.xml": nil,
+			"app/res/drawable/icon.png":      nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	check := app.Output("manifest_overlayable/check.stamp")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"--overlayable-package com.android.app --resource-file app/res/values/overlayable.xml")
+	android.AssertStringDoesNotContain(t, "check command", check.RuleParams.Command, "icon.png")
+	android.AssertStringDoesNotContain(t, "check command", check.RuleParams.Command, "--app-package")
+	android.AssertStringListContains(t, "apk implicits",
+		app.Output("app-unsigned.apk").Implicits.RelativeToTop().Strings(),
+		"out/soong/.intermediates/app/android_common/manifest_overlayable/check.stamp")
+
+	override := result.ModuleForTests("app", "android_common_override_app")
+	android.AssertStringDoesContain(t, "override check command",
+		override.Output("manifest_overlayable/check.stamp").RuleParams.Command,
+		"--app-package com.android.override")
+}
+
+func TestManifestCheckIntentFilterPriorities(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			check_intent_filter_priorities: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	cmd := result.ModuleForTests("app", "android_common").
+		Output("manifest_post_merge_check/AndroidManifest.xml").RuleParams.Command
+	android.AssertStringDoesContain(t, "check command", cmd, "--check-intent-filter-priorities")
+}
+
+func TestManifestCheckMergedTargetSdkVersion(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			target_sdk_version: "30",
+			srcs: ["app/app.java"],
+			static_libs: ["lib"],
+			check_merged_target_sdk_version: true,
+		}
+
+		android_library {
+			name: "lib",
+			sdk_version: "current",
+			manifest: "lib/AndroidManifest.xml",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddTextFile("lib/AndroidManifest.xml",
+			`<manifest package="com.android.lib"><uses-sdk android:targetSdkVersion="28"/></manifest>`),
+	).RunTestWithBp(t, bp)
+
+	check := result.ModuleForTests("app", "android_common").Output("manifest_post_merge_check/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"--expected-target-sdk-version 30")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"out/soong/.intermediates/app/android_common/manifest_merger/AndroidManifest.xml")
+}
+
+func TestManifestCheckMergedMinSdkVersion(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			min_sdk_version: "29",
+			srcs: ["app/app.java"],
+			manifest: "app/AndroidManifest.xml",
+			check_merged_min_sdk_version: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureAddTextFile("app/AndroidManifest.xml",
+			`<manifest package="com.android.app"><uses-sdk android:minSdkVersion="31"/></manifest>`),
+	).RunTestWithBp(t, bp)
+
+	// The manifest declares a higher minSdkVersion than min_sdk_version, which the manifest fixer
+	// keeps, so the check fails when the rule runs.
+	check := result.ModuleForTests("app", "android_common").Output("manifest_post_merge_check/AndroidManifest.xml")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"--expected-min-sdk-version 29")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"out/soong/.intermediates/app/android_common/manifest_fixer/AndroidManifest.xml")
+}
+
+func TestManifestFixerDisabledComponents(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			disabled_components: [
+				"com.android.app.SyncService",
+				".FeatureActivity",
+			],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	android.AssertStringEquals(t, "post-merge manifest fixer args",
+		"--disable-component .FeatureActivity --disable-component com.android.app.SyncService",
+		app.Output("manifest_fixer_post_merge/AndroidManifest.xml").Args["args"])
+}
+
+func TestManifestMetadataInfo(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "current",
+		}
+
+		java_sdk_library {
+			name: "bar",
+			srcs: ["a.java"],
+			api_packages: ["bar"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			min_sdk_version: "29",
+			uses_libs: ["foo"],
+			optional_uses_libs: ["bar"],
+		}
+
+		android_library {
+			name: "lib",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("foo", "bar"),
+	).RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common").Module()
+	info, ok := android.SingletonModuleProvider(result, app, ManifestMetadataInfoProvider)
+	android.AssertBoolEquals(t, "has ManifestMetadataInfo", true, ok)
+	android.AssertPathRelativeToTopEquals(t, "manifest",
+		android.PathRelativeToTop(app.(*AndroidApp).mergedManifestFile), info.Manifest)
+	android.AssertBoolEquals(t, "is library", false, info.IsLibrary)
+	android.AssertStringEquals(t, "package name", "", info.PackageName)
+	android.AssertStringEquals(t, "min sdk version", "29", info.MinSdkVersion)
+	android.AssertStringEquals(t, "compile sdk version", "current", info.CompileSdkVersion)
+	android.AssertDeepEquals(t, "uses libraries", []string{"foo"}, info.UsesLibraries)
+	android.AssertDeepEquals(t, "optional uses libraries", []string{"bar"}, info.OptionalUsesLibraries)
+
+	lib := result.ModuleForTests("lib", "android_common").Module()
+	info, _ = android.SingletonModuleProvider(result, lib, ManifestMetadataInfoProvider)
+	android.AssertBoolEquals(t, "is library", true, info.IsLibrary)
+}
+
+func TestManifestMetadataInfoApplicationClass(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_library {
+			name: "lib",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	info, _ := android.SingletonModuleProvider(result, app.Module(), ManifestMetadataInfoProvider)
+	android.AssertPathRelativeToTopEquals(t, "application class",
+		"out/soong/.intermediates/app/android_common/manifest_application_class/application_class.txt",
+		info.ApplicationClass)
+	cmd := app.Output("manifest_application_class/application_class.txt").RuleParams.Command
+	android.AssertStringDoesContain(t, "extract command", cmd,
+		android.PathRelativeToTop(info.Manifest))
+
+	lib := result.ModuleForTests("lib", "android_common")
+	info, _ = android.SingletonModuleProvider(result, lib.Module(), ManifestMetadataInfoProvider)
+	android.AssertBoolEquals(t, "library application class", true, info.ApplicationClass == nil)
+}
+
+func TestManifestMetadataInfoFixerArgs(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			min_sdk_version: "29",
+			hardware_accelerated_activities: ["com.android.app.LegacyActivity:false"],
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	app := result.ModuleForTests("app", "android_common")
+	info, _ := android.SingletonModuleProvider(result, app.Module(), ManifestMetadataInfoProvider)
+	android.AssertStringListContains(t, "fixer args", info.FixerArgs, "--minSdkVersion ")
+	android.AssertStringListContains(t, "fixer args", info.FixerArgs, "29")
+	android.AssertStringEquals(t, "fixer args",
+		app.Output("manifest_fixer/AndroidManifest.xml").Args["args"], strings.Join(info.FixerArgs, " "))
+	android.AssertDeepEquals(t, "post-merge fixer args",
+		[]string{"--activity-hardware-accelerated", "com.android.app.LegacyActivity=false"},
+		info.PostMergeFixerArgs)
+}
+
+func TestCanonicalManifestFixerArgs(t *testing.T) {
+	a := []string{"--uses-library", "foo", "--minSdkVersion ", "29", "--is-split-required=true",
+		"--uses-library", "bar", "--mark-final"}
+	b := []string{"--mark-final", "--is-split-required=true", "--uses-library", "foo",
+		"--minSdkVersion ", "29", "--uses-library", "bar"}
+
+	expected := "--is-split-required=true --mark-final --minSdkVersion  29 --uses-library foo --uses-library bar"
+	android.AssertStringEquals(t, "first order", expected, strings.Join(canonicalManifestFixerArgs(a), " "))
+	android.AssertStringEquals(t, "second order", expected, strings.Join(canonicalManifestFixerArgs(b), " "))
+}
+
+func TestManifestFixerCanonicalArgs(t *testing.T) {
+	bp := `
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			mark_manifest_final: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_CANONICAL_MANIFEST_FIXER_ARGS": "true",
+		}),
+	).RunTestWithBp(t, bp)
+
+	args := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	// --mark-final is appended last, but sorts before --minSdkVersion and --targetSdkVersion.
+	if mark, minSdk := strings.Index(args, "--mark-final"), strings.Index(args, "--minSdkVersion"); mark < 0 || mark > minSdk {
+		t.Errorf("expected --mark-final before --minSdkVersion in %q", args)
+	}
+	if minSdk, target := strings.Index(args, "--minSdkVersion"), strings.Index(args, "--targetSdkVersion"); minSdk > target {
+		t.Errorf("expected --minSdkVersion before --targetSdkVersion in %q", args)
+	}
+}
+
+func TestManifestFixerTestSuitesMetaData(t *testing.T) {
+	bp := `
+		android_test {
+			name: "test",
+			sdk_version: "current",
+			srcs: ["test/test.java"],
+			test_suites: ["mts-foo", "general-tests"],
+			test_suites_meta_data: true,
+		}
+
+		android_app {
+			name: "app",
+			sdk_version: "current",
+			srcs: ["app/app.java"],
+			test_suites_meta_data: true,
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+
+	testArgs := result.ModuleForTests("test", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesContain(t, "test manifest fixer args", testArgs, "--test-suites-meta-data general-tests,mts-foo")
+
+	appArgs := result.ModuleForTests("app", "android_common").Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+	android.AssertStringDoesNotContain(t, "app manifest fixer args", appArgs, "--test-suites-meta-data")
+}
+
+func TestManifestCheckLineagePackage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		props         string
+		expectedFlag  string
+		expectedError string
+	}{
+		{
+			name: "manifest package",
+			props: `
+				lineage: "lineage.bin",
+				lineage_package: "com.android.foo",
+			`,
+			expectedFlag: "--expected-package com.android.foo",
+		},
+		{
+			name: "matching package_name",
+			props: `
+				lineage: "lineage.bin",
+				lineage_package: "com.android.foo",
+				package_name: "com.android.foo",
+			`,
+		},
+		{
+			name: "mismatched package_name",
+			props: `
+				lineage: "lineage.bin",
+				lineage_package: "com.android.foo",
+				package_name: "com.android.bar",
+			`,
+			expectedError: `package name "com.android.bar" doesn't match the package "com.android.foo" ` +
+				`that the signing lineage was created for`,
+		},
+		{
+			name: "missing lineage",
+			props: `
+				lineage_package: "com.android.foo",
+			`,
+			expectedError: `requires lineage to be set`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := `
+				android_app {
+					name: "app",
+					sdk_version: "current",
+					srcs: ["app/app.java"],
+					` + tc.props + `
+				}
+			`
+
+			errorHandler := android.FixtureExpectsNoErrors
+			if tc.expectedError != "" {
+				errorHandler = android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.expectedError)
+			}
+
+			result := PrepareForTestWithJavaDefaultModules.
+				ExtendWithErrorHandler(errorHandler).
+				RunTestWithBp(t, bp)
+
+			if tc.expectedError != "" {
+				return
+			}
+
+			app := result.ModuleForTests("app", "android_common")
+			if tc.expectedFlag != "" {
+				check := app.Output("manifest_post_merge_check/AndroidManifest.xml")
+				android.AssertStringDoesContain(t, "check command", check.RuleParams.Command, tc.expectedFlag)
+			} else {
+				check := app.MaybeOutput("manifest_post_merge_check/AndroidManifest.xml")
+				android.AssertStringDoesNotContain(t, "check command", check.RuleParams.Command, "--expected-package")
+			}
+		})
+	}
+}
@@ -54,6 +54,8 @@ func RegisterAppBuildComponents(ctx android.RegistrationContext) {
 	ctx.RegisterModuleType("android_app_certificate", AndroidAppCertificateFactory)
 	ctx.RegisterModuleType("override_android_app", OverrideAndroidAppModuleFactory)
 	ctx.RegisterModuleType("override_android_test", OverrideAndroidTestModuleFactory)
+
+	registerAndroidManifestSingleton(ctx)
 }
 
 // AndroidManifest.xml merging
@@ -151,6 +153,11 @@ type overridableAppProperties struct {
 	// Name of the signing certificate lineage file or filegroup module.
 	Lineage *string `android:"path"`
 
+	// The package name that the signing certificate lineage was created for.  If set, the build
+	// fails if the package name of the final manifest doesn't match it, to catch renames that would
+	// break updates signed with the rotated certificates.  Requires lineage.
+	Lineage_package *string
+
 	// For overriding the --rotation-min-sdk-version property of apksig
 	RotationMinSdkVersion *string
 
@@ -182,6 +189,8 @@ type AndroidApp struct {
 
 	overridableAppProperties overridableAppProperties
 
+	manifestProperties appManifestProperties
+
 	jniLibs                  []jniLib
 	installPathForJNISymbols android.Path
 	embeddedJniLibs          bool
@@ -205,6 +214,10 @@ type AndroidApp struct {
 	javaApiUsedByOutputFile android.ModuleOutPath
 
 	privAppAllowlist android.OptionalPath
+
+	manifestFingerprint android.OptionalPath
+
+	manifestCheckParams ManifestCheckParams
 }
 
 func (a *AndroidApp) IsInstallable() bool {
@@ -231,6 +244,10 @@ func (a *AndroidApp) PrivAppAllowlist() android.OptionalPath {
 	return a.privAppAllowlist
 }
 
+func (a *AndroidApp) manifestFingerprintFile() android.OptionalPath {
+	return a.manifestFingerprint
+}
+
 var _ AndroidLibraryDependency = (*AndroidApp)(nil)
 
 type Certificate struct {
@@ -309,6 +326,7 @@ func (a *AndroidApp) DepsMutator(ctx android.BottomUpMutatorContext) {
 	for _, aconfig_declaration := range a.aaptProperties.Flags_packages {
 		ctx.AddDependency(ctx.Module(), aconfigDeclarationTag, aconfig_declaration)
 	}
+	ctx.AddVariationDependencies(nil, rroManifestTag, a.manifestProperties.Rro_manifests...)
 }
 
 func (a *AndroidApp) OverridablePropertiesDepsMutator(ctx android.BottomUpMutatorContext) {
@@ -546,6 +564,24 @@ func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 		}
 	}
 
+	if lineagePackage := String(a.overridableAppProperties.Lineage_package); lineagePackage != "" {
+		if String(a.overridableAppProperties.Lineage) == "" {
+			ctx.PropertyErrorf("lineage_package", "requires lineage to be set")
+		} else if a.overriddenManifestPackageName != "" {
+			// The package is renamed by aapt2 after the manifest is merged, so it can be checked here.
+			if a.overriddenManifestPackageName != lineagePackage {
+				ctx.PropertyErrorf("lineage_package", "package name %q doesn't match the package %q "+
+					"that the signing lineage was created for", a.overriddenManifestPackageName, lineagePackage)
+			}
+		} else {
+			a.manifestCheckParams.ExpectedPackage = lineagePackage
+		}
+	}
+
+	if Bool(a.manifestProperties.Persistent) && !a.Privileged() {
+		ctx.PropertyErrorf("persistent", `android:persistent="true" is only allowed for privileged apps`)
+	}
+
 	// Use non final ids if we are doing optimized shrinking and are using R8.
 	nonFinalIds := a.dexProperties.optimizedResourceShrinkingEnabled(ctx) && a.dexer.effectiveOptimizeEnabled()
 	a.aapt.buildActions(ctx,
@@ -558,6 +594,10 @@ func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 			extraLinkFlags:                 aaptLinkFlags,
 			aconfigTextFiles:               getAconfigFilePaths(ctx),
 			usesLibrary:                    &a.usesLibrary,
+			manifestProperties:             &a.manifestProperties,
+			rroManifests:                   rroManifestsForManifestMerger(ctx, a.overriddenManifestPackageName),
+			manifestCheckParams:            a.manifestCheckParams,
+			renamedPackageName:             a.overriddenManifestPackageName,
 		},
 	)
 
@@ -839,6 +879,10 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 	// The decision to enforce <uses-library> checks is made before adding implicit SDK libraries.
 	a.usesLibrary.freezeEnforceUsesLibraries()
 
+	if manifestFingerprintsEnabled(ctx.Config()) {
+		a.manifestFingerprint = android.OptionalPathForPath(manifestFingerprint(ctx, a.mergedManifestFile))
+	}
+
 	// Check that the <uses-library> list is coherent with the manifest.
 	if a.usesLibrary.enforceUsesLibraries() {
 		manifestCheckFile := a.usesLibrary.verifyUsesLibrariesManifest(
@@ -846,6 +890,15 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 		apkDeps = append(apkDeps, manifestCheckFile)
 	}
 
+	if Bool(a.manifestProperties.Check_icon_resources) {
+		apkDeps = append(apkDeps, checkManifestIconResources(ctx, a.mergedManifestFile, a.rTxt))
+	}
+
+	if a.manifestProperties.Overlayable_package != nil {
+		apkDeps = append(apkDeps, checkManifestOverlayable(ctx, a.mergedManifestFile, a.aapt.resourceFiles,
+			*a.manifestProperties.Overlayable_package, a.overriddenManifestPackageName))
+	}
+
 	a.proguardBuildActions(ctx)
 
 	a.linter.mergedManifest = a.aapt.mergedManifestFile
@@ -866,6 +919,14 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 
 	a.certificate, certificates = processMainCert(a.ModuleBase, a.getCertString(ctx), certificates, ctx)
 
+	if Bool(a.manifestProperties.Enforce_uses_library_certificates) {
+		checkUsesLibraryCertificates(ctx, a.classLoaderContexts, a.getCertString(ctx), a.certificate)
+	}
+
+	if Bool(a.manifestProperties.Check_uses_library_modules) {
+		checkUsesLibraryModules(ctx)
+	}
+
 	// Build a final signed app package.
 	packageFile := android.PathForModuleOut(ctx, a.installApkName+".apk")
 	v4SigningRequested := Bool(a.Module.deviceProperties.V4_signature)
@@ -1177,6 +1238,30 @@ func (a *AndroidApp) OutputFiles(tag string) (android.Paths, error) {
 		return []android.Path{a.exportPackage}, nil
 	case ".manifest.xml":
 		return []android.Path{a.aapt.manifestPath}, nil
+	case ".manifest_merged.xml":
+		// The output of the manifest merger before the post-merge fixes are applied, or the source
+		// manifest if there was nothing to merge.
+		return []android.Path{a.aapt.unfixedMergedManifestFile}, nil
+	case ".manifest_lib_packages":
+		if a.aapt.manifestLibPackagesFile != nil {
+			return []android.Path{a.aapt.manifestLibPackagesFile}, nil
+		}
+	case ".manifest_merge_changes.json":
+		if a.aapt.manifestMergeChangesFile != nil {
+			return []android.Path{a.aapt.manifestMergeChangesFile}, nil
+		}
+	case ".manifest_lib_permissions.xml":
+		if a.aapt.manifestLibPermissionsFile != nil {
+			return []android.Path{a.aapt.manifestLibPermissionsFile}, nil
+		}
+	case ".manifest_compat_report.json":
+		if a.aapt.manifestCompatReportFile != nil {
+			return []android.Path{a.aapt.manifestCompatReportFile}, nil
+		}
+	case ".component_inventory.json":
+		if a.aapt.manifestComponentInventoryFile != nil {
+			return []android.Path{a.aapt.manifestComponentInventoryFile}, nil
+		}
 	}
 	return a.Library.OutputFiles(tag)
 }
@@ -1231,6 +1316,7 @@ func AndroidAppFactory() android.Module {
 		&module.aaptProperties,
 		&module.appProperties,
 		&module.overridableAppProperties,
+		&module.manifestProperties,
 		&module.Library.sourceProperties)
 
 	module.usesLibrary.enforce = true
@@ -1315,6 +1401,11 @@ type appTestProperties struct {
 	// If specified, the mainline module package name in the test config is overwritten by it.
 	Mainline_package_name *string
 
+	// list of "<attribute>" or "<attribute>:<value>" entries for android: attributes that every
+	// <instrumentation> tag in the merged manifest must declare, optionally with the given value,
+	// e.g. "handleProfiling:false" or "functionalTest".  The build fails if the manifest deviates.
+	Required_instrumentation_attributes []string
+
 	Manifest_values Manifest_values
 }
 
@@ -1334,18 +1425,75 @@ func (a *AndroidTest) InstallInTestcases() bool {
 	return true
 }
 
+// setInstrumentationTargetCheck makes the manifest check verify that the <instrumentation> tags
+// in the merged manifest target the package of the app listed in instrumentation_for.  It does
+// nothing if the target package is renamed, as aapt2 rewrites it anyway.
+func (a *AndroidTest) setInstrumentationTargetCheck(ctx android.ModuleContext) {
+	if a.appTestProperties.Instrumentation_target_package != nil || a.appTestProperties.Instrumentation_for == nil {
+		return
+	}
+	if _, overridden := ctx.DeviceConfig().OverrideManifestPackageNameFor(*a.appTestProperties.Instrumentation_for); overridden {
+		return
+	}
+
+	ctx.VisitDirectDepsWithTag(instrumentationForTag, func(module android.Module) {
+		info, ok := android.OtherModuleProvider(ctx, module, ManifestMetadataInfoProvider)
+		if !ok {
+			return
+		}
+		if info.PackageName != "" {
+			a.manifestCheckParams.InstrumentationTargetPackage = info.PackageName
+		} else {
+			a.manifestCheckParams.InstrumentationTargetManifest = info.Manifest
+		}
+	})
+}
+
+// setInstrumentationAttributesCheck makes the manifest check verify that the <instrumentation>
+// tags in the merged manifest declare the attributes listed in required_instrumentation_attributes.
+func (a *AndroidTest) setInstrumentationAttributesCheck(ctx android.ModuleContext) {
+	entries := a.appTestProperties.Required_instrumentation_attributes
+	if len(entries) == 0 {
+		return
+	}
+	required := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		attr, value, found := strings.Cut(entry, ":")
+		if !manifestAttributeNameRegexp.MatchString(attr) || (found && value == "") {
+			ctx.PropertyErrorf("required_instrumentation_attributes",
+				"invalid entry %q, must be \"<attribute>\" or \"<attribute>:<value>\"", entry)
+			continue
+		}
+		if _, exists := required[attr]; exists {
+			ctx.PropertyErrorf("required_instrumentation_attributes", "duplicate entry for %q", attr)
+			continue
+		}
+		required[attr] = value
+	}
+	a.manifestCheckParams.RequiredInstrumentationAttributes = required
+}
+
 type androidTestApp interface {
 	includedInTestSuite(searchPrefix string) bool
+	testSuites() []string
 }
 
 func (a *AndroidTest) includedInTestSuite(searchPrefix string) bool {
 	return android.PrefixInList(a.testProperties.Test_suites, searchPrefix)
 }
 
+func (a *AndroidTest) testSuites() []string {
+	return a.testProperties.Test_suites
+}
+
 func (a *AndroidTestHelperApp) includedInTestSuite(searchPrefix string) bool {
 	return android.PrefixInList(a.appTestHelperAppProperties.Test_suites, searchPrefix)
 }
 
+func (a *AndroidTestHelperApp) testSuites() []string {
+	return a.appTestHelperAppProperties.Test_suites
+}
+
 func (a *AndroidTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	var configs []tradefed.Config
 	if a.appTestProperties.Instrumentation_target_package != nil {
@@ -1365,6 +1513,8 @@ func (a *AndroidTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		}
 		a.aapt.manifestValues.applicationId = *applicationId
 	}
+	a.setInstrumentationTargetCheck(ctx)
+	a.setInstrumentationAttributesCheck(ctx)
 	a.generateAndroidBuildActions(ctx)
 
 	for _, module := range a.testProperties.Test_mainline_modules {
@@ -1463,6 +1613,7 @@ func AndroidTestFactory() android.Module {
 		&module.appProperties,
 		&module.appTestProperties,
 		&module.overridableAppProperties,
+		&module.manifestProperties,
 		&module.testProperties)
 
 	android.InitAndroidMultiTargetsArchModule(module, android.DeviceSupported, android.MultilibCommon)
@@ -1518,7 +1669,8 @@ func AndroidTestHelperAppFactory() android.Module {
 		&module.aaptProperties,
 		&module.appProperties,
 		&module.appTestHelperAppProperties,
-		&module.overridableAppProperties)
+		&module.overridableAppProperties,
+		&module.manifestProperties)
 
 	android.InitAndroidMultiTargetsArchModule(module, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(module)
@@ -82,6 +82,10 @@ type RuntimeResourceOverlayProperties struct {
 	// overlays would be installed by default (in PRODUCT_PACKAGES) the other overlay will be removed
 	// from PRODUCT_PACKAGES.
 	Overrides []string
+
+	// If true, the manifest of this overlay is not merged into apps that list it in their
+	// rro_manifests property.
+	Exclude_from_manifest_merge *bool
 }
 
 // RuntimeResourceOverlayModule interface is used by the apex package to gather information from
@@ -18,9 +18,11 @@
 
 from __future__ import print_function
 from xml.dom import minidom
+import xml.etree.ElementTree as ET
 
 
 android_ns = 'http://schemas.android.com/apk/res/android'
+tools_ns = 'http://schemas.android.com/tools'
 
 
 def get_children_with_tag(parent, tag_name):
@@ -65,6 +67,67 @@ def ensure_manifest_android_ns(doc):
                        ns.value)
 
 
+def resolve_class_name(package, name):
+  """Returns the fully qualified form of a class name from the manifest."""
+  if name.startswith('.'):
+    return package + name
+  if '.' not in name:
+    return package + '.' + name
+  return name
+
+
+def is_exported(component):
+  """Returns True if the component can be started by other apps."""
+  exported = component.getAttributeNS(android_ns, 'exported')
+  if exported:
+    return exported == 'true'
+  # Before Android 12 components with intent filters were exported by default.
+  return bool(get_children_with_tag(component, 'intent-filter'))
+
+
+def find_launcher_activities_without_exported(doc):
+  """Returns the launcher activities that don't declare android:exported.
+
+  Launcher activities are the <activity> and <activity-alias> tags with an intent filter in the
+  android.intent.category.LAUNCHER category.  Apps targeting Android 12 or later must declare
+  android:exported on them.
+  """
+  manifest = parse_manifest(doc)
+  activities = []
+  for application in get_children_with_tag(manifest, 'application'):
+    for tag in ['activity', 'activity-alias']:
+      for activity in get_children_with_tag(application, tag):
+        if activity.hasAttributeNS(android_ns, 'exported'):
+          continue
+        for intent_filter in get_children_with_tag(activity, 'intent-filter'):
+          if find_child_with_attribute(intent_filter, 'category', android_ns, 'name',
+                                       'android.intent.category.LAUNCHER') is not None:
+            activities.append(activity)
+            break
+  return activities
+
+
+def find_receivers_without_exported(doc, protected_broadcasts):
+  """Returns the receivers for implicit broadcasts that don't declare android:exported.
+
+  These are the <receiver> tags with an intent filter for an action that isn't one of the
+  protected broadcasts, which only the system can send.  Apps targeting Android 12 or later must
+  declare android:exported on them.
+  """
+  manifest = parse_manifest(doc)
+  receivers = []
+  for application in get_children_with_tag(manifest, 'application'):
+    for receiver in get_children_with_tag(application, 'receiver'):
+      if receiver.hasAttributeNS(android_ns, 'exported'):
+        continue
+      actions = [action.getAttributeNS(android_ns, 'name')
+                 for intent_filter in get_children_with_tag(receiver, 'intent-filter')
+                 for action in get_children_with_tag(intent_filter, 'action')]
+      if any(action not in protected_broadcasts for action in actions):
+        receivers.append(receiver)
+  return receivers
+
+
 def parse_test_config(doc):
   """ Get the configuration element. """
 
@@ -120,6 +183,16 @@ def get_indent(element, default_level):
   return indent
 
 
+def canonicalize(doc):
+  """Returns the canonical form of an XML document.
+
+  The canonical form is C14N 2.0 with whitespace-only text dropped, so that
+  documents that only differ in formatting, attribute order or comments have
+  the same canonical form.
+  """
+  return ET.canonicalize(doc.documentElement.toxml(), strip_text=True)
+
+
 def write_xml(f, doc):
   f.write('<?xml version="1.0" encoding="utf-8"?>\n')
   for node in doc.childNodes:
@@ -19,6 +19,8 @@
 from __future__ import print_function
 
 import argparse
+import difflib
+import hashlib
 import json
 import re
 import subprocess
@@ -26,8 +28,14 @@ import sys
 from xml.dom import minidom
 
 from manifest import android_ns
+from manifest import canonicalize
+from manifest import find_launcher_activities_without_exported
+from manifest import find_receivers_without_exported
 from manifest import get_children_with_tag
+from manifest import is_exported
 from manifest import parse_manifest
+from manifest import resolve_class_name
+from manifest import tools_ns
 from manifest import write_xml
 
 
@@ -80,11 +88,267 @@ def parse_args():
         dest='extract_target_sdk_version',
         action='store_true',
         help='print the targetSdkVersion from the manifest')
+    parser.add_argument(
+        '--application-class-output',
+        dest='application_class_output',
+        help='output file to store the fully qualified android:name of '
+        '<application>, or nothing if it declares none')
+    parser.add_argument(
+        '--component-inventory-output',
+        dest='component_inventory_output',
+        help='output file to store a JSON list of the components of the '
+        'manifest with their exported status')
+    parser.add_argument(
+        '--baseline-manifest',
+        dest='baseline_manifest',
+        help='the manifest of a prior release to compare the input manifest '
+        'with, for --compat-report-output')
+    parser.add_argument(
+        '--compat-report-output',
+        dest='compat_report_output',
+        help='output file to store a JSON report of the permissions, '
+        'components and SDK versions of the input manifest that changed '
+        'relative to --baseline-manifest')
     parser.add_argument(
         '--dexpreopt-config',
         dest='dexpreopt_configs',
         action='append',
         help='a paths to a dexpreopt.config of some library')
+    parser.add_argument(
+        '--fingerprint',
+        dest='fingerprint',
+        help='output file to store a hash of the canonicalized manifest')
+    parser.add_argument(
+        '--lib-manifest',
+        dest='lib_manifests',
+        action='append',
+        default=[],
+        help='a static library manifest of the input manifest, for '
+        '--check-lib-packages, --check-duplicate-components, '
+        '--lib-packages-output and --lib-permissions-output')
+    parser.add_argument(
+        '--check-lib-packages',
+        dest='check_lib_packages',
+        action='store_true',
+        help='check that the --lib-manifest manifests declare the package of '
+        'the input manifest')
+    parser.add_argument(
+        '--lib-packages-output',
+        dest='lib_packages_output',
+        help='output file to store the packages declared by the --lib-manifest '
+        'manifests as aapt2 --extra-packages flags')
+    parser.add_argument(
+        '--unmerged-manifest',
+        dest='unmerged_manifest',
+        help='the main manifest that was merged into the input manifest, for '
+        '--merge-changes-output and --lib-permissions-output')
+    parser.add_argument(
+        '--merge-changes-output',
+        dest='merge_changes_output',
+        help='output file to store a JSON list of the nodes and attributes of '
+        'the input manifest that were added or overridden relative to '
+        '--unmerged-manifest')
+    parser.add_argument(
+        '--lib-permissions-output',
+        dest='lib_permissions_output',
+        help='output file to store a copy of the input manifest in which the '
+        '<uses-permission> tags that are not in --unmerged-manifest are moved '
+        'into a section that names the --lib-manifest manifests requesting '
+        'them')
+    parser.add_argument(
+        '--check-duplicate-components',
+        dest='check_duplicate_components',
+        action='store_true',
+        help='check that the input manifest and the --lib-manifest manifests '
+        'do not declare the same component more than once')
+    parser.add_argument(
+        '--allowed-lib-package',
+        dest='allowed_lib_packages',
+        action='append',
+        default=[],
+        help='a package that static library manifests may declare even if it '
+        'does not match the package of the input manifest')
+    parser.add_argument(
+        '--app-package',
+        dest='app_package',
+        help='the package of the app, if it is overridden by the build system')
+    parser.add_argument(
+        '--instrumentation-target-package',
+        dest='instrumentation_target_package',
+        help='the package that the <instrumentation> tags must target')
+    parser.add_argument(
+        '--instrumentation-target-manifest',
+        dest='instrumentation_target_manifest',
+        help='the manifest of the app whose package the <instrumentation> tags '
+        'must target')
+    parser.add_argument(
+        '--expected-target-sdk-version',
+        dest='expected_target_sdk_version',
+        help='the targetSdkVersion computed by the build system, which the '
+        'manifest must declare')
+    parser.add_argument(
+        '--expected-min-sdk-version',
+        dest='expected_min_sdk_version',
+        help='the minSdkVersion computed by the build system, which the '
+        'manifest must declare')
+    parser.add_argument(
+        '--expected-package',
+        dest='expected_package',
+        help='the package recorded for the signing certificate lineage of the '
+        'app, which the manifest must declare')
+    parser.add_argument(
+        '--required-instrumentation-attribute',
+        dest='required_instrumentation_attributes',
+        action='append',
+        default=[],
+        help='specify <attribute> or <attribute>=<value> for an attribute that '
+        'every <instrumentation> tag must declare')
+    parser.add_argument(
+        '--deprecated-permission',
+        dest='deprecated_permissions',
+        action='append',
+        default=[],
+        help='specify <permission>=<replacement> for a permission that must '
+        'not be requested by the manifest')
+    parser.add_argument(
+        '--deprecated-permissions-warn-only',
+        dest='deprecated_permissions_warn_only',
+        action='store_true',
+        help='only print a warning for requests of deprecated permissions')
+    parser.add_argument(
+        '--enforce-signature-protected-components',
+        dest='enforce_signature_protected_components',
+        action='store_true',
+        help='check that exported components guarded by a permission declared in '
+        'the manifest use a signature protection level')
+    parser.add_argument(
+        '--shared-user-id-warn-target-sdk-version',
+        dest='shared_user_id_warn_target_sdk_version',
+        type=int,
+        help='print a warning if the manifest declares android:sharedUserId '
+        'and targets this SDK version or a later one')
+    parser.add_argument(
+        '--module-name',
+        dest='module_name',
+        help='the name of the module that the manifest belongs to, used in '
+        'warnings')
+    parser.add_argument(
+        '--check-launcher-activities-exported',
+        dest='check_launcher_activities_exported',
+        action='store_true',
+        help='check that activities with a LAUNCHER intent filter declare '
+        'android:exported')
+    parser.add_argument(
+        '--check-broadcast-receivers-exported',
+        dest='check_broadcast_receivers_exported',
+        action='store_true',
+        help='check that receivers with an intent filter for a broadcast that '
+        'is not protected declare android:exported')
+    parser.add_argument(
+        '--protected-broadcast',
+        dest='protected_broadcasts',
+        action='append',
+        default=[],
+        help='a broadcast that only the system can send, for '
+        '--check-broadcast-receivers-exported')
+    parser.add_argument(
+        '--check-component-feature-flags',
+        dest='check_component_feature_flags',
+        action='store_true',
+        help='check that the components gated behind a feature flag are only '
+        'present when the flag is enabled')
+    parser.add_argument(
+        '--enabled-feature-flag',
+        dest='enabled_feature_flags',
+        action='append',
+        default=[],
+        help='a feature flag that is enabled for the product, for '
+        '--check-component-feature-flags')
+    parser.add_argument(
+        '--check-provider-authorities',
+        dest='check_provider_authorities',
+        action='store_true',
+        help='check that providers declare a non-empty android:authorities')
+    parser.add_argument(
+        '--check-file-provider-paths',
+        dest='check_file_provider_paths',
+        action='store_true',
+        help='check that FileProviders granting URI permissions declare their '
+        'paths')
+    parser.add_argument(
+        '--check-intent-filter-priorities',
+        dest='check_intent_filter_priorities',
+        action='store_true',
+        help='print a warning for components of the same kind that declare '
+        'intent filters with the same non-default priority for a common action')
+    parser.add_argument(
+        '--check-exported-service-permissions',
+        dest='check_exported_service_permissions',
+        action='store_true',
+        help='check that exported services are guarded by android:permission')
+    parser.add_argument(
+        '--open-exported-service',
+        dest='open_exported_services',
+        action='append',
+        default=[],
+        help='specify the class name of an exported service that is allowed '
+        'not to be guarded by a permission')
+    parser.add_argument(
+        '--check-exported-provider-permissions',
+        dest='check_exported_provider_permissions',
+        action='store_true',
+        help='check that exported providers are guarded for reading and '
+        'writing by permissions')
+    parser.add_argument(
+        '--open-exported-provider',
+        dest='open_exported_providers',
+        action='append',
+        default=[],
+        help='specify the class name of an exported provider that is allowed '
+        'not to be guarded by permissions')
+    parser.add_argument(
+        '--check-structure',
+        dest='check_structure',
+        action='store_true',
+        help='check that the elements of the manifest are nested in their '
+        'expected parents')
+    parser.add_argument(
+        '--check-target-sandbox-version',
+        dest='check_target_sandbox_version',
+        action='store_true',
+        help='check that the manifest does not declare both '
+        'android:sharedUserId and android:targetSandboxVersion of 2 or higher')
+    parser.add_argument(
+        '--uses-permission-budget',
+        dest='uses_permission_budget',
+        type=int,
+        help='check that the manifest requests at most the given number of '
+        'permissions')
+    parser.add_argument(
+        '--check-icon-resources',
+        dest='check_icon_resources',
+        action='store_true',
+        help='check that the icon resources referenced by the manifest are in '
+        'the R.txt file given with --r-txt')
+    parser.add_argument(
+        '--r-txt',
+        dest='r_txt',
+        help='the R.txt file listing the resources of the app')
+    parser.add_argument(
+        '--overlayable-package',
+        dest='overlayable_package',
+        help='check that the --resource-file files declare an <overlayable> and '
+        'that the input manifest, or --app-package, declares the given package')
+    parser.add_argument(
+        '--resource-file',
+        dest='resource_files',
+        action='append',
+        default=[],
+        help='a values resource file of the app, for --overlayable-package')
+    parser.add_argument(
+        '--golden-manifest',
+        dest='golden_manifest',
+        help='a manifest that the input manifest must match in canonical form')
     parser.add_argument('--aapt', dest='aapt', help='path to aapt executable')
     parser.add_argument(
         '--output', '-o', dest='output', help='output AndroidManifest.xml file')
@@ -308,6 +572,964 @@ def extract_target_sdk_version_xml(xml):
     return target_attr.value
 
 
+def extract_application_class(xml):
+    """Returns the fully qualified android:name of <application>, or None."""
+    manifest = parse_manifest(xml)
+    for application in get_children_with_tag(manifest, 'application'):
+        name = application.getAttributeNS(android_ns, 'name')
+        if name:
+            return resolve_class_name(manifest.getAttribute('package'), name)
+    return None
+
+
+def component_inventory(xml):
+    """Returns the components declared by the manifest with their exported status.
+
+    The components are returned as a list of dicts with the tag, the fully
+    qualified name and the exported status of each component, sorted by tag and
+    name.
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+    components = []
+    for application in get_children_with_tag(manifest, 'application'):
+        for tag in COMPONENT_TAGS:
+            for component in get_children_with_tag(application, tag):
+                name = component.getAttributeNS(android_ns, 'name')
+                components.append({
+                    'tag': tag,
+                    'name': resolve_class_name(package, name),
+                    'exported': is_exported(component),
+                })
+    return sorted(components, key=lambda c: (c['tag'], c['name']))
+
+
+COMPAT_REPORT_SDK_ATTRIBUTES = (
+    'minSdkVersion', 'targetSdkVersion', 'maxSdkVersion')
+
+
+def compat_report(baseline, xml):
+    """Returns the changes of a manifest relative to a prior release.
+
+  Both manifests are canonicalized before they are compared, so that
+  differences in formatting, attribute order and comments are ignored.
+
+  Args:
+    baseline: parsed XML manifest of the prior release
+    xml:      parsed XML manifest
+
+  Returns:
+    a dict with the added and removed permissions, the added and removed
+    components, and the baseline and current values of the changed SDK versions
+    """
+    baseline = minidom.parseString(canonicalize(baseline))
+    xml = minidom.parseString(canonicalize(xml))
+
+    def permissions(doc):
+        return {
+            elem.getAttributeNS(android_ns, 'name')
+            for elem in get_children_with_tag(parse_manifest(doc),
+                                              'uses-permission')
+        }
+
+    def components(doc):
+        return {(c['tag'], c['name']) for c in component_inventory(doc)}
+
+    def sdk_versions(doc):
+        versions = {}
+        for uses_sdk in get_children_with_tag(parse_manifest(doc), 'uses-sdk'):
+            for attr in COMPAT_REPORT_SDK_ATTRIBUTES:
+                if uses_sdk.hasAttributeNS(android_ns, attr):
+                    versions[attr] = uses_sdk.getAttributeNS(android_ns, attr)
+        return versions
+
+    def component_list(keys):
+        return [{'tag': tag, 'name': name} for tag, name in sorted(keys)]
+
+    baseline_sdk = sdk_versions(baseline)
+    current_sdk = sdk_versions(xml)
+    sdk = {}
+    for attr in COMPAT_REPORT_SDK_ATTRIBUTES:
+        if baseline_sdk.get(attr) != current_sdk.get(attr):
+            sdk[attr] = {
+                'baseline': baseline_sdk.get(attr),
+                'current': current_sdk.get(attr),
+            }
+
+    return {
+        'permissions': {
+            'added': sorted(permissions(xml) - permissions(baseline)),
+            'removed': sorted(permissions(baseline) - permissions(xml)),
+        },
+        'components': {
+            'added': component_list(components(xml) - components(baseline)),
+            'removed': component_list(components(baseline) - components(xml)),
+        },
+        'sdk': sdk,
+    }
+
+
+def fingerprint(xml):
+    """Returns a hash of the canonical form of the manifest.
+
+  Manifests that only differ in formatting have the same fingerprint.
+
+  Args:
+    xml: parsed XML manifest
+    """
+    return hashlib.sha256(canonicalize(xml).encode('utf-8')).hexdigest()
+
+
+def diff_canonical(xml, golden):
+    """Returns the differences between the canonical forms of two manifests.
+
+  Args:
+    xml:    parsed XML manifest
+    golden: parsed XML manifest that xml is expected to match
+
+  Returns:
+    a list of unified diff lines, empty if the canonical forms are the same
+    """
+    def lines(doc):
+        pretty = minidom.parseString(canonicalize(doc)).toprettyxml(indent='    ')
+        return pretty.splitlines()[1:]
+
+    return list(difflib.unified_diff(lines(golden), lines(xml), 'golden',
+                                     'actual', lineterm=''))
+
+
+def check_lib_packages(package, libs, allowed):
+    """Verify that static library manifests declare the package of the app.
+
+  Args:
+    package: the package of the app
+    libs:    list of (path, parsed XML manifest) of static libraries
+    allowed: packages that static libraries may declare regardless
+    """
+    mismatches = []
+    for path, lib in libs:
+        lib_package = parse_manifest(lib).getAttribute('package')
+        if lib_package and lib_package != package and lib_package not in allowed:
+            mismatches.append('\t%s declares package "%s"' % (path, lib_package))
+
+    if mismatches:
+        raise ManifestMismatchError(
+            'static library manifests do not match package "%s":\n%s\n'
+            'Add the package to allowed_static_lib_manifest_packages if this is '
+            'intentional.' % (package, '\n'.join(mismatches)))
+
+
+def extract_lib_packages(package, libs):
+    """Returns the sorted packages declared by static library manifests.
+
+  Args:
+    package: the package of the app, which is not included in the result
+    libs:    list of (path, parsed XML manifest) of static libraries
+    """
+    packages = set()
+    for _, lib in libs:
+        lib_package = parse_manifest(lib).getAttribute('package')
+        if lib_package and lib_package != package:
+            packages.add(lib_package)
+    return sorted(packages)
+
+
+LIB_PERMISSIONS_BEGIN = ' BEGIN <uses-permission> merged from static libraries '
+LIB_PERMISSIONS_END = ' END <uses-permission> merged from static libraries '
+
+
+def section_lib_permissions(main, merged, libs):
+    """Move the <uses-permission> tags added by the merge into a section.
+
+  The section is delimited by LIB_PERMISSIONS_BEGIN and LIB_PERMISSIONS_END
+  comments and is placed before <application>, and each tag in it follows a
+  comment naming the static library manifests that request the permission.
+
+  Args:
+    main:   the parsed XML main manifest before the merge
+    merged: the parsed XML merged manifest, modified by this function
+    libs:   list of (path, parsed XML manifest) of the static library manifests
+
+  Returns:
+    a list of the names of the moved permissions
+    """
+    requested = {
+        elem.getAttributeNS(android_ns, 'name')
+        for elem in get_children_with_tag(parse_manifest(main), 'uses-permission')
+    }
+    manifest = parse_manifest(merged)
+
+    moved = []
+    for elem in get_children_with_tag(manifest, 'uses-permission'):
+        if elem.getAttributeNS(android_ns, 'name') in requested:
+            continue
+        previous = elem.previousSibling
+        if (previous is not None and
+                previous.nodeType == minidom.Node.TEXT_NODE and
+                not previous.nodeValue.strip()):
+            manifest.removeChild(previous)
+        manifest.removeChild(elem)
+        moved.append(elem)
+    if not moved:
+        return []
+
+    nodes = [merged.createComment(LIB_PERMISSIONS_BEGIN)]
+    for elem in moved:
+        name = elem.getAttributeNS(android_ns, 'name')
+        sources = [
+            path for path, lib in libs
+            if any(lib_elem.getAttributeNS(android_ns, 'name') == name
+                   for lib_elem in get_children_with_tag(
+                       parse_manifest(lib), 'uses-permission'))
+        ]
+        if sources:
+            nodes.append(merged.createComment(' from %s ' % ', '.join(sources)))
+        else:
+            nodes.append(merged.createComment(' added by the manifest merger '))
+        nodes.append(elem)
+    nodes.append(merged.createComment(LIB_PERMISSIONS_END))
+
+    indent = '\n    '
+    applications = get_children_with_tag(manifest, 'application')
+    if applications:
+        for node in nodes:
+            manifest.insertBefore(node, applications[0])
+            manifest.insertBefore(merged.createTextNode(indent), applications[0])
+    else:
+        last = manifest.lastChild
+        if (last is None or last.nodeType != minidom.Node.TEXT_NODE or
+                last.nodeValue.strip()):
+            last = manifest.appendChild(merged.createTextNode('\n'))
+        for node in nodes:
+            manifest.insertBefore(merged.createTextNode(indent), last)
+            manifest.insertBefore(node, last)
+
+    return [elem.getAttributeNS(android_ns, 'name') for elem in moved]
+
+
+def merge_change_key(package, element, ordinals):
+    """Returns the key and path component that identify a child element.
+
+  Elements are identified by their android:name, components by their fully
+  qualified class name, and elements without a name by their position among
+  the unnamed siblings with the same tag.
+  """
+    name = element.getAttributeNS(android_ns, 'name')
+    if name:
+        if element.tagName in COMPONENT_TAGS:
+            name = resolve_class_name(package, name)
+        return (element.tagName, name), '%s[@android:name="%s"]' % (
+            element.tagName, name)
+    ordinal = ordinals.get(element.tagName, 0)
+    ordinals[element.tagName] = ordinal + 1
+    if ordinal:
+        return (element.tagName, ordinal), '%s[%d]' % (element.tagName,
+                                                       ordinal + 1)
+    return (element.tagName, ordinal), element.tagName
+
+
+def merge_change_children(package, element):
+    """Returns a dict from key to (path component, child) for an element."""
+    children = {}
+    ordinals = {}
+    for child in element.childNodes:
+        if child.nodeType != minidom.Node.ELEMENT_NODE:
+            continue
+        key, path = merge_change_key(package, child, ordinals)
+        children.setdefault(key, (path, child))
+    return children
+
+
+def find_merge_changes(main, merged):
+    """Find the nodes and attributes added or overridden by the merge.
+
+  Namespace declarations, tools: merge directives and the android:name that
+  identifies an element are not reported.
+
+  Args:
+    main:   the parsed XML main manifest before the merge
+    merged: the parsed XML merged manifest
+
+  Returns:
+    a list of dicts sorted by path and attribute, each with the path of the
+    node, the change ("added node", "added attribute" or "overridden
+    attribute"), the attribute, its merged value and its previous value
+    """
+    package = parse_manifest(main).getAttribute('package')
+    changes = []
+
+    def compare(path, before, after):
+        for attr in after.attributes.values():
+            if (attr.name == 'xmlns' or attr.prefix == 'xmlns' or
+                    attr.namespaceURI == tools_ns):
+                continue
+            if attr.namespaceURI == android_ns and attr.localName == 'name':
+                continue
+            if not before.hasAttributeNS(attr.namespaceURI, attr.localName):
+                changes.append({
+                    'path': path,
+                    'change': 'added attribute',
+                    'attribute': attr.name,
+                    'value': attr.value,
+                    'previous': None,
+                })
+                continue
+            previous = before.getAttributeNS(attr.namespaceURI, attr.localName)
+            if previous != attr.value:
+                changes.append({
+                    'path': path,
+                    'change': 'overridden attribute',
+                    'attribute': attr.name,
+                    'value': attr.value,
+                    'previous': previous,
+                })
+
+        before_children = merge_change_children(package, before)
+        for key, (name, child) in merge_change_children(package, after).items():
+            child_path = path + '/' + name
+            if key in before_children:
+                compare(child_path, before_children[key][1], child)
+            else:
+                changes.append({
+                    'path': child_path,
+                    'change': 'added node',
+                    'attribute': None,
+                    'value': None,
+                    'previous': None,
+                })
+
+    compare('/manifest', parse_manifest(main), parse_manifest(merged))
+    return sorted(changes, key=lambda c: (c['path'], c['attribute'] or ''))
+
+
+def check_target_sdk_version(xml, expected):
+    """Verify that the manifest declares the given targetSdkVersion.
+
+  Args:
+    xml: parsed XML manifest
+    expected: the targetSdkVersion computed by the build system
+    """
+    target = extract_target_sdk_version_xml(xml)
+    if target != expected:
+        raise ManifestMismatchError(
+            'merged manifest declares targetSdkVersion="%s", but the build '
+            'system computed "%s"' % (target, expected))
+
+
+def check_min_sdk_version(xml, expected):
+    """Verify that the manifest declares the given minSdkVersion.
+
+  Args:
+    xml: parsed XML manifest
+    expected: the minSdkVersion computed by the build system
+    """
+    manifest = parse_manifest(xml)
+    uses_sdk = get_children_with_tag(manifest, 'uses-sdk')
+    if len(uses_sdk) != 1:
+        raise ManifestMismatchError(
+            'merged manifest must declare exactly one <uses-sdk>, found %d' %
+            len(uses_sdk))
+
+    declared = uses_sdk[0].getAttributeNS(android_ns, 'minSdkVersion')
+    if declared != expected:
+        raise ManifestMismatchError(
+            'merged manifest declares minSdkVersion="%s", but the build '
+            'system computed "%s"' % (declared, expected))
+
+
+def check_expected_package(xml, expected):
+    """Verify that the manifest declares the given package.
+
+  Args:
+    xml:      parsed XML manifest
+    expected: the package recorded for the signing certificate lineage
+    """
+    package = parse_manifest(xml).getAttribute('package')
+    if package != expected:
+        raise ManifestMismatchError(
+            'merged manifest declares package="%s", but the signing lineage '
+            'was created for "%s"' % (package, expected))
+
+
+def check_instrumentation_target(xml, package):
+    """Verify that the <instrumentation> tags target the given package.
+
+  Args:
+    xml:     parsed XML manifest of the test
+    package: the package of the app listed in instrumentation_for
+    """
+    manifest = parse_manifest(xml)
+    for instrumentation in get_children_with_tag(manifest, 'instrumentation'):
+        target = instrumentation.getAttributeNS(android_ns, 'targetPackage')
+        if target != package:
+            raise ManifestMismatchError(
+                'android:targetPackage="%s" of <instrumentation> does not match '
+                'package "%s" of the app in instrumentation_for' % (target, package))
+
+
+def find_missing_instrumentation_attributes(xml, required):
+    """Find the <instrumentation> tags that don't declare the required attributes.
+
+  Args:
+    xml:      parsed XML manifest of the test
+    required: map from attribute names to their expected values, or to None
+              if any value is accepted
+
+  Returns:
+    a list of messages naming each missing or mismatched attribute
+    """
+    manifest = parse_manifest(xml)
+    instrumentations = get_children_with_tag(manifest, 'instrumentation')
+    if not instrumentations:
+        return ['the manifest has no <instrumentation> tag']
+
+    messages = []
+    for instrumentation in instrumentations:
+        name = instrumentation.getAttributeNS(android_ns, 'name')
+        for attr in sorted(required):
+            expected = required[attr]
+            if not instrumentation.hasAttributeNS(android_ns, attr):
+                messages.append('<instrumentation> %s does not declare android:%s' %
+                                (name, attr))
+                continue
+            value = instrumentation.getAttributeNS(android_ns, attr)
+            if expected is not None and value != expected:
+                messages.append('<instrumentation> %s declares android:%s="%s", '
+                                'expected "%s"' % (name, attr, value, expected))
+    return messages
+
+
+def find_deprecated_permissions(xml, deprecated):
+    """Find the <uses-permission> tags that request deprecated permissions.
+
+  Args:
+    xml:        parsed XML manifest
+    deprecated: map from deprecated permission names to their replacements
+
+  Returns:
+    a list of messages naming each deprecated permission and its replacement
+    """
+    manifest = parse_manifest(xml)
+    messages = []
+    for tag in ['uses-permission', 'uses-permission-sdk-23']:
+        for elem in get_children_with_tag(manifest, tag):
+            name = elem.getAttributeNS(android_ns, 'name')
+            if name in deprecated:
+                messages.append('<%s> requests deprecated permission "%s", use '
+                                '"%s" instead' % (tag, name, deprecated[name]))
+    return messages
+
+
+COMPONENT_TAGS = ['activity', 'activity-alias', 'service', 'receiver', 'provider']
+
+SIGNATURE_PROTECTION_LEVELS = ['signature', 'signatureOrSystem', 'internal']
+
+
+def find_duplicate_components(manifests):
+    """Find components that are declared more than once.
+
+  Declarations with tools: attributes are merge directives that modify a
+  component declared by another manifest, and are not duplicates.
+
+  Args:
+    manifests: list of (path, parsed XML manifest)
+
+  Returns:
+    a list of messages naming each duplicated component and the manifests
+    that declare it
+    """
+    declarations = {}
+    for path, xml in manifests:
+        manifest = parse_manifest(xml)
+        package = manifest.getAttribute('package')
+        for application in get_children_with_tag(manifest, 'application'):
+            for tag in COMPONENT_TAGS:
+                for component in get_children_with_tag(application, tag):
+                    name = component.getAttributeNS(android_ns, 'name')
+                    if not name or any(
+                            attr.namespaceURI == tools_ns
+                            for attr in component.attributes.values()):
+                        continue
+                    declarations.setdefault(resolve_class_name(package, name),
+                                            []).append('<%s> in %s' % (tag, path))
+    return ['%s is declared by %s' % (name, ', '.join(sources))
+            for name, sources in sorted(declarations.items())
+            if len(sources) > 1]
+
+
+def find_weakly_protected_components(xml):
+    """Find exported components guarded by weakly protected permissions.
+
+  Only permissions declared by the manifest itself are considered, as the
+  protection level of permissions declared by other packages is not known.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a list of messages naming each component and its permission
+    """
+    manifest = parse_manifest(xml)
+
+    protection_levels = {}
+    for permission in get_children_with_tag(manifest, 'permission'):
+        name = permission.getAttributeNS(android_ns, 'name')
+        level = permission.getAttributeNS(android_ns, 'protectionLevel')
+        # The base protection level may be combined with flags, e.g.
+        # "signature|privileged".
+        protection_levels[name] = (level or 'normal').split('|')[0]
+
+    messages = []
+    for application in get_children_with_tag(manifest, 'application'):
+        default_permission = application.getAttributeNS(android_ns, 'permission')
+        for tag in COMPONENT_TAGS:
+            for component in get_children_with_tag(application, tag):
+                if not is_exported(component):
+                    continue
+                permission = (component.getAttributeNS(android_ns, 'permission') or
+                              default_permission)
+                level = protection_levels.get(permission)
+                if level is not None and level not in SIGNATURE_PROTECTION_LEVELS:
+                    messages.append(
+                        'exported <%s> %s is guarded by permission %s with '
+                        'protectionLevel "%s"' %
+                        (tag, component.getAttributeNS(android_ns, 'name'),
+                         permission, level))
+    return messages
+
+
+def find_providers_without_authorities(xml):
+    """Find <provider> tags that don't declare android:authorities.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a list of the names of the providers
+    """
+    manifest = parse_manifest(xml)
+
+    names = []
+    for application in get_children_with_tag(manifest, 'application'):
+        for provider in get_children_with_tag(application, 'provider'):
+            if not provider.getAttributeNS(android_ns, 'authorities').strip():
+                names.append(provider.getAttributeNS(android_ns, 'name'))
+    return names
+
+
+FEATURE_FLAG_META_DATA = 'android.soong.FEATURE_FLAG'
+
+
+def find_components_with_disabled_feature_flags(xml, enabled_flags):
+    """Find components gated behind a feature flag that is not enabled.
+
+  A component is gated behind a feature flag by an android.soong.FEATURE_FLAG
+  <meta-data> tag whose android:value is the name of the flag.
+
+  Args:
+    xml:           parsed XML manifest
+    enabled_flags: the feature flags that are enabled for the product
+
+  Returns:
+    a list of "<component> (<flag>)" strings
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+
+    components = []
+    for application in get_children_with_tag(manifest, 'application'):
+        for tag in COMPONENT_TAGS:
+            for component in get_children_with_tag(application, tag):
+                for meta_data in get_children_with_tag(component, 'meta-data'):
+                    if (meta_data.getAttributeNS(android_ns, 'name') !=
+                            FEATURE_FLAG_META_DATA):
+                        continue
+                    flag = meta_data.getAttributeNS(android_ns, 'value')
+                    if flag not in enabled_flags:
+                        components.append('%s (%s)' % (resolve_class_name(
+                            package, component.getAttributeNS(android_ns, 'name')),
+                                                       flag))
+    return components
+
+
+FILE_PROVIDER_PATHS_META_DATA = 'android.support.FILE_PROVIDER_PATHS'
+
+
+def find_file_providers_without_paths(xml):
+    """Find FileProviders granting URI permissions that don't declare paths.
+
+  A provider is considered a FileProvider if its class name ends with
+  FileProvider, e.g. androidx.core.content.FileProvider or a subclass of it.
+  A FileProvider with android:grantUriPermissions="true" must declare its paths
+  in an android.support.FILE_PROVIDER_PATHS <meta-data> tag with an
+  android:resource.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a list of the fully qualified names of the providers
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+
+    names = []
+    for application in get_children_with_tag(manifest, 'application'):
+        for provider in get_children_with_tag(application, 'provider'):
+            name = resolve_class_name(
+                package, provider.getAttributeNS(android_ns, 'name'))
+            if not name.endswith('FileProvider'):
+                continue
+            if provider.getAttributeNS(android_ns,
+                                       'grantUriPermissions') != 'true':
+                continue
+            has_paths = any(
+                meta_data.getAttributeNS(android_ns, 'name') ==
+                FILE_PROVIDER_PATHS_META_DATA and
+                meta_data.getAttributeNS(android_ns, 'resource')
+                for meta_data in get_children_with_tag(provider, 'meta-data'))
+            if not has_paths:
+                names.append(name)
+    return names
+
+
+def find_unguarded_exported_services(xml, open_services):
+    """Find exported <service> tags that aren't guarded by a permission.
+
+  A service is guarded by its own android:permission, or by the one of the
+  <application> if it doesn't declare one.
+
+  Args:
+    xml: parsed XML manifest
+    open_services: list of class names of services that are allowed not to be
+      guarded, resolved against the package of the manifest
+
+  Returns:
+    a list of the names of the services
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+    allowed = {resolve_class_name(package, name) for name in open_services}
+
+    names = []
+    for application in get_children_with_tag(manifest, 'application'):
+        default_permission = application.getAttributeNS(android_ns, 'permission')
+        for service in get_children_with_tag(application, 'service'):
+            if not is_exported(service):
+                continue
+            if service.getAttributeNS(android_ns, 'permission') or default_permission:
+                continue
+            name = service.getAttributeNS(android_ns, 'name')
+            if resolve_class_name(package, name) not in allowed:
+                names.append(name)
+    return names
+
+
+def find_unguarded_exported_providers(xml, open_providers):
+    """Find exported <provider> tags that aren't guarded by permissions.
+
+  A provider is guarded by its own android:permission, by the one of the
+  <application> if it doesn't declare one, or by declaring both
+  android:readPermission and android:writePermission.
+
+  Args:
+    xml: parsed XML manifest
+    open_providers: list of class names of providers that are allowed not to be
+      guarded, resolved against the package of the manifest
+
+  Returns:
+    a list of the names of the providers
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+    allowed = {resolve_class_name(package, name) for name in open_providers}
+
+    names = []
+    for application in get_children_with_tag(manifest, 'application'):
+        default_permission = application.getAttributeNS(android_ns, 'permission')
+        for provider in get_children_with_tag(application, 'provider'):
+            if not is_exported(provider):
+                continue
+            if provider.getAttributeNS(android_ns, 'permission') or default_permission:
+                continue
+            if (provider.getAttributeNS(android_ns, 'readPermission') and
+                    provider.getAttributeNS(android_ns, 'writePermission')):
+                continue
+            name = provider.getAttributeNS(android_ns, 'name')
+            if resolve_class_name(package, name) not in allowed:
+                names.append(name)
+    return names
+
+
+# The parents that the common elements of a manifest must be nested in.  Elements
+# that aren't listed aren't checked.
+MANIFEST_ELEMENT_PARENTS = {
+    'application': ['manifest'],
+    'attribution': ['manifest'],
+    'compatible-screens': ['manifest'],
+    'instrumentation': ['manifest'],
+    'permission': ['manifest'],
+    'permission-group': ['manifest'],
+    'permission-tree': ['manifest'],
+    'queries': ['manifest'],
+    'supports-screens': ['manifest'],
+    'uses-configuration': ['manifest'],
+    'uses-feature': ['manifest'],
+    'uses-permission': ['manifest'],
+    'uses-permission-sdk-23': ['manifest'],
+    'uses-sdk': ['manifest'],
+    'activity': ['application'],
+    'activity-alias': ['application'],
+    'profileable': ['application'],
+    'provider': ['application', 'queries'],
+    'receiver': ['application'],
+    'service': ['application'],
+    'uses-library': ['application'],
+    'uses-native-library': ['application'],
+    'uses-sdk-library': ['application'],
+    'grant-uri-permission': ['provider'],
+    'path-permission': ['provider'],
+    'intent-filter': ['activity', 'activity-alias', 'service', 'receiver',
+                      'provider'],
+    'action': ['intent-filter', 'intent'],
+    'category': ['intent-filter', 'intent'],
+    'data': ['intent-filter', 'intent'],
+    'meta-data': ['application', 'activity', 'activity-alias', 'service',
+                  'receiver', 'provider'],
+    'property': ['application', 'activity', 'activity-alias', 'service',
+                 'receiver', 'provider'],
+}
+
+# The elements that may be declared at most once in their parent.
+MANIFEST_SINGLE_ELEMENTS = ['application', 'uses-sdk', 'queries']
+
+
+def check_uses_permission_budget(xml, budget):
+    """Verify that the manifest requests at most the given number of permissions.
+
+  Args:
+    xml: parsed XML manifest
+    budget: the maximum number of <uses-permission> and <uses-permission-sdk-23>
+      permissions
+    """
+    manifest = parse_manifest(xml)
+    permissions = set()
+    for tag in ['uses-permission', 'uses-permission-sdk-23']:
+        for elem in get_children_with_tag(manifest, tag):
+            permissions.add(elem.getAttributeNS(android_ns, 'name'))
+    if len(permissions) > budget:
+        raise ManifestMismatchError(
+            'manifest requests %d permissions, more than the budget of %d:\n\t%s' % (
+                len(permissions), budget, '\n\t'.join(sorted(permissions))))
+
+
+def find_structure_errors(xml):
+    """Find elements of the manifest that aren't nested in their expected parents.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a list of messages naming each misplaced element and its parent
+    """
+    root = xml.documentElement
+    if root.tagName != 'manifest':
+        return ['root element is <%s>, expected <manifest>' % root.tagName]
+
+    messages = []
+
+    def visit(elem, path):
+        counts = {}
+        for child in elem.childNodes:
+            if child.nodeType != minidom.Node.ELEMENT_NODE:
+                continue
+            child_path = path + '/' + child.tagName
+            parents = MANIFEST_ELEMENT_PARENTS.get(child.tagName)
+            if parents is not None and elem.tagName not in parents:
+                messages.append('<%s> %s must be nested in %s' % (
+                    child.tagName, child_path,
+                    ' or '.join('<%s>' % p for p in parents)))
+            counts[child.tagName] = counts.get(child.tagName, 0) + 1
+            visit(child, child_path)
+        for tag in MANIFEST_SINGLE_ELEMENTS:
+            if counts.get(tag, 0) > 1:
+                messages.append('<%s> is declared %d times in %s, at most one is '
+                                'allowed' % (tag, counts[tag], path))
+
+    visit(root, '/manifest')
+    return messages
+
+
+ICON_ATTRIBUTES = ['icon', 'roundIcon']
+
+
+def parse_r_txt(lines):
+    """Returns the (type, name) pairs of the resources listed in an R.txt file."""
+    resources = set()
+    for line in lines:
+        fields = line.split()
+        if len(fields) >= 3 and fields[0] == 'int':
+            resources.add((fields[1], fields[2]))
+    return resources
+
+
+def find_overlayable_errors(package, overlayable_package, resources):
+    """Find mismatches between the overlayable declarations and the manifest.
+
+  Args:
+    package:             the package of the app
+    overlayable_package: the package that overlays of the app target
+    resources:           list of (path, parsed XML) values resource files
+
+  Returns:
+    a list of error messages
+    """
+    messages = []
+    if package != overlayable_package:
+        messages.append('overlays target package "%s", but the manifest declares '
+                        'package "%s"' % (overlayable_package, package))
+    if not any(res.getElementsByTagName('overlayable') for _, res in resources):
+        messages.append('no <overlayable> is declared by the resources')
+    return messages
+
+
+def find_dangling_icon_resources(xml, resources):
+    """Find icon attributes that refer to resources that don't exist.
+
+  Only references to resources of the app itself are checked; references to
+  resources of other packages, e.g. @android:mipmap/sym_def_app_icon, are
+  ignored.
+
+  Args:
+    xml: parsed XML manifest
+    resources: set of (type, name) pairs of the resources of the app
+
+  Returns:
+    a list of messages naming each element, attribute and reference
+    """
+    manifest = parse_manifest(xml)
+    package = manifest.getAttribute('package')
+
+    messages = []
+    for application in get_children_with_tag(manifest, 'application'):
+        elems = [application]
+        for tag in COMPONENT_TAGS:
+            elems.extend(get_children_with_tag(application, tag))
+        for elem in elems:
+            for attr in ICON_ATTRIBUTES:
+                value = elem.getAttributeNS(android_ns, attr)
+                match = re.match(r'^@(?:([\w.]+):)?(\w+)/([\w.]+)$', value)
+                if not match:
+                    continue
+                ref_package, res_type, name = match.groups()
+                if ref_package and ref_package != package:
+                    continue
+                if (res_type, name.replace('.', '_')) not in resources:
+                    what = '<%s>' % elem.tagName
+                    if elem is not application:
+                        what += ' ' + elem.getAttributeNS(android_ns, 'name')
+                    messages.append('%s android:%s="%s" refers to a resource that '
+                                    'does not exist' % (what, attr, value))
+    return messages
+
+
+def find_colliding_intent_filter_priorities(xml):
+    """Find intent filters that compete with the same non-default priority.
+
+  Two intent filters collide if they belong to different components declared
+  with the same tag, have the same android:priority other than 0, share an
+  action, and either both have no categories or share a category.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a list of messages naming each pair of colliding components
+    """
+    manifest = parse_manifest(xml)
+
+    def values(intent_filter, tag):
+        return set(elem.getAttributeNS(android_ns, 'name')
+                   for elem in get_children_with_tag(intent_filter, tag))
+
+    messages = []
+    for application in get_children_with_tag(manifest, 'application'):
+        for tag in COMPONENT_TAGS:
+            filters = []
+            for component in get_children_with_tag(application, tag):
+                name = component.getAttributeNS(android_ns, 'name')
+                for intent_filter in get_children_with_tag(component, 'intent-filter'):
+                    priority = intent_filter.getAttributeNS(android_ns, 'priority')
+                    if priority and priority != '0':
+                        filters.append((name, priority,
+                                        values(intent_filter, 'action'),
+                                        values(intent_filter, 'category')))
+
+            for i, (name, priority, actions, categories) in enumerate(filters):
+                for other, other_priority, other_actions, other_categories in filters[i + 1:]:
+                    if other == name or other_priority != priority:
+                        continue
+                    common = actions & other_actions
+                    if not common:
+                        continue
+                    if (categories or other_categories) and not categories & other_categories:
+                        continue
+                    messages.append(
+                        '<%s> %s and %s declare intent filters with '
+                        'android:priority="%s" for %s' %
+                        (tag, name, other, priority, ', '.join(sorted(common))))
+    return messages
+
+
+def find_sandboxed_shared_user_id(xml):
+    """Find an android:sharedUserId declared with a targetSandboxVersion of 2 or higher.
+
+  Apps with a targetSandboxVersion of 2 or higher can't share a user id, the
+  package manager refuses to install them.
+
+  Args:
+    xml: parsed XML manifest
+
+  Returns:
+    a tuple of the shared user id and the targetSandboxVersion, or None if the
+    manifest does not declare both
+    """
+    manifest = parse_manifest(xml)
+    shared_user_id = manifest.getAttributeNS(android_ns, 'sharedUserId')
+    sandbox_version = manifest.getAttributeNS(android_ns, 'targetSandboxVersion')
+    if not shared_user_id or not sandbox_version.isdigit():
+        return None
+    if int(sandbox_version) < 2:
+        return None
+    return shared_user_id, sandbox_version
+
+
+def find_shared_user_id(xml, min_target_sdk_version):
+    """Find an android:sharedUserId declared by a manifest that targets a recent SDK.
+
+  Args:
+    xml:                    parsed XML manifest
+    min_target_sdk_version: the lowest targetSdkVersion for which the shared
+                            user id is reported
+
+  Returns:
+    a tuple of the shared user id and the targetSdkVersion, or None if the
+    manifest does not declare a shared user id or targets an older SDK
+    """
+    manifest = parse_manifest(xml)
+    shared_user_id = manifest.getAttributeNS(android_ns, 'sharedUserId')
+    if not shared_user_id:
+        return None
+
+    target_sdk_version = extract_target_sdk_version_xml(xml)
+    # A codename is a prerelease of the next SDK, which is newer than any
+    # threshold given as a number.
+    if (target_sdk_version.isdigit() and
+            int(target_sdk_version) < min_target_sdk_version):
+        return None
+    return shared_user_id, target_sdk_version
+
+
 def load_dexpreopt_configs(configs):
     """Load dexpreopt.config files and map module names to library names."""
     module_to_libname = {}
@@ -391,6 +1613,316 @@ def main():
                 # result in dexpreopt not adding any compatibility libraries.
                 print(10000)
 
+        if args.lib_manifests:
+            if is_apk:
+                raise RuntimeError('cannot check library packages of APK manifest')
+
+            package = args.app_package
+            if not package:
+                package = parse_manifest(manifest).getAttribute('package')
+            libs = [(path, minidom.parse(path)) for path in args.lib_manifests]
+            if args.check_lib_packages:
+                check_lib_packages(package, libs, args.allowed_lib_packages)
+            if args.check_duplicate_components:
+                messages = find_duplicate_components(
+                    [(args.input, manifest)] + libs)
+                if messages:
+                    raise ManifestMismatchError(
+                        'components are declared more than once:\n\t%s' %
+                        '\n\t'.join(messages))
+            if args.lib_packages_output:
+                with open(args.lib_packages_output, 'w') as f:
+                    for lib_package in extract_lib_packages(package, libs):
+                        f.write('--extra-packages %s\n' % lib_package)
+
+        if args.lib_permissions_output:
+            if is_apk:
+                raise RuntimeError('cannot section permissions of APK manifest')
+
+            libs = [(path, minidom.parse(path)) for path in args.lib_manifests]
+            sectioned = minidom.parseString(manifest.toxml())
+            section_lib_permissions(
+                minidom.parse(args.unmerged_manifest), sectioned, libs)
+            with open(args.lib_permissions_output, 'w') as f:
+                write_xml(f, sectioned)
+
+        if args.merge_changes_output:
+            if is_apk:
+                raise RuntimeError('cannot list merge changes of APK manifest')
+
+            changes = find_merge_changes(
+                minidom.parse(args.unmerged_manifest), manifest)
+            with open(args.merge_changes_output, 'w') as f:
+                json.dump(changes, f, indent=2, sort_keys=True)
+                f.write('\n')
+
+        if args.expected_target_sdk_version:
+            if is_apk:
+                raise RuntimeError('cannot check targetSdkVersion of APK manifest')
+
+            check_target_sdk_version(manifest, args.expected_target_sdk_version)
+
+        if args.expected_min_sdk_version:
+            if is_apk:
+                raise RuntimeError('cannot check minSdkVersion of APK manifest')
+
+            check_min_sdk_version(manifest, args.expected_min_sdk_version)
+
+        if args.expected_package:
+            if is_apk:
+                raise RuntimeError('cannot check package of APK manifest')
+
+            check_expected_package(manifest, args.expected_package)
+
+        if (args.instrumentation_target_package or
+                args.instrumentation_target_manifest):
+            if is_apk:
+                raise RuntimeError('cannot check instrumentation target of APK')
+
+            package = args.instrumentation_target_package
+            if not package:
+                target = minidom.parse(args.instrumentation_target_manifest)
+                package = parse_manifest(target).getAttribute('package')
+            check_instrumentation_target(manifest, package)
+
+        if args.required_instrumentation_attributes:
+            if is_apk:
+                raise RuntimeError('cannot check instrumentation attributes of APK')
+
+            required = {}
+            for entry in args.required_instrumentation_attributes:
+                attr, sep, value = entry.partition('=')
+                required[attr] = value if sep else None
+            messages = find_missing_instrumentation_attributes(manifest, required)
+            if messages:
+                raise ManifestMismatchError('%s:\n\t%s' % (args.input, '\n\t'.join(messages)))
+
+        if args.deprecated_permissions:
+            if is_apk:
+                raise RuntimeError('cannot check permissions of APK manifest')
+
+            deprecated = dict(
+                entry.split('=', 1) for entry in args.deprecated_permissions)
+            messages = find_deprecated_permissions(manifest, deprecated)
+            if messages and args.deprecated_permissions_warn_only:
+                for message in messages:
+                    print('%swarning:%s %s: %s' % (C_BLUE, C_OFF, args.input, message),
+                          file=sys.stderr)
+            elif messages:
+                raise ManifestMismatchError('%s:\n\t%s' % (args.input, '\n\t'.join(messages)))
+
+        if args.enforce_signature_protected_components:
+            if is_apk:
+                raise RuntimeError('cannot check components of APK manifest')
+
+            messages = find_weakly_protected_components(manifest)
+            if messages:
+                raise ManifestMismatchError(
+                    '%s: exported components must be guarded by signature '
+                    'permissions:\n\t%s' % (args.input, '\n\t'.join(messages)))
+
+        if args.check_launcher_activities_exported:
+            if is_apk:
+                raise RuntimeError('cannot check activities of APK manifest')
+
+            activities = find_launcher_activities_without_exported(manifest)
+            if activities:
+                raise ManifestMismatchError(
+                    '%s: launcher activities must declare android:exported:\n\t%s' % (
+                        args.input, '\n\t'.join(
+                            '<%s> %s' % (a.tagName, a.getAttributeNS(android_ns, 'name'))
+                            for a in activities)))
+
+        if args.check_broadcast_receivers_exported:
+            if is_apk:
+                raise RuntimeError('cannot check receivers of APK manifest')
+
+            receivers = find_receivers_without_exported(
+                manifest, args.protected_broadcasts)
+            if receivers:
+                raise ManifestMismatchError(
+                    '%s: receivers for broadcasts that are not protected must '
+                    'declare android:exported:\n\t%s' % (
+                        args.input, '\n\t'.join(
+                            r.getAttributeNS(android_ns, 'name') for r in receivers)))
+
+        if args.check_component_feature_flags:
+            if is_apk:
+                raise RuntimeError('cannot check components of APK manifest')
+
+            components = find_components_with_disabled_feature_flags(
+                manifest, args.enabled_feature_flags)
+            if components:
+                raise ManifestMismatchError(
+                    '%s: components gated behind a feature flag that is not '
+                    'enabled for the product:\n\t%s' % (
+                        args.input, '\n\t'.join(components)))
+
+        if args.check_provider_authorities:
+            if is_apk:
+                raise RuntimeError('cannot check providers of APK manifest')
+
+            providers = find_providers_without_authorities(manifest)
+            if providers:
+                raise ManifestMismatchError(
+                    '%s: providers must declare android:authorities:\n\t%s' % (
+                        args.input, '\n\t'.join(providers)))
+
+        if args.check_file_provider_paths:
+            if is_apk:
+                raise RuntimeError('cannot check providers of APK manifest')
+
+            providers = find_file_providers_without_paths(manifest)
+            if providers:
+                raise ManifestMismatchError(
+                    '%s: FileProviders granting URI permissions must declare '
+                    'their paths in a %s <meta-data> tag:\n\t%s' % (
+                        args.input, FILE_PROVIDER_PATHS_META_DATA,
+                        '\n\t'.join(providers)))
+
+        if args.check_exported_service_permissions:
+            if is_apk:
+                raise RuntimeError('cannot check services of APK manifest')
+
+            services = find_unguarded_exported_services(
+                manifest, args.open_exported_services)
+            if services:
+                raise ManifestMismatchError(
+                    '%s: exported services must declare android:permission or be '
+                    'listed in open_exported_services:\n\t%s' % (
+                        args.input, '\n\t'.join(services)))
+
+        if args.check_exported_provider_permissions:
+            if is_apk:
+                raise RuntimeError('cannot check providers of APK manifest')
+
+            providers = find_unguarded_exported_providers(
+                manifest, args.open_exported_providers)
+            if providers:
+                raise ManifestMismatchError(
+                    '%s: exported providers must declare android:permission or '
+                    'both android:readPermission and android:writePermission, or '
+                    'be listed in open_exported_providers:\n\t%s' % (
+                        args.input, '\n\t'.join(providers)))
+
+        if args.check_intent_filter_priorities:
+            if is_apk:
+                raise RuntimeError('cannot check intent filters of APK manifest')
+
+            for message in find_colliding_intent_filter_priorities(manifest):
+                print('%swarning:%s %s: %s' % (C_BLUE, C_OFF, args.input, message),
+                      file=sys.stderr)
+
+        if args.check_structure:
+            if is_apk:
+                raise RuntimeError('cannot check structure of APK manifest')
+
+            messages = find_structure_errors(manifest)
+            if messages:
+                raise ManifestMismatchError(
+                    '%s: invalid manifest structure:\n\t%s' % (
+                        args.input, '\n\t'.join(messages)))
+
+        if args.check_target_sandbox_version:
+            if is_apk:
+                raise RuntimeError('cannot check sandbox version of APK manifest')
+
+            found = find_sandboxed_shared_user_id(manifest)
+            if found:
+                raise ManifestMismatchError(
+                    '%s: android:sharedUserId="%s" cannot be used with '
+                    'android:targetSandboxVersion="%s"' % (
+                        args.input, found[0], found[1]))
+
+        if args.uses_permission_budget is not None:
+            if is_apk:
+                raise RuntimeError('cannot check permissions of APK manifest')
+
+            check_uses_permission_budget(manifest, args.uses_permission_budget)
+
+        if args.check_icon_resources:
+            if is_apk:
+                raise RuntimeError('cannot check icon resources of APK manifest')
+
+            with open(args.r_txt) as f:
+                resources = parse_r_txt(f)
+            messages = find_dangling_icon_resources(manifest, resources)
+            if messages:
+                raise ManifestMismatchError(
+                    '%s: dangling icon resource references:\n\t%s' % (
+                        args.input, '\n\t'.join(messages)))
+
+        if args.overlayable_package:
+            if is_apk:
+                raise RuntimeError('cannot check overlayable package of APK manifest')
+
+            package = args.app_package
+            if not package:
+                package = parse_manifest(manifest).getAttribute('package')
+            resources = [(path, minidom.parse(path)) for path in args.resource_files]
+            messages = find_overlayable_errors(
+                package, args.overlayable_package, resources)
+            if messages:
+                raise ManifestMismatchError(
+                    '%s: invalid overlayable declaration:\n\t%s' % (
+                        args.input, '\n\t'.join(messages)))
+
+        if args.golden_manifest:
+            if is_apk:
+                raise RuntimeError('cannot compare APK manifest with golden')
+
+            diff = diff_canonical(manifest, minidom.parse(args.golden_manifest))
+            if diff:
+                raise ManifestMismatchError(
+                    '%s does not match golden manifest %s:\n%s' % (
+                        args.input, args.golden_manifest, '\n'.join(diff)))
+
+        if args.shared_user_id_warn_target_sdk_version is not None:
+            if is_apk:
+                raise RuntimeError('cannot check shared user id of APK manifest')
+
+            found = find_shared_user_id(
+                manifest, args.shared_user_id_warn_target_sdk_version)
+            if found:
+                print('%swarning:%s %s: module "%s" declares '
+                      'android:sharedUserId="%s" and targets SDK %s, shared '
+                      'user ids are deprecated' % (
+                          C_BLUE, C_OFF, args.input, args.module_name,
+                          found[0], found[1]), file=sys.stderr)
+
+        if args.application_class_output:
+            if is_apk:
+                raise RuntimeError('cannot extract application class of APK manifest')
+
+            with open(args.application_class_output, 'w') as f:
+                application_class = extract_application_class(manifest)
+                if application_class:
+                    f.write('%s\n' % application_class)
+
+        if args.component_inventory_output:
+            if is_apk:
+                raise RuntimeError('cannot list components of APK manifest')
+
+            with open(args.component_inventory_output, 'w') as f:
+                json.dump(component_inventory(manifest), f, indent=2, sort_keys=True)
+                f.write('\n')
+
+        if args.compat_report_output:
+            if is_apk:
+                raise RuntimeError('cannot compare APK manifest with baseline')
+
+            report = compat_report(minidom.parse(args.baseline_manifest), manifest)
+            with open(args.compat_report_output, 'w') as f:
+                json.dump(report, f, indent=2, sort_keys=True)
+                f.write('\n')
+
+        if args.fingerprint:
+            if is_apk:
+                raise RuntimeError('cannot fingerprint APK manifest')
+
+            with open(args.fingerprint, 'w') as f:
+                f.write('%s\n' % fingerprint(manifest))
+
         if args.output:
             # XML output is supposed to be written only when this script is
             # invoked with XML input manifest, not with an APK.
@@ -267,5 +267,1051 @@ class ExtractTargetSdkVersionTest(unittest.TestCase):
         self.run_test(xml, apk, '29')
 
 
+class CompatReportTest(unittest.TestCase):
+
+    def test_report(self):
+        baseline = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-sdk android:minSdkVersion="29" '
+            'android:targetSdkVersion="33"/>\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '    <uses-permission android:name="android.permission.CAMERA"/>\n'
+            '    <application>\n'
+            '        <activity android:name=".Main"/>\n'
+            '        <service android:name=".Old"/>\n'
+            '    </application>\n'
+            '</manifest>\n')
+        # Formatting and attribute order don't matter.
+        xml = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo"><uses-sdk android:targetSdkVersion="34" '
+            'android:minSdkVersion="29"/><uses-permission '
+            'android:name="android.permission.INTERNET"/><application><activity '
+            'android:name="com.android.foo.Main"/><receiver android:name=".New"/>'
+            '</application></manifest>\n')
+        self.assertEqual(manifest_check.compat_report(baseline, xml), {
+            'permissions': {
+                'added': [],
+                'removed': ['android.permission.CAMERA'],
+            },
+            'components': {
+                'added': [{'tag': 'receiver', 'name': 'com.android.foo.New'}],
+                'removed': [{'tag': 'service', 'name': 'com.android.foo.Old'}],
+            },
+            'sdk': {
+                'targetSdkVersion': {'baseline': '33', 'current': '34'},
+            },
+        })
+
+    def test_identical(self):
+        xml = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '</manifest>\n')
+        self.assertEqual(manifest_check.compat_report(xml, xml), {
+            'permissions': {'added': [], 'removed': []},
+            'components': {'added': [], 'removed': []},
+            'sdk': {},
+        })
+
+
+class FingerprintTest(unittest.TestCase):
+
+    xml = (
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n    '
+        '<uses-sdk android:minSdkVersion="28" android:targetSdkVersion="29"/>\n'
+        '</manifest>\n')
+
+    def fingerprint(self, xml):
+        return manifest_check.fingerprint(minidom.parseString(xml))
+
+    def test_identical(self):
+        self.assertEqual(self.fingerprint(self.xml), self.fingerprint(self.xml))
+
+    def test_formatting(self):
+        reformatted = (
+            '<manifest package="com.android.foo" '
+            'xmlns:android="http://schemas.android.com/apk/res/android">'
+            '<uses-sdk android:targetSdkVersion="29" '
+            'android:minSdkVersion="28" /></manifest>')
+        self.assertEqual(self.fingerprint(self.xml), self.fingerprint(reformatted))
+
+    def test_different(self):
+        changed = self.xml.replace('"29"', '"30"')
+        self.assertNotEqual(self.fingerprint(self.xml), self.fingerprint(changed))
+
+
+class FindLauncherActivitiesWithoutExportedTest(unittest.TestCase):
+
+    def xml(self, exported):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application>\n'
+            '        <activity-alias android:name=".Launcher"%s>\n'
+            '            <intent-filter>\n'
+            '                <category '
+            'android:name="android.intent.category.LAUNCHER"/>\n'
+            '            </intent-filter>\n'
+            '        </activity-alias>\n'
+            '    </application>\n'
+            '</manifest>\n' % exported)
+
+    def test_missing(self):
+        activities = manifest_check.find_launcher_activities_without_exported(
+            self.xml(''))
+        self.assertEqual(
+            [a.getAttributeNS(manifest_check.android_ns, 'name') for a in activities],
+            ['.Launcher'])
+
+    def test_explicit(self):
+        activities = manifest_check.find_launcher_activities_without_exported(
+            self.xml(' android:exported="true"'))
+        self.assertEqual(activities, [])
+
+
+class FindReceiversWithoutExportedTest(unittest.TestCase):
+
+    def xml(self, exported):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application>\n'
+            '        <receiver android:name=".Boot">\n'
+            '            <intent-filter>\n'
+            '                <action '
+            'android:name="android.intent.action.BOOT_COMPLETED"/>\n'
+            '            </intent-filter>\n'
+            '        </receiver>\n'
+            '        <receiver android:name=".Refresh"%s>\n'
+            '            <intent-filter>\n'
+            '                <action android:name="com.android.foo.REFRESH"/>\n'
+            '            </intent-filter>\n'
+            '        </receiver>\n'
+            '        <receiver android:name=".Explicit"/>\n'
+            '    </application>\n'
+            '</manifest>\n' % exported)
+
+    def test_missing(self):
+        receivers = manifest_check.find_receivers_without_exported(
+            self.xml(''), ['android.intent.action.BOOT_COMPLETED'])
+        self.assertEqual(
+            [r.getAttributeNS(manifest_check.android_ns, 'name') for r in receivers],
+            ['.Refresh'])
+
+    def test_explicit(self):
+        receivers = manifest_check.find_receivers_without_exported(
+            self.xml(' android:exported="false"'),
+            ['android.intent.action.BOOT_COMPLETED'])
+        self.assertEqual(receivers, [])
+
+    def test_not_protected(self):
+        receivers = manifest_check.find_receivers_without_exported(
+            self.xml(' android:exported="false"'), [])
+        self.assertEqual(
+            [r.getAttributeNS(manifest_check.android_ns, 'name') for r in receivers],
+            ['.Boot'])
+
+
+class ExtractApplicationClassTest(unittest.TestCase):
+
+    def xml(self, application):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    %s\n'
+            '</manifest>\n' % application)
+
+    def test_relative(self):
+        self.assertEqual(
+            manifest_check.extract_application_class(
+                self.xml('<application android:name=".FooApplication"/>')),
+            'com.android.foo.FooApplication')
+
+    def test_absolute(self):
+        self.assertEqual(
+            manifest_check.extract_application_class(
+                self.xml('<application android:name="com.android.bar.App"/>')),
+            'com.android.bar.App')
+
+    def test_none(self):
+        self.assertIsNone(
+            manifest_check.extract_application_class(self.xml('<application/>')))
+
+
+class ComponentInventoryTest(unittest.TestCase):
+
+    def test_components(self):
+        xml = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application>\n'
+            '        <service android:name=".SyncService" android:exported="false"/>\n'
+            '        <activity android:name=".MainActivity">\n'
+            '            <intent-filter>\n'
+            '                <action android:name="android.intent.action.MAIN"/>\n'
+            '            </intent-filter>\n'
+            '        </activity>\n'
+            '        <receiver android:name="com.android.lib.BootReceiver" '
+            'android:exported="true"/>\n'
+            '    </application>\n'
+            '</manifest>\n')
+        self.assertEqual(
+            manifest_check.component_inventory(xml), [
+                {'tag': 'activity', 'name': 'com.android.foo.MainActivity',
+                 'exported': True},
+                {'tag': 'receiver', 'name': 'com.android.lib.BootReceiver',
+                 'exported': True},
+                {'tag': 'service', 'name': 'com.android.foo.SyncService',
+                 'exported': False},
+            ])
+
+
+class DiffCanonicalTest(unittest.TestCase):
+
+    def xml(self, application):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    %s\n'
+            '</manifest>\n' % application)
+
+    def test_match(self):
+        diff = manifest_check.diff_canonical(
+            self.xml('<application android:label="foo" android:icon="@mipmap/foo"/>'),
+            self.xml('<application android:icon="@mipmap/foo"\n'
+                     '        android:label="foo"></application><!-- comment -->'))
+        self.assertEqual(diff, [])
+
+    def test_mismatch(self):
+        diff = manifest_check.diff_canonical(
+            self.xml('<application android:label="foo"/>'),
+            self.xml('<application android:label="bar"/>'))
+        self.assertIn('-    <application xmlns:android='
+                      '"http://schemas.android.com/apk/res/android" '
+                      'android:label="bar"/>', diff)
+        self.assertIn('+    <application xmlns:android='
+                      '"http://schemas.android.com/apk/res/android" '
+                      'android:label="foo"/>', diff)
+
+
+class CheckLibPackagesTest(unittest.TestCase):
+
+    def lib(self, package):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="%s"/>\n' % package)
+
+    def test_match(self):
+        manifest_check.check_lib_packages(
+            'com.android.foo', [('lib.xml', self.lib('com.android.foo'))], [])
+
+    def test_mismatch(self):
+        with self.assertRaisesRegex(manifest_check.ManifestMismatchError,
+                                    'lib.xml declares package "com.android.bar"'):
+            manifest_check.check_lib_packages(
+                'com.android.foo', [('lib.xml', self.lib('com.android.bar'))], [])
+
+    def test_allowed(self):
+        manifest_check.check_lib_packages(
+            'com.android.foo', [('lib.xml', self.lib('com.android.bar'))],
+            ['com.android.bar'])
+
+
+class FindDuplicateComponentsTest(unittest.TestCase):
+
+    def xml(self, package, components):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'xmlns:tools="http://schemas.android.com/tools" '
+            'package="%s">\n'
+            '    <application>\n'
+            '        %s\n'
+            '    </application>\n'
+            '</manifest>\n' % (package, components))
+
+    def test_duplicate_provider(self):
+        messages = manifest_check.find_duplicate_components([
+            ('app.xml', self.xml('com.android.app', '<activity android:name=".Main"/>')),
+            ('liba.xml', self.xml('com.android.lib',
+                                  '<provider android:name=".Provider"/>')),
+            ('libb.xml', self.xml('com.android.libb',
+                                  '<provider android:name="com.android.lib.Provider"/>')),
+        ])
+        self.assertEqual(messages, [
+            'com.android.lib.Provider is declared by <provider> in liba.xml, '
+            '<provider> in libb.xml'
+        ])
+
+    def test_merge_directive(self):
+        messages = manifest_check.find_duplicate_components([
+            ('app.xml', self.xml(
+                'com.android.app',
+                '<provider android:name="com.android.lib.Provider" '
+                'android:exported="false" tools:replace="android:exported"/>')),
+            ('liba.xml', self.xml('com.android.lib',
+                                  '<provider android:name=".Provider"/>')),
+        ])
+        self.assertEqual(messages, [])
+
+
+class ExtractLibPackagesTest(unittest.TestCase):
+
+    def lib(self, package):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="%s"/>\n' % package)
+
+    def test_two_libs(self):
+        packages = manifest_check.extract_lib_packages('com.android.foo', [
+            ('b.xml', self.lib('com.android.libb')),
+            ('a.xml', self.lib('com.android.liba')),
+            ('c.xml', self.lib('com.android.foo')),
+            ('d.xml', self.lib('com.android.liba')),
+        ])
+        self.assertEqual(packages, ['com.android.liba', 'com.android.libb'])
+
+
+class CheckTargetSdkVersionTest(unittest.TestCase):
+
+    def xml(self, target):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-sdk android:minSdkVersion="29" '
+            'android:targetSdkVersion="%s"/>\n'
+            '</manifest>\n' % target)
+
+    def test_match(self):
+        manifest_check.check_target_sdk_version(self.xml('34'), '34')
+
+    def test_lowered(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'merged manifest declares targetSdkVersion="30", but the build '
+                'system computed "34"'):
+            manifest_check.check_target_sdk_version(self.xml('30'), '34')
+
+
+class CheckMinSdkVersionTest(unittest.TestCase):
+
+    def xml(self, uses_sdk):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    %s\n'
+            '</manifest>\n' % uses_sdk)
+
+    def test_match(self):
+        manifest_check.check_min_sdk_version(
+            self.xml('<uses-sdk android:minSdkVersion="29"/>'), '29')
+
+    def test_stale(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'merged manifest declares minSdkVersion="31", but the build '
+                'system computed "29"'):
+            manifest_check.check_min_sdk_version(
+                self.xml('<uses-sdk android:minSdkVersion="31"/>'), '29')
+
+    def test_missing(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'must declare exactly one <uses-sdk>, found 0'):
+            manifest_check.check_min_sdk_version(self.xml(''), '29')
+
+
+class CheckExpectedPackageTest(unittest.TestCase):
+
+    def xml(self, package):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="%s">\n'
+            '</manifest>\n' % package)
+
+    def test_match(self):
+        manifest_check.check_expected_package(
+            self.xml('com.android.foo'), 'com.android.foo')
+
+    def test_mismatch(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'merged manifest declares package="com.android.bar", but the '
+                'signing lineage was created for "com.android.foo"'):
+            manifest_check.check_expected_package(
+                self.xml('com.android.bar'), 'com.android.foo')
+
+
+class CheckInstrumentationTargetTest(unittest.TestCase):
+
+    def xml(self, target):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo.test">\n'
+            '    <instrumentation android:name="androidx.test.runner.AndroidJUnitRunner" '
+            'android:targetPackage="%s"/>\n'
+            '</manifest>\n' % target)
+
+    def test_match(self):
+        manifest_check.check_instrumentation_target(
+            self.xml('com.android.foo'), 'com.android.foo')
+
+    def test_mismatch(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'android:targetPackage="com.android.bar" of <instrumentation> '
+                'does not match package "com.android.foo"'):
+            manifest_check.check_instrumentation_target(
+                self.xml('com.android.bar'), 'com.android.foo')
+
+
+class FindMissingInstrumentationAttributesTest(unittest.TestCase):
+
+    def xml(self, attrs):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo.test">\n'
+            '    <instrumentation android:name="androidx.test.runner.AndroidJUnitRunner" '
+            'android:targetPackage="com.android.foo" %s/>\n'
+            '</manifest>\n' % attrs)
+
+    def test_present(self):
+        messages = manifest_check.find_missing_instrumentation_attributes(
+            self.xml('android:handleProfiling="false" android:functionalTest="true"'),
+            {'handleProfiling': None, 'functionalTest': 'true'})
+        self.assertEqual(messages, [])
+
+    def test_missing(self):
+        messages = manifest_check.find_missing_instrumentation_attributes(
+            self.xml('android:functionalTest="true"'),
+            {'handleProfiling': None, 'functionalTest': 'true'})
+        self.assertEqual(messages, [
+            '<instrumentation> androidx.test.runner.AndroidJUnitRunner does not '
+            'declare android:handleProfiling'
+        ])
+
+    def test_mismatch(self):
+        messages = manifest_check.find_missing_instrumentation_attributes(
+            self.xml('android:functionalTest="false"'), {'functionalTest': 'true'})
+        self.assertEqual(messages, [
+            '<instrumentation> androidx.test.runner.AndroidJUnitRunner declares '
+            'android:functionalTest="false", expected "true"'
+        ])
+
+    def test_no_instrumentation(self):
+        xml = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo.test"/>\n')
+        messages = manifest_check.find_missing_instrumentation_attributes(
+            xml, {'functionalTest': None})
+        self.assertEqual(messages, ['the manifest has no <instrumentation> tag'])
+
+
+class FindDeprecatedPermissionsTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <uses-permission android:name="android.permission.OLD"/>\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+        '</manifest>\n')
+
+    def test_deprecated(self):
+        messages = manifest_check.find_deprecated_permissions(
+            self.xml, {'android.permission.OLD': 'android.permission.NEW'})
+        self.assertEqual(messages, [
+            '<uses-permission> requests deprecated permission '
+            '"android.permission.OLD", use "android.permission.NEW" instead'
+        ])
+
+    def test_none(self):
+        messages = manifest_check.find_deprecated_permissions(
+            self.xml, {'android.permission.OTHER': 'android.permission.NEW'})
+        self.assertEqual(messages, [])
+
+
+class SectionLibPermissionsTest(unittest.TestCase):
+
+    main = (
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+        '%s'
+        '</manifest>\n')
+
+    lib = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.bar">\n'
+        '    <uses-permission android:name="android.permission.CAMERA"/>\n'
+        '</manifest>\n')
+
+    def test_section(self):
+        application = '    <application/>\n'
+        merged = minidom.parseString(self.main % (
+            '    <uses-permission android:name="android.permission.CAMERA"/>\n' +
+            application))
+        moved = manifest_check.section_lib_permissions(
+            minidom.parseString(self.main % application), merged,
+            [('bar/AndroidManifest.xml', self.lib)])
+        self.assertEqual(moved, ['android.permission.CAMERA'])
+        self.assertEqual(
+            merged.documentElement.toxml(),
+            '<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '    <!-- BEGIN <uses-permission> merged from static libraries -->\n'
+            '    <!-- from bar/AndroidManifest.xml -->\n'
+            '    <uses-permission android:name="android.permission.CAMERA"/>\n'
+            '    <!-- END <uses-permission> merged from static libraries -->\n'
+            '    <application/>\n'
+            '</manifest>')
+
+    def test_no_application(self):
+        merged = minidom.parseString(self.main % (
+            '    <uses-permission android:name="android.permission.READ_PHONE_STATE"/>\n'))
+        manifest_check.section_lib_permissions(
+            minidom.parseString(self.main % ''), merged,
+            [('bar/AndroidManifest.xml', self.lib)])
+        self.assertEqual(
+            merged.documentElement.toxml(),
+            '<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '    <!-- BEGIN <uses-permission> merged from static libraries -->\n'
+            '    <!-- added by the manifest merger -->\n'
+            '    <uses-permission android:name="android.permission.READ_PHONE_STATE"/>\n'
+            '    <!-- END <uses-permission> merged from static libraries -->\n'
+            '</manifest>')
+
+    def test_nothing_merged(self):
+        merged = minidom.parseString(self.main % '')
+        self.assertEqual(
+            manifest_check.section_lib_permissions(
+                minidom.parseString(self.main % ''), merged, []), [])
+        self.assertEqual(merged.toxml(), minidom.parseString(self.main % '').toxml())
+
+
+class FindMergeChangesTest(unittest.TestCase):
+
+    def test_changes(self):
+        main = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-sdk android:minSdkVersion="29"/>\n'
+            '    <application android:label="Foo">\n'
+            '        <activity android:name=".Main"/>\n'
+            '    </application>\n'
+            '</manifest>\n')
+        merged = minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-sdk android:minSdkVersion="30"/>\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '    <application android:label="Foo" android:allowBackup="false">\n'
+            '        <activity android:name="com.android.foo.Main"/>\n'
+            '        <service android:name="com.android.bar.Service"/>\n'
+            '    </application>\n'
+            '</manifest>\n')
+        changes = manifest_check.find_merge_changes(main, merged)
+        self.assertEqual(changes, [
+            {
+                'path': '/manifest/application',
+                'change': 'added attribute',
+                'attribute': 'android:allowBackup',
+                'value': 'false',
+                'previous': None,
+            },
+            {
+                'path': '/manifest/application/service'
+                        '[@android:name="com.android.bar.Service"]',
+                'change': 'added node',
+                'attribute': None,
+                'value': None,
+                'previous': None,
+            },
+            {
+                'path': '/manifest/uses-permission'
+                        '[@android:name="android.permission.INTERNET"]',
+                'change': 'added node',
+                'attribute': None,
+                'value': None,
+                'previous': None,
+            },
+            {
+                'path': '/manifest/uses-sdk',
+                'change': 'overridden attribute',
+                'attribute': 'android:minSdkVersion',
+                'value': '30',
+                'previous': '29',
+            },
+        ])
+
+    def test_unchanged(self):
+        xml = ('<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+               'xmlns:android="http://schemas.android.com/apk/res/android" '
+               'package="com.android.foo">\n'
+               '    <application/>\n'
+               '</manifest>\n')
+        changes = manifest_check.find_merge_changes(
+            minidom.parseString(xml), minidom.parseString(xml))
+        self.assertEqual(changes, [])
+
+
+class FindSandboxedSharedUserIdTest(unittest.TestCase):
+
+    def xml(self, attrs):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo"%s/>\n' % attrs)
+
+    def test_conflict(self):
+        self.assertEqual(
+            manifest_check.find_sandboxed_shared_user_id(self.xml(
+                ' android:sharedUserId="android.uid.foo"'
+                ' android:targetSandboxVersion="2"')),
+            ('android.uid.foo', '2'))
+
+    def test_sandbox_version_1(self):
+        self.assertIsNone(
+            manifest_check.find_sandboxed_shared_user_id(self.xml(
+                ' android:sharedUserId="android.uid.foo"'
+                ' android:targetSandboxVersion="1"')))
+
+    def test_no_shared_user_id(self):
+        self.assertIsNone(
+            manifest_check.find_sandboxed_shared_user_id(self.xml(
+                ' android:targetSandboxVersion="2"')))
+
+
+class FindSharedUserIdTest(unittest.TestCase):
+
+    def xml(self, target_sdk_version, shared_user_id='android.uid.foo'):
+        attr = ''
+        if shared_user_id:
+            attr = ' android:sharedUserId="%s"' % shared_user_id
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo"%s>\n'
+            '    <uses-sdk android:minSdkVersion="29" '
+            'android:targetSdkVersion="%s"/>\n'
+            '</manifest>\n' % (attr, target_sdk_version))
+
+    def test_high_target(self):
+        found = manifest_check.find_shared_user_id(self.xml('34'), 33)
+        self.assertEqual(found, ('android.uid.foo', '34'))
+
+    def test_codename_target(self):
+        found = manifest_check.find_shared_user_id(
+            self.xml('VanillaIceCream'), 33)
+        self.assertEqual(found, ('android.uid.foo', 'VanillaIceCream'))
+
+    def test_low_target(self):
+        found = manifest_check.find_shared_user_id(self.xml('32'), 33)
+        self.assertIsNone(found)
+
+    def test_no_shared_user_id(self):
+        found = manifest_check.find_shared_user_id(
+            self.xml('34', shared_user_id=None), 33)
+        self.assertIsNone(found)
+
+
+class FindWeaklyProtectedComponentsTest(unittest.TestCase):
+
+    def xml(self, level, exported='true'):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <permission android:name="com.android.foo.BIND" '
+            'android:protectionLevel="%s"/>\n'
+            '    <application>\n'
+            '        <service android:name=".Service" android:exported="%s" '
+            'android:permission="com.android.foo.BIND"/>\n'
+            '        <service android:name=".Other" android:exported="true" '
+            'android:permission="android.permission.BIND_JOB_SERVICE"/>\n'
+            '    </application>\n'
+            '</manifest>\n' % (level, exported))
+
+    def test_weak(self):
+        messages = manifest_check.find_weakly_protected_components(
+            self.xml('dangerous'))
+        self.assertEqual(messages, [
+            'exported <service> .Service is guarded by permission '
+            'com.android.foo.BIND with protectionLevel "dangerous"'
+        ])
+
+    def test_signature(self):
+        messages = manifest_check.find_weakly_protected_components(
+            self.xml('signature|privileged'))
+        self.assertEqual(messages, [])
+
+    def test_not_exported(self):
+        messages = manifest_check.find_weakly_protected_components(
+            self.xml('normal', exported='false'))
+        self.assertEqual(messages, [])
+
+
+class FindProvidersWithoutAuthoritiesTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <application>\n'
+        '        <provider android:name=".Provider" '
+        'android:authorities="com.android.foo.provider"/>\n'
+        '        <provider android:name=".Missing"/>\n'
+        '        <provider android:name=".Empty" android:authorities=""/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+
+    def test_find(self):
+        self.assertEqual(
+            manifest_check.find_providers_without_authorities(self.xml),
+            ['.Missing', '.Empty'])
+
+
+class FindComponentsWithDisabledFeatureFlagsTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <application>\n'
+        '        <activity android:name=".NewActivity">\n'
+        '            <meta-data android:name="android.soong.FEATURE_FLAG" '
+        'android:value="new_ui"/>\n'
+        '        </activity>\n'
+        '        <service android:name=".SyncService">\n'
+        '            <meta-data android:name="android.soong.FEATURE_FLAG" '
+        'android:value="cloud_sync"/>\n'
+        '        </service>\n'
+        '        <activity android:name=".MainActivity"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+
+    def test_enabled(self):
+        self.assertEqual(
+            manifest_check.find_components_with_disabled_feature_flags(
+                self.xml, ['new_ui', 'cloud_sync']), [])
+
+    def test_disabled(self):
+        self.assertEqual(
+            manifest_check.find_components_with_disabled_feature_flags(
+                self.xml, ['new_ui']),
+            ['com.android.foo.SyncService (cloud_sync)'])
+
+
+class FindFileProvidersWithoutPathsTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <application>\n'
+        '        <provider android:name="androidx.core.content.FileProvider" '
+        'android:authorities="com.android.foo.files" '
+        'android:grantUriPermissions="true">\n'
+        '            <meta-data android:name="android.support.FILE_PROVIDER_PATHS" '
+        'android:resource="@xml/file_paths"/>\n'
+        '        </provider>\n'
+        '        <provider android:name=".ShareFileProvider" '
+        'android:authorities="com.android.foo.share" '
+        'android:grantUriPermissions="true"/>\n'
+        '        <provider android:name=".CacheFileProvider" '
+        'android:authorities="com.android.foo.cache"/>\n'
+        '        <provider android:name=".Provider" '
+        'android:authorities="com.android.foo.provider" '
+        'android:grantUriPermissions="true"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+
+    def test_find(self):
+        self.assertEqual(
+            manifest_check.find_file_providers_without_paths(self.xml),
+            ['com.android.foo.ShareFileProvider'])
+
+
+class FindUnguardedExportedServicesTest(unittest.TestCase):
+
+    def xml(self, application_permission=''):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application%s>\n'
+            '        <service android:name=".Guarded" android:exported="true" '
+            'android:permission="com.android.foo.BIND"/>\n'
+            '        <service android:name=".Unguarded" android:exported="true"/>\n'
+            '        <service android:name=".Open" android:exported="true"/>\n'
+            '        <service android:name=".Private" android:exported="false"/>\n'
+            '    </application>\n'
+            '</manifest>\n' % application_permission)
+
+    def test_unguarded(self):
+        self.assertEqual(
+            manifest_check.find_unguarded_exported_services(
+                self.xml(), ['com.android.foo.Open']),
+            ['.Unguarded'])
+
+    def test_application_permission(self):
+        self.assertEqual(
+            manifest_check.find_unguarded_exported_services(
+                self.xml(' android:permission="com.android.foo.ACCESS"'), []),
+            [])
+
+
+class FindUnguardedExportedProvidersTest(unittest.TestCase):
+
+    def xml(self, application_permission=''):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application%s>\n'
+            '        <provider android:name=".Guarded" android:exported="true" '
+            'android:authorities="com.android.foo.guarded" '
+            'android:permission="com.android.foo.ACCESS"/>\n'
+            '        <provider android:name=".ReadWrite" android:exported="true" '
+            'android:authorities="com.android.foo.readwrite" '
+            'android:readPermission="com.android.foo.READ" '
+            'android:writePermission="com.android.foo.WRITE"/>\n'
+            '        <provider android:name=".ReadOnly" android:exported="true" '
+            'android:authorities="com.android.foo.readonly" '
+            'android:readPermission="com.android.foo.READ"/>\n'
+            '        <provider android:name=".Unguarded" android:exported="true" '
+            'android:authorities="com.android.foo.unguarded"/>\n'
+            '        <provider android:name=".Open" android:exported="true" '
+            'android:authorities="com.android.foo.open"/>\n'
+            '        <provider android:name=".Private" android:exported="false" '
+            'android:authorities="com.android.foo.private"/>\n'
+            '    </application>\n'
+            '</manifest>\n' % application_permission)
+
+    def test_unguarded(self):
+        self.assertEqual(
+            manifest_check.find_unguarded_exported_providers(
+                self.xml(), ['com.android.foo.Open']),
+            ['.ReadOnly', '.Unguarded'])
+
+    def test_application_permission(self):
+        self.assertEqual(
+            manifest_check.find_unguarded_exported_providers(
+                self.xml(' android:permission="com.android.foo.ACCESS"'), []),
+            [])
+
+
+class FindStructureErrorsTest(unittest.TestCase):
+
+    def xml(self, application):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+            '%s'
+            '</manifest>\n' % application)
+
+    def test_valid(self):
+        self.assertEqual(
+            manifest_check.find_structure_errors(self.xml(
+                '    <application>\n'
+                '        <activity android:name=".Main">\n'
+                '            <intent-filter>\n'
+                '                <action android:name="android.intent.action.MAIN"/>\n'
+                '            </intent-filter>\n'
+                '        </activity>\n'
+                '    </application>\n')),
+            [])
+
+    def test_misplaced(self):
+        self.assertEqual(
+            manifest_check.find_structure_errors(self.xml(
+                '    <application>\n'
+                '        <uses-permission android:name="android.permission.CAMERA"/>\n'
+                '    </application>\n'
+                '    <activity android:name=".Main"/>\n')),
+            ['<uses-permission> /manifest/application/uses-permission must be '
+             'nested in <manifest>',
+             '<activity> /manifest/activity must be nested in <application>'])
+
+    def test_duplicate_application(self):
+        self.assertEqual(
+            manifest_check.find_structure_errors(self.xml(
+                '    <application/>\n    <application/>\n')),
+            ['<application> is declared 2 times in /manifest, at most one is '
+             'allowed'])
+
+    def test_root(self):
+        self.assertEqual(
+            manifest_check.find_structure_errors(
+                minidom.parseString('<application/>')),
+            ['root element is <application>, expected <manifest>'])
+
+
+class CheckUsesPermissionBudgetTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+        '    <uses-permission-sdk-23 android:name="android.permission.CAMERA"/>\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n'
+        '    <uses-permission android:name="android.permission.WAKE_LOCK"/>\n'
+        '    <permission android:name="com.android.foo.BIND"/>\n'
+        '</manifest>\n')
+
+    def test_within_budget(self):
+        manifest_check.check_uses_permission_budget(self.xml, 3)
+
+    def test_over_budget(self):
+        with self.assertRaisesRegex(
+                manifest_check.ManifestMismatchError,
+                'manifest requests 3 permissions, more than the budget of 2:\n'
+                '\tandroid.permission.CAMERA\n'
+                '\tandroid.permission.INTERNET\n'
+                '\tandroid.permission.WAKE_LOCK'):
+            manifest_check.check_uses_permission_budget(self.xml, 2)
+
+
+class FindDanglingIconResourcesTest(unittest.TestCase):
+
+    xml = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+        'xmlns:android="http://schemas.android.com/apk/res/android" '
+        'package="com.android.foo">\n'
+        '    <application android:icon="@mipmap/ic_launcher" '
+        'android:roundIcon="@mipmap/ic_launcher_round">\n'
+        '        <activity android:name=".Main" '
+        'android:icon="@com.android.foo:drawable/main.icon"/>\n'
+        '        <activity android:name=".Other" '
+        'android:icon="@android:mipmap/sym_def_app_icon"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+
+    def test_find(self):
+        resources = manifest_check.parse_r_txt([
+            'int mipmap ic_launcher 0x7f0c0000\n',
+            'int drawable main_icon 0x7f080000\n',
+            'int[] styleable Foo { 0x7f040000 }\n',
+        ])
+        self.assertEqual(
+            manifest_check.find_dangling_icon_resources(self.xml, resources),
+            ['<application> android:roundIcon="@mipmap/ic_launcher_round" '
+             'refers to a resource that does not exist'])
+
+
+class FindOverlayableErrorsTest(unittest.TestCase):
+
+    overlayable = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<resources>\n'
+        '    <overlayable name="FooResources">\n'
+        '        <policy type="public">\n'
+        '            <item type="string" name="title"/>\n'
+        '        </policy>\n'
+        '    </overlayable>\n'
+        '</resources>\n')
+
+    strings = minidom.parseString(
+        '<?xml version="1.0" encoding="utf-8"?>\n<resources>\n'
+        '    <string name="title">Foo</string>\n'
+        '</resources>\n')
+
+    def test_valid(self):
+        messages = manifest_check.find_overlayable_errors(
+            'com.android.foo', 'com.android.foo',
+            [('strings.xml', self.strings), ('overlayable.xml', self.overlayable)])
+        self.assertEqual(messages, [])
+
+    def test_package_mismatch(self):
+        messages = manifest_check.find_overlayable_errors(
+            'com.android.bar', 'com.android.foo',
+            [('overlayable.xml', self.overlayable)])
+        self.assertEqual(messages, [
+            'overlays target package "com.android.foo", but the manifest '
+            'declares package "com.android.bar"'
+        ])
+
+    def test_no_overlayable(self):
+        messages = manifest_check.find_overlayable_errors(
+            'com.android.foo', 'com.android.foo', [('strings.xml', self.strings)])
+        self.assertEqual(messages,
+                         ['no <overlayable> is declared by the resources'])
+
+
+class FindCollidingIntentFilterPrioritiesTest(unittest.TestCase):
+
+    def xml(self, second_priority, second_category=''):
+        return minidom.parseString(
+            '<?xml version="1.0" encoding="utf-8"?>\n<manifest '
+            'xmlns:android="http://schemas.android.com/apk/res/android" '
+            'package="com.android.foo">\n'
+            '    <application>\n'
+            '        <receiver android:name=".First">\n'
+            '            <intent-filter android:priority="1000">\n'
+            '                <action android:name="android.intent.action.BOOT_COMPLETED"/>\n'
+            '            </intent-filter>\n'
+            '        </receiver>\n'
+            '        <receiver android:name=".Second">\n'
+            '            <intent-filter android:priority="%s">\n'
+            '                <action android:name="android.intent.action.BOOT_COMPLETED"/>\n'
+            '                <action android:name="android.intent.action.LOCKED_BOOT_COMPLETED"/>\n'
+            '%s'
+            '            </intent-filter>\n'
+            '        </receiver>\n'
+            '    </application>\n'
+            '</manifest>\n' % (second_priority, second_category))
+
+    def test_colliding(self):
+        messages = manifest_check.find_colliding_intent_filter_priorities(
+            self.xml('1000'))
+        self.assertEqual(messages, [
+            '<receiver> .First and .Second declare intent filters with '
+            'android:priority="1000" for android.intent.action.BOOT_COMPLETED'
+        ])
+
+    def test_different_priorities(self):
+        messages = manifest_check.find_colliding_intent_filter_priorities(
+            self.xml('999'))
+        self.assertEqual(messages, [])
+
+    def test_different_categories(self):
+        messages = manifest_check.find_colliding_intent_filter_priorities(
+            self.xml('1000', '                <category '
+                     'android:name="android.intent.category.DEFAULT"/>\n'))
+        self.assertEqual(messages, [])
+
+
 if __name__ == '__main__':
     unittest.main(verbosity=2)
@@ -27,9 +27,14 @@ from manifest import android_ns
 from manifest import compare_version_gt
 from manifest import ensure_manifest_android_ns
 from manifest import find_child_with_attribute
+from manifest import find_launcher_activities_without_exported
+from manifest import find_receivers_without_exported
 from manifest import get_children_with_tag
 from manifest import get_indent
+from manifest import is_exported
 from manifest import parse_manifest
+from manifest import resolve_class_name
+from manifest import tools_ns
 from manifest import write_xml
 
 
@@ -45,6 +50,16 @@ def parse_args():
                       help='specify targetSdkVersion used by the build system')
   parser.add_argument('--raise-min-sdk-version', dest='raise_min_sdk_version', action='store_true',
                       help='raise the minimum sdk version in the manifest if necessary')
+  parser.add_argument('--warn-min-sdk-version-mismatch', dest='warn_min_sdk_version_mismatch',
+                      action='store_true',
+                      help=('print a warning if the manifest declares a minSdkVersion that differs from '
+                            '--minSdkVersion'))
+  parser.add_argument('--warn-missing-uses-libraries', dest='warn_missing_uses_libraries',
+                      help=('comma-separated libraries in the class loader context of the module; '
+                            'print a warning if the manifest has no <uses-library> tags'))
+  parser.add_argument('--warnings-output', dest='warnings_output',
+                      help=('write the warnings about the manifest to the given file, one per '
+                            'line, instead of printing them'))
   parser.add_argument('--library', dest='library', action='store_true',
                       help='manifest is for a static library')
   parser.add_argument('--uses-library', dest='uses_libraries', action='append',
@@ -56,6 +71,14 @@ def parse_args():
   parser.add_argument('--logging-parent', dest='logging_parent', default='',
                       help=('specify logging parent as an additional <meta-data> tag. '
                             'This value is ignored if the logging_parent meta-data tag is present.'))
+  parser.add_argument('--test-suites-meta-data', dest='test_suites_meta_data',
+                      help=('specify the comma-separated test suites of a test app, added as an '
+                            'android.testSuites <meta-data> tag. Replaces the value of an existing '
+                            'tag.'))
+  parser.add_argument('--app-restrictions', dest='app_restrictions',
+                      help=('specify the @xml/... resource declaring the restrictions of the app, '
+                            'added as an android.content.APP_RESTRICTIONS <meta-data> tag. This '
+                            'value is ignored if the tag is present.'))
   parser.add_argument('--use-embedded-dex', dest='use_embedded_dex', action='store_true',
                       help=('specify if the app wants to use embedded dex and avoid extracted,'
                             'locally compiled code. Must not conflict if already declared '
@@ -72,11 +95,616 @@ def parse_args():
                             'already has a testOnly attribute.'))
   parser.add_argument('--override-placeholder-version', dest='new_version',
                       help='Overrides the versionCode if it\'s set to the placeholder value of 0')
+  parser.add_argument('--version-code', dest='version_code',
+                      help='sets the versionCode attribute of the manifest, overriding any existing value')
+  parser.add_argument('--version-code-file', dest='version_code_file',
+                      help=('sets the versionCode attribute of the manifest to the contents of the file, '
+                            'overriding any existing value'))
+  parser.add_argument('--version-name', dest='version_name',
+                      help='sets the versionName attribute of the manifest, overriding any existing value')
+  parser.add_argument('--install-location', dest='install_location',
+                      help=('sets the installLocation attribute of the manifest, overriding any '
+                            'existing value'))
+  parser.add_argument('--is-split-required', dest='is_split_required',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the isSplitRequired attribute of the manifest, overriding any '
+                            'existing value'))
+  parser.add_argument('--shared-user-max-sdk-version', dest='shared_user_max_sdk_version',
+                      help=('sets the sharedUserMaxSdkVersion attribute of the manifest. Fails if the '
+                            'manifest does not declare sharedUserId.'))
+  parser.add_argument('--shared-user-label', dest='shared_user_label',
+                      help=('sets the sharedUserLabel attribute of the manifest. Fails if the '
+                            'manifest does not declare sharedUserId.'))
+  parser.add_argument('--allow-backup', dest='allow_backup',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the allowBackup attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--gwp-asan-mode', dest='gwp_asan_mode',
+                      choices=['always', 'never', 'default'],
+                      help=('sets the gwpAsanMode attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--hardware-accelerated', dest='hardware_accelerated',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the hardwareAccelerated attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--direct-boot-aware', dest='direct_boot_aware',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the directBootAware attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--allow-clear-user-data', dest='allow_clear_user_data',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the allowClearUserData attribute of the application. Overrides '
+                            'the value already declared in the manifest.'))
+  parser.add_argument('--kill-after-restore', dest='kill_after_restore',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the killAfterRestore attribute of the application. Overrides '
+                            'the value already declared in the manifest.'))
+  parser.add_argument('--multi-arch', dest='multi_arch',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the multiArch attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--persistent', dest='persistent',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the persistent attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--ui-options', dest='ui_options',
+                      help=('sets the uiOptions attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--restore-any-version', dest='restore_any_version',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the restoreAnyVersion attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--cross-profile', dest='cross_profile',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the crossProfile attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--allow-native-heap-pointer-tagging',
+                      dest='allow_native_heap_pointer_tagging',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the allowNativeHeapPointerTagging attribute of the application. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--large-heap', dest='large_heap',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the largeHeap attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--resizeable-activity', dest='resizeable_activity',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the resizeableActivity attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--application-attribute', dest='application_attributes', action='append',
+                      help=('specify <attribute>=<value> to set an android: attribute of the '
+                            'application. Overrides the value already declared in the manifest.'))
+  parser.add_argument('--attributions-are-user-visible', dest='attributions_are_user_visible',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the attributionsAreUserVisible attribute of the application. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--attribution', dest='attributions', action='append',
+                      help=('specify <tag>=<label> to add an <attribution> tag to the manifest, '
+                            'replacing one with the same tag.'))
+  parser.add_argument('--compatible-screen', dest='compatible_screens', action='append',
+                      help=('specify <screenSize>:<screenDensity> to add a <screen> tag to the '
+                            '<compatible-screens> tag of the manifest.'))
+  parser.add_argument('--uses-sdk-library', dest='uses_sdk_libraries', action='append',
+                      help=('specify <name>=<versionMajor>:<certDigest> to add a <uses-sdk-library> '
+                            'tag to the application, replacing one with the same name.'))
+  parser.add_argument('--request-raw-external-storage-access',
+                      dest='request_raw_external_storage_access',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the requestRawExternalStorageAccess attribute of the application. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--preserve-legacy-external-storage',
+                      dest='preserve_legacy_external_storage',
+                      default=None, type=lambda x: (str(x).lower() == 'true'),
+                      help=('sets the preserveLegacyExternalStorage attribute of the application. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--activity-hardware-accelerated', dest='activity_hardware_accelerated',
+                      action='append',
+                      help=('specify <activity class name>=<true|false> to set the hardwareAccelerated '
+                            'attribute of an activity. Overrides the value already declared in the '
+                            'manifest.'))
+  parser.add_argument('--component-direct-boot-aware', dest='component_direct_boot_aware',
+                      action='append',
+                      help=('specify <component class name>=<true|false> to set the directBootAware '
+                            'attribute of an activity, service, receiver or provider. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--component-label', dest='component_label', action='append',
+                      help=('specify <component class name>=<string reference> to set the label '
+                            'attribute of an activity, service, receiver or provider. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--component-description', dest='component_description', action='append',
+                      help=('specify <component class name>=<string reference> to set the '
+                            'description attribute of an activity, service, receiver or provider. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--activity-on-back-invoked-callback',
+                      dest='activity_on_back_invoked_callback', action='append',
+                      help=('specify <activity class name>=<true|false> to set the '
+                            'enableOnBackInvokedCallback attribute of an activity. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--activity-resizeable-activity', dest='activity_resizeable_activity',
+                      action='append',
+                      help=('specify <activity class name>=<true|false> to set the resizeableActivity '
+                            'attribute of an activity. Overrides the value already declared in the '
+                            'manifest.'))
+  parser.add_argument('--activity-ui-options', dest='activity_ui_options', action='append',
+                      help=('specify <activity class name>=<ui options> to set the uiOptions '
+                            'attribute of an activity. Overrides the value already declared in the '
+                            'manifest.'))
+  parser.add_argument('--activity-theme', dest='activity_theme', action='append',
+                      help=('specify <activity class name>=<style reference> to set the theme '
+                            'attribute of an activity. Overrides the value already declared in the '
+                            'manifest.'))
+  parser.add_argument('--activity-window-attribute', dest='activity_window_attributes',
+                      action='append',
+                      help=('specify <activity class name>=<attribute>=<value> to set a window '
+                            'attribute of an activity. Overrides the value already declared in the '
+                            'manifest.'))
+  parser.add_argument('--uses-permission-flags', dest='uses_permission_flags', action='append',
+                      help=('specify <permission>=<flags> to set the usesPermissionFlags attribute of '
+                            'the <uses-permission> tags requesting a permission. Fails if the '
+                            'permission is not requested, unless --add-missing-uses-permissions is '
+                            'set.'))
+  parser.add_argument('--add-missing-uses-permissions', dest='add_missing_uses_permissions',
+                      action='store_true',
+                      help=('add a <uses-permission> tag for permissions in --uses-permission-flags '
+                            'that the manifest does not request.'))
+  parser.add_argument('--modify-uses-permission', dest='uses_permission_modifications',
+                      action='append',
+                      help=('specify <permission>=<maxSdkVersion>:<flags> to set the maxSdkVersion '
+                            'and usesPermissionFlags attributes of the <uses-permission> tags '
+                            'requesting a permission together. An empty value leaves the attribute '
+                            'as is. Fails if the permission is not requested.'))
+  parser.add_argument('--export-launcher-activities', dest='export_launcher_activities',
+                      action='store_true',
+                      help=('set exported="true" on activities with a LAUNCHER intent filter that '
+                            'do not declare exported.'))
+  parser.add_argument('--export-broadcast-receivers', dest='export_broadcast_receivers',
+                      action='store_true',
+                      help=('set exported="true" on receivers with an intent filter for a broadcast '
+                            'that is not protected that do not declare exported.'))
+  parser.add_argument('--protected-broadcast', dest='protected_broadcasts', action='append',
+                      default=[],
+                      help=('a broadcast that only the system can send, for '
+                            '--export-broadcast-receivers'))
+  parser.add_argument('--clear-task-affinity', dest='clear_task_affinity',
+                      choices=['exported', 'all'],
+                      help=('set taskAffinity="" on the exported activities, or on all activities. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--grant-uri-permission', dest='grant_uri_permissions', action='append',
+                      help=('specify <provider class name>=<path|pathPrefix|pathPattern>:<value> to '
+                            'set grantUriPermissions="true" on a provider and add a '
+                            '<grant-uri-permission> tag to it. Fails if the provider is not '
+                            'declared in the manifest.'))
+  parser.add_argument('--disable-component', dest='disabled_components', action='append',
+                      help=('specify the class name of a component to set enabled="false" on. Fails if '
+                            'the component is not declared in the manifest.'))
+  parser.add_argument('--backup-agent', dest='backup_agent',
+                      help=('sets the backupAgent attribute of the application. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--zygote-preload-name', dest='zygote_preload_name',
+                      help=('sets the zygotePreloadName attribute of the application. Overrides the '
+                            'value already declared in the manifest.'))
+  parser.add_argument('--app-component-factory', dest='app_component_factory',
+                      help=('sets the appComponentFactory attribute of the application. Overrides '
+                            'the value already declared in the manifest.'))
+  parser.add_argument('--full-backup-content', dest='full_backup_content',
+                      help=('sets the fullBackupContent attribute of the application. Overrides the '
+                            'value already declared in the manifest. dataExtractionRules is not '
+                            'modified.'))
+  parser.add_argument('--known-activity-embedding-certs', dest='known_activity_embedding_certs',
+                      help=('sets the knownActivityEmbeddingCerts attribute of the application to a '
+                            'certificate digest or a string array resource. Overrides the value '
+                            'already declared in the manifest.'))
+  parser.add_argument('--locale-config', dest='locale_config',
+                      help=('sets the localeConfig attribute of the application to an xml resource. '
+                            'Overrides the value already declared in the manifest.'))
+  parser.add_argument('--default-theme', dest='default_theme',
+                      help=('sets the theme attribute of the application if the manifest does not '
+                            'declare one.'))
+  parser.add_argument('--override-theme', dest='override_theme', action='store_true',
+                      help='makes --default-theme override the theme already declared in the manifest.')
+  parser.add_argument('--required-split-types', dest='required_split_types',
+                      help=('sets the requiredSplitTypes attribute of the manifest to the given '
+                            'comma-separated list. Overrides the value already declared in the manifest.'))
+  parser.add_argument('--split-types', dest='split_types',
+                      help=('sets the splitTypes attribute of the manifest to the given comma-separated '
+                            'list. Overrides the value already declared in the manifest.'))
+  parser.add_argument('--application-property', dest='application_properties', action='append',
+                      help=('specify <name>=<value> to add a <property> with an android:value to '
+                            '<application>.'))
+  parser.add_argument('--application-resource-property', dest='application_resource_properties',
+                      action='append',
+                      help=('specify <name>=<resource> to add a <property> with an android:resource '
+                            'to <application>.'))
+  parser.add_argument('--canonicalize-namespaces', dest='canonicalize_namespaces',
+                      action='store_true',
+                      help='use the android: and tools: prefixes for the android and tools namespaces')
+  parser.add_argument('--strip-tools-attributes', dest='strip_tools_attributes',
+                      action='store_true',
+                      help='remove all tools: attributes and the tools namespace declaration')
+  parser.add_argument('--remove-meta-data', dest='remove_meta_data', action='append',
+                      help=('specify the name of a <meta-data> tag to remove from <application>. '
+                            'Ignored if no such tag is present.'))
+  parser.add_argument('--remove-compile-sdk-version', dest='remove_compile_sdk_version',
+                      action='store_true',
+                      help=('removes the compileSdkVersion and compileSdkVersionCodename attributes '
+                            'from the manifest.'))
+  parser.add_argument('--strip-overlay', dest='strip_overlay', action='store_true',
+                      help=('removes the <overlay> tag and the package of the manifest of a runtime '
+                            'resource overlay, so that it can be merged into the manifest of an app. '
+                            'Relative class names are resolved against the removed package.'))
+  parser.add_argument('--mark-final', dest='mark_final', action='store_true',
+                      help=('marks the output as final. The values injected by the build system, e.g. '
+                            'the SDK versions, are left alone when a final manifest is passed to '
+                            'manifest_fixer again, e.g. after being captured in a prebuilt.'))
   parser.add_argument('input', help='input AndroidManifest.xml file')
   parser.add_argument('output', help='output AndroidManifest.xml file')
   return parser.parse_args()
 
 
+FINAL_MARKER = ' manifest_fixer: final '
+
+# The destinations of the arguments that inject values derived by the build system rather than
+# requested by the module, e.g. the SDK versions.  A final manifest already contains them, so they
+# are ignored when it is fixed again.
+BUILD_INJECTED_ARGS = ['raise_min_sdk_version', 'max_sdk_version', 'uses_libraries',
+                       'optional_uses_libraries', 'uses_non_sdk_api', 'logging_parent',
+                       'use_embedded_dex', 'has_no_code', 'test_only', 'extract_native_libs',
+                       'new_version']
+
+
+def is_final(doc):
+  """Returns True if the document was marked final by mark_final."""
+  for node in doc.childNodes:
+    if node.nodeType == minidom.Node.COMMENT_NODE and node.data == FINAL_MARKER:
+      return True
+  return False
+
+
+def mark_final(doc):
+  """Mark the document as final so that it is not fixed again.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  """
+  if not is_final(doc):
+    doc.insertBefore(doc.createComment(FINAL_MARKER), doc.documentElement)
+
+
+def set_manifest_attribute(doc, name, value):
+  """Set an android: attribute of <manifest>, overriding any existing value.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    name: The name of the attribute without the android: prefix.
+    value: The string value of the attribute.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  manifest.setAttributeNS(android_ns, 'android:' + name, value)
+
+
+def read_version_code_file(path):
+  """Read a versionCode from a file.
+
+  Args:
+    path: The file holding the versionCode, optionally surrounded by whitespace.
+  Returns:
+    The versionCode.
+  Raises:
+    RuntimeError: The file does not contain a valid versionCode
+  """
+  with open(path) as f:
+    version_code = f.read().strip()
+  if not version_code.isdigit():
+    raise RuntimeError('invalid versionCode "%s" in %s' % (version_code, path))
+  return version_code
+
+
+def set_shared_user_max_sdk_version(doc, version):
+  """Set android:sharedUserMaxSdkVersion on <manifest>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    version: The highest API level that the shared user id applies to.
+  Raises:
+    RuntimeError: Invalid manifest or the manifest does not declare a shared user id
+  """
+  manifest = parse_manifest(doc)
+  if not manifest.hasAttributeNS(android_ns, 'sharedUserId'):
+    raise RuntimeError('sharedUserMaxSdkVersion requires android:sharedUserId to be declared')
+  set_manifest_attribute(doc, 'sharedUserMaxSdkVersion', version)
+
+
+def set_shared_user_label(doc, label):
+  """Set android:sharedUserLabel on <manifest>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    label: The @string/... reference of the label of the shared user id.
+  Raises:
+    RuntimeError: Invalid manifest or the manifest does not declare a shared user id
+  """
+  manifest = parse_manifest(doc)
+  if not manifest.hasAttributeNS(android_ns, 'sharedUserId'):
+    raise RuntimeError('sharedUserLabel requires android:sharedUserId to be declared')
+  set_manifest_attribute(doc, 'sharedUserLabel', label)
+
+
+def remove_manifest_attributes(doc, names):
+  """Remove android: attributes from <manifest>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    names: The names of the attributes without the android: prefix.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  for name in names:
+    if manifest.hasAttributeNS(android_ns, name):
+      manifest.removeAttributeNS(android_ns, name)
+
+
+def strip_tools_attributes(doc):
+  """Remove all tools: attributes and declarations of the tools namespace.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  """
+  elems = [doc.documentElement]
+  while elems:
+    elem = elems.pop()
+    for attr in list(elem.attributes.values()):
+      if attr.namespaceURI == tools_ns or (attr.namespaceURI == minidom.XMLNS_NAMESPACE and
+                                           attr.value == tools_ns):
+        elem.removeAttributeNode(attr)
+    elems.extend(child for child in elem.childNodes
+                 if child.nodeType == minidom.Node.ELEMENT_NODE)
+
+
+CANONICAL_NAMESPACE_PREFIXES = {android_ns: 'android', tools_ns: 'tools'}
+
+
+def canonicalize_namespaces(doc):
+  """Use the android: and tools: prefixes for the android and tools namespaces.
+
+  Attributes using other prefixes for the namespaces are renamed, the declarations of the other
+  prefixes are removed and the canonical prefixes are declared on the <manifest> tag instead.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  Raises:
+    RuntimeError: A canonical prefix is declared for a different namespace
+  """
+  manifest = parse_manifest(doc)
+  canonical_namespaces = {prefix: ns for ns, prefix in CANONICAL_NAMESPACE_PREFIXES.items()}
+
+  declared = set()
+  elems = [manifest]
+  while elems:
+    elem = elems.pop()
+    for attr in list(elem.attributes.values()):
+      if attr.namespaceURI == minidom.XMLNS_NAMESPACE:
+        expected = canonical_namespaces.get(attr.localName)
+        if expected is not None and attr.value != expected:
+          raise RuntimeError('prefix %s is declared for namespace %s instead of %s' %
+                             (attr.localName, attr.value, expected))
+        if attr.value in CANONICAL_NAMESPACE_PREFIXES:
+          declared.add(attr.value)
+          if expected is None or elem is not manifest:
+            elem.removeAttributeNode(attr)
+        continue
+      prefix = CANONICAL_NAMESPACE_PREFIXES.get(attr.namespaceURI)
+      if prefix is not None and attr.prefix != prefix:
+        elem.removeAttributeNode(attr)
+        elem.setAttributeNS(attr.namespaceURI, prefix + ':' + attr.localName, attr.value)
+    elems.extend(child for child in elem.childNodes
+                 if child.nodeType == minidom.Node.ELEMENT_NODE)
+
+  for ns in sorted(declared):
+    prefix = CANONICAL_NAMESPACE_PREFIXES[ns]
+    if manifest.getAttributeNodeNS(minidom.XMLNS_NAMESPACE, prefix) is None:
+      manifest.setAttributeNS(minidom.XMLNS_NAMESPACE, 'xmlns:' + prefix, ns)
+
+
+def get_or_create_application(doc):
+  """Get the <application> element, inserting one if the manifest has none.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  Returns:
+    The <application> element.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  elems = get_children_with_tag(manifest, 'application')
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+  elif elems:
+    return elems[0]
+
+  application = doc.createElement('application')
+  indent = get_indent(manifest.firstChild, 1)
+  first = manifest.firstChild
+  manifest.insertBefore(doc.createTextNode(indent), first)
+  manifest.insertBefore(application, first)
+  return application
+
+
+def set_application_attribute(doc, name, value, override=True):
+  """Set an android: attribute of <application>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    name: The name of the attribute without the android: prefix.
+    value: The string value of the attribute.
+    override: Whether to replace a value already declared in the manifest.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  application = get_or_create_application(doc)
+  if not override and application.hasAttributeNS(android_ns, name):
+    return
+  application.setAttributeNS(android_ns, 'android:' + name, value)
+
+
+def check_min_sdk_version(doc, min_sdk_version):
+  """Compare the minSdkVersion declared in the manifest with the requested one.
+
+  Args:
+    doc: The XML document.
+    min_sdk_version: The requested minSdkVersion attribute.
+  Returns:
+    A warning message if the manifest declares a different minSdkVersion, otherwise None.
+  Raises:
+    RuntimeError: invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  uses_sdk = get_children_with_tag(manifest, 'uses-sdk')
+  if len(uses_sdk) != 1:
+    return None
+  min_attr = uses_sdk[0].getAttributeNodeNS(android_ns, 'minSdkVersion')
+  if min_attr is None or min_attr.value == min_sdk_version:
+    return None
+  return ('manifest declares minSdkVersion="%s" but the module\'s min_sdk_version is "%s"' %
+          (min_attr.value, min_sdk_version))
+
+
+def check_missing_uses_libraries(doc, clc_libraries):
+  """Check that the manifest declares <uses-library> tags for a non-empty class loader context.
+
+  Args:
+    doc: The XML document.
+    clc_libraries: The libraries in the class loader context of the module.
+  Returns:
+    A warning message if the manifest has no <uses-library> tags, otherwise None.
+  Raises:
+    RuntimeError: invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  for application in get_children_with_tag(manifest, 'application'):
+    if get_children_with_tag(application, 'uses-library'):
+      return None
+  return ('the class loader context contains %s but the manifest has no <uses-library> tags, '
+          'the uses_libs of the module may be misconfigured' % ', '.join(clc_libraries))
+
+
+def check_attribute_target_sdk_version(doc, name, min_target_sdk_version):
+  """Check that an android: attribute has an effect on the app.
+
+  Some attributes are ignored for apps targeting older SDK versions, e.g.
+  android:requestRawExternalStorageAccess before 30.
+
+  Args:
+    doc: The XML document.
+    name: The name of the attribute without the android: prefix.
+    min_target_sdk_version: The lowest targetSdkVersion for which the attribute has an effect.
+  Returns:
+    A warning message if the manifest targets an older SDK version, otherwise None.
+  Raises:
+    RuntimeError: invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  uses_sdk = get_children_with_tag(manifest, 'uses-sdk')
+  if len(uses_sdk) != 1:
+    return None
+  target_attr = uses_sdk[0].getAttributeNodeNS(android_ns, 'targetSdkVersion')
+  if target_attr is None or not compare_version_gt(min_target_sdk_version, target_attr.value):
+    return None
+  return ('%s has no effect on apps with targetSdkVersion="%s", it requires targetSdkVersion %s '
+          'or higher' % (name, target_attr.value, min_target_sdk_version))
+
+
+COMPONENT_TAGS = ['activity', 'activity-alias', 'service', 'receiver', 'provider']
+
+
+def set_component_attribute(doc, tags, component, name, value):
+  """Set an android: attribute of a component declared in <application>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    tags: The tags the component may be declared with, e.g. ['activity'].
+    component: The class name of the component. Relative names are resolved against the
+      package of the manifest before comparing.
+    name: The name of the attribute without the android: prefix.
+    value: The string value of the attribute.
+  Raises:
+    RuntimeError: Invalid manifest or the component is not declared
+  """
+  for elem in find_components(doc, tags, component):
+    elem.setAttributeNS(android_ns, 'android:' + name, value)
+
+
+def find_components(doc, tags, component):
+  """Find the declarations of a component in <application>.
+
+  Args:
+    doc: The XML document.
+    tags: The tags the component may be declared with, e.g. ['activity'].
+    component: The class name of the component. Relative names are resolved against the
+      package of the manifest before comparing.
+  Returns:
+    The list of elements declaring the component.
+  Raises:
+    RuntimeError: Invalid manifest or the component is not declared
+  """
+  manifest = parse_manifest(doc)
+  package = manifest.getAttribute('package')
+  elems = get_children_with_tag(manifest, 'application')
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+
+  component = resolve_class_name(package, component)
+  found = []
+  for application in elems:
+    for tag in tags:
+      for elem in get_children_with_tag(application, tag):
+        elem_name = elem.getAttributeNS(android_ns, 'name')
+        if elem_name and resolve_class_name(package, elem_name) == component:
+          found.append(elem)
+  if not found:
+    raise RuntimeError('<%s> %s not found in manifest' % ('|'.join(tags), component))
+  return found
+
+
+def add_grant_uri_permission(doc, provider, attr, value):
+  """Set android:grantUriPermissions on a provider and add a <grant-uri-permission> child to it.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    provider: The class name of the provider.
+    attr: 'path', 'pathPrefix' or 'pathPattern'.
+    value: The value of the attribute.
+  Raises:
+    RuntimeError: Invalid manifest or the provider is not declared
+  """
+  for elem in find_components(doc, ['provider'], provider):
+    elem.setAttributeNS(android_ns, 'android:grantUriPermissions', 'true')
+    if find_child_with_attribute(elem, 'grant-uri-permission', android_ns, attr,
+                                 value) is not None:
+      continue
+
+    indent = get_indent(elem.firstChild, 3)
+
+    last = elem.lastChild
+    if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+      last = None
+
+    grant = doc.createElement('grant-uri-permission')
+    grant.setAttributeNS(android_ns, 'android:' + attr, value)
+    elem.insertBefore(doc.createTextNode(indent), last)
+    elem.insertBefore(grant, last)
+    last = elem.lastChild
+
+    # align the closing tag with the opening tag if it's not
+    # indented
+    if last and last.nodeType != minidom.Node.TEXT_NODE:
+      indent = get_indent(elem.previousSibling, 2)
+      elem.appendChild(doc.createTextNode(indent))
+
+
 def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library):
   """Ensure the manifest contains a <uses-sdk> tag with a minSdkVersion.
 
@@ -134,6 +762,125 @@ def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library):
     element.setAttributeNode(target_attr)
 
 
+def add_application_property(doc, name, attr, value):
+  """Add a <property> tag to <application>, replacing one with the same name.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    name: The android:name of the property.
+    attr: 'value' or 'resource'.
+    value: The value of the attribute.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  application = get_or_create_application(doc)
+
+  prop = find_child_with_attribute(application, 'property', android_ns, 'name', name)
+  if prop is not None:
+    for old_attr in ['value', 'resource']:
+      if prop.hasAttributeNS(android_ns, old_attr):
+        prop.removeAttributeNS(android_ns, old_attr)
+    prop.setAttributeNS(android_ns, 'android:' + attr, value)
+    return
+
+  indent = get_indent(application.firstChild, 2)
+
+  last = application.lastChild
+  if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+    last = None
+
+  prop = doc.createElement('property')
+  prop.setAttributeNS(android_ns, 'android:name', name)
+  prop.setAttributeNS(android_ns, 'android:' + attr, value)
+  application.insertBefore(doc.createTextNode(indent), last)
+  application.insertBefore(prop, last)
+  last = application.lastChild
+
+  # align the closing tag with the opening tag if it's not
+  # indented
+  if last and last.nodeType != minidom.Node.TEXT_NODE:
+    indent = get_indent(application.previousSibling, 1)
+    application.appendChild(doc.createTextNode(indent))
+
+
+TEST_SUITES_META_DATA = 'android.testSuites'
+
+
+def add_test_suites_meta_data(doc, test_suites):
+  """Add the test suites of a test app as an android.testSuites <meta-data> tag.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    test_suites: The comma-separated names of the test suites.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  application = get_or_create_application(doc)
+
+  meta_data = find_child_with_attribute(application, 'meta-data', android_ns, 'name',
+                                        TEST_SUITES_META_DATA)
+  if meta_data is not None:
+    meta_data.setAttributeNS(android_ns, 'android:value', test_suites)
+    return
+
+  indent = get_indent(application.firstChild, 2)
+
+  last = application.lastChild
+  if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+    last = None
+
+  meta_data = doc.createElement('meta-data')
+  meta_data.setAttributeNS(android_ns, 'android:name', TEST_SUITES_META_DATA)
+  meta_data.setAttributeNS(android_ns, 'android:value', test_suites)
+  application.insertBefore(doc.createTextNode(indent), last)
+  application.insertBefore(meta_data, last)
+  last = application.lastChild
+
+  # align the closing tag with the opening tag if it's not
+  # indented
+  if last and last.nodeType != minidom.Node.TEXT_NODE:
+    indent = get_indent(application.previousSibling, 1)
+    application.appendChild(doc.createTextNode(indent))
+
+
+APP_RESTRICTIONS_META_DATA = 'android.content.APP_RESTRICTIONS'
+
+
+def add_app_restrictions(doc, resource):
+  """Add an android.content.APP_RESTRICTIONS <meta-data> tag referring to resource.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    resource: The @xml/... reference of the restrictions of the app.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  application = get_or_create_application(doc)
+
+  if find_child_with_attribute(application, 'meta-data', android_ns, 'name',
+                               APP_RESTRICTIONS_META_DATA) is not None:
+    return
+
+  indent = get_indent(application.firstChild, 2)
+
+  last = application.lastChild
+  if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+    last = None
+
+  meta_data = doc.createElement('meta-data')
+  meta_data.setAttributeNS(android_ns, 'android:name', APP_RESTRICTIONS_META_DATA)
+  meta_data.setAttributeNS(android_ns, 'android:resource', resource)
+  application.insertBefore(doc.createTextNode(indent), last)
+  application.insertBefore(meta_data, last)
+  last = application.lastChild
+
+  # align the closing tag with the opening tag if it's not
+  # indented
+  if last and last.nodeType != minidom.Node.TEXT_NODE:
+    indent = get_indent(application.previousSibling, 1)
+    application.appendChild(doc.createTextNode(indent))
+
+
 def add_logging_parent(doc, logging_parent_value):
   """Add logging parent as an additional <meta-data> tag.
 
@@ -180,6 +927,199 @@ def add_logging_parent(doc, logging_parent_value):
     application.appendChild(doc.createTextNode(indent))
 
 
+def export_launcher_activities(doc):
+  """Set android:exported="true" on launcher activities that don't declare android:exported.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  for activity in find_launcher_activities_without_exported(doc):
+    activity.setAttributeNS(android_ns, 'android:exported', 'true')
+
+
+def export_broadcast_receivers(doc, protected_broadcasts):
+  """Set android:exported="true" on receivers for implicit broadcasts that don't declare it.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    protected_broadcasts: The broadcasts that only the system can send.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  for receiver in find_receivers_without_exported(doc, protected_broadcasts):
+    receiver.setAttributeNS(android_ns, 'android:exported', 'true')
+
+
+def clear_task_affinity(doc, exported_only):
+  """Set android:taskAffinity="" on activities.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    exported_only: Whether to only set it on the activities that can be started by other apps.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  for application in get_children_with_tag(manifest, 'application'):
+    for activity in get_children_with_tag(application, 'activity'):
+      if exported_only and not is_exported(activity):
+        continue
+      activity.setAttributeNS(android_ns, 'android:taskAffinity', '')
+
+
+def add_attribution(doc, tag, label):
+  """Add an <attribution> tag to the manifest, replacing one with the same tag.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    tag: The android:tag of the attribution.
+    label: The android:label of the attribution.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+
+  attribution = find_child_with_attribute(manifest, 'attribution', android_ns, 'tag', tag)
+  if attribution is not None:
+    attribution.setAttributeNS(android_ns, 'android:label', label)
+    return
+
+  attribution = doc.createElement('attribution')
+  attribution.setAttributeNS(android_ns, 'android:tag', tag)
+  attribution.setAttributeNS(android_ns, 'android:label', label)
+
+  # Attributions are declared before <application>, or at the end of the manifest if it has none.
+  elems = get_children_with_tag(manifest, 'application')
+  if elems:
+    indent = get_indent(elems[0].previousSibling, 1)
+    manifest.insertBefore(attribution, elems[0])
+    manifest.insertBefore(doc.createTextNode(indent), elems[0])
+    return
+
+  last = manifest.lastChild
+  if last is None or last.nodeType != minidom.Node.TEXT_NODE:
+    last = doc.createTextNode('\n')
+    manifest.appendChild(last)
+  manifest.insertBefore(doc.createTextNode(get_indent(None, 1)), last)
+  manifest.insertBefore(attribution, last)
+
+
+def add_compatible_screens(doc, screens):
+  """Add <screen> tags to the <compatible-screens> tag of the manifest, creating it if needed.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    screens: A list of (screenSize, screenDensity) tuples.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+
+  elems = get_children_with_tag(manifest, 'compatible-screens')
+  if elems:
+    compatible_screens = elems[0]
+  else:
+    compatible_screens = doc.createElement('compatible-screens')
+    # <compatible-screens> is declared before <application>, or at the end of the manifest if it
+    # has none.
+    elems = get_children_with_tag(manifest, 'application')
+    if elems:
+      indent = get_indent(elems[0].previousSibling, 1)
+      manifest.insertBefore(compatible_screens, elems[0])
+      manifest.insertBefore(doc.createTextNode(indent), elems[0])
+    else:
+      last = manifest.lastChild
+      if last is None or last.nodeType != minidom.Node.TEXT_NODE:
+        last = doc.createTextNode('\n')
+        manifest.appendChild(last)
+      manifest.insertBefore(doc.createTextNode(get_indent(None, 1)), last)
+      manifest.insertBefore(compatible_screens, last)
+
+  for size, density in screens:
+    existing = get_children_with_tag(compatible_screens, 'screen')
+    if any(s.getAttributeNS(android_ns, 'screenSize') == size and
+           s.getAttributeNS(android_ns, 'screenDensity') == density for s in existing):
+      continue
+
+    screen = doc.createElement('screen')
+    screen.setAttributeNS(android_ns, 'android:screenSize', size)
+    screen.setAttributeNS(android_ns, 'android:screenDensity', density)
+
+    last = compatible_screens.lastChild
+    if last is None or last.nodeType != minidom.Node.TEXT_NODE:
+      last = doc.createTextNode(get_indent(None, 1))
+      compatible_screens.appendChild(last)
+    compatible_screens.insertBefore(doc.createTextNode(get_indent(None, 2)), last)
+    compatible_screens.insertBefore(screen, last)
+
+
+def set_uses_permission_flags(doc, permission, flags, add_missing):
+  """Set android:usesPermissionFlags on the <uses-permission> tags requesting a permission.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    permission: The name of the permission.
+    flags: The value of the android:usesPermissionFlags attribute, e.g. neverForLocation.
+    add_missing: Whether to add a <uses-permission> tag if the permission is not requested.
+  Raises:
+    RuntimeError: Invalid manifest or the permission is not requested
+  """
+  manifest = parse_manifest(doc)
+  found = False
+  for elem in get_children_with_tag(manifest, 'uses-permission'):
+    if elem.getAttributeNS(android_ns, 'name') == permission:
+      elem.setAttributeNS(android_ns, 'android:usesPermissionFlags', flags)
+      found = True
+  if found:
+    return
+  if not add_missing:
+    raise RuntimeError('<uses-permission> %s not found in manifest' % permission)
+
+  elem = doc.createElement('uses-permission')
+  elem.setAttributeNS(android_ns, 'android:name', permission)
+  elem.setAttributeNS(android_ns, 'android:usesPermissionFlags', flags)
+  indent = get_indent(manifest.firstChild, 1)
+  first = manifest.firstChild
+  manifest.insertBefore(doc.createTextNode(indent), first)
+  manifest.insertBefore(elem, first)
+
+
+def modify_uses_permission(doc, permission, max_sdk_version, flags):
+  """Set android:maxSdkVersion and android:usesPermissionFlags on the <uses-permission> tags
+  requesting a permission.
+
+  Both attributes are validated before either is set, so that a tag is never left with only one
+  of them modified.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    permission: The name of the permission.
+    max_sdk_version: The value of the android:maxSdkVersion attribute, or '' to keep it as is.
+    flags: The value of the android:usesPermissionFlags attribute, or '' to keep it as is.
+  Raises:
+    RuntimeError: Invalid manifest, invalid value or the permission is not requested
+  """
+  if max_sdk_version and not max_sdk_version.isdigit():
+    raise RuntimeError('invalid maxSdkVersion "%s" for <uses-permission> %s' %
+                       (max_sdk_version, permission))
+  if not max_sdk_version and not flags:
+    raise RuntimeError('nothing to modify for <uses-permission> %s' % permission)
+
+  manifest = parse_manifest(doc)
+  elems = [elem for elem in get_children_with_tag(manifest, 'uses-permission')
+           if elem.getAttributeNS(android_ns, 'name') == permission]
+  if not elems:
+    raise RuntimeError('<uses-permission> %s not found in manifest' % permission)
+
+  for elem in elems:
+    if max_sdk_version:
+      elem.setAttributeNS(android_ns, 'android:maxSdkVersion', max_sdk_version)
+    if flags:
+      elem.setAttributeNS(android_ns, 'android:usesPermissionFlags', flags)
+
+
 def add_uses_libraries(doc, new_uses_libraries, required):
   """Add additional <uses-library> tags
 
@@ -230,6 +1170,51 @@ def add_uses_libraries(doc, new_uses_libraries, required):
     application.appendChild(doc.createTextNode(indent))
 
 
+def add_uses_sdk_library(doc, name, version_major, cert_digest):
+  """Add a <uses-sdk-library> tag to the application, replacing one with the same name.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    name: The android:name of the SDK library.
+    version_major: The android:versionMajor of the SDK library.
+    cert_digest: The android:certDigest of the SDK library.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  elems = get_children_with_tag(manifest, 'application')
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+  elif not elems:
+    application = doc.createElement('application')
+    indent = get_indent(manifest.firstChild, 1)
+    first = manifest.firstChild
+    manifest.insertBefore(doc.createTextNode(indent), first)
+    manifest.insertBefore(application, first)
+  else:
+    application = elems[0]
+
+  library = find_child_with_attribute(application, 'uses-sdk-library', android_ns, 'name', name)
+  if library is None:
+    library = doc.createElement('uses-sdk-library')
+    library.setAttributeNS(android_ns, 'android:name', name)
+
+    indent = get_indent(application.firstChild, 2)
+    last = application.lastChild
+    if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+      last = None
+    application.insertBefore(doc.createTextNode(indent), last)
+    application.insertBefore(library, last)
+
+    # align the closing tag with the opening tag if it's not indented
+    if application.lastChild.nodeType != minidom.Node.TEXT_NODE:
+      indent = get_indent(application.previousSibling, 1)
+      application.appendChild(doc.createTextNode(indent))
+
+  library.setAttributeNS(android_ns, 'android:versionMajor', version_major)
+  library.setAttributeNS(android_ns, 'android:certDigest', cert_digest)
+
+
 def add_uses_non_sdk_api(doc):
   """Add android:usesNonSdkApi=true attribute to <application>.
 
@@ -377,15 +1362,110 @@ def override_placeholder_version(doc, new_version):
   if (version == '0'):
     manifest.setAttribute("android:versionCode", new_version)
 
+def remove_meta_data(doc, names):
+  """Remove the named <meta-data> tags from <application>.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    names: The android:name values of the <meta-data> tags to remove.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  elems = get_children_with_tag(manifest, 'application')
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+  elif not elems:
+    return
+
+  application = elems[0]
+  for name in names:
+    meta_data = find_child_with_attribute(application, 'meta-data', android_ns,
+                                          'name', name)
+    while meta_data is not None:
+      # Remove the indent in front of the tag along with the tag itself.
+      prev = meta_data.previousSibling
+      if (prev is not None and prev.nodeType == minidom.Node.TEXT_NODE and
+          not prev.data.strip()):
+        application.removeChild(prev)
+      application.removeChild(meta_data)
+      meta_data = find_child_with_attribute(application, 'meta-data', android_ns,
+                                            'name', name)
+
+def strip_overlay(doc):
+  """Remove the <overlay> tag and the package from the manifest of a runtime resource overlay.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+  for overlay in get_children_with_tag(manifest, 'overlay'):
+    # Remove the indent in front of the tag along with the tag itself.
+    prev = overlay.previousSibling
+    if (prev is not None and prev.nodeType == minidom.Node.TEXT_NODE and
+        not prev.data.strip()):
+      manifest.removeChild(prev)
+    manifest.removeChild(overlay)
+
+  package = manifest.getAttribute('package')
+  if not package:
+    return
+
+  for application in get_children_with_tag(manifest, 'application'):
+    elems = [application]
+    for tag in COMPONENT_TAGS:
+      elems += get_children_with_tag(application, tag)
+    for elem in elems:
+      for name in ['name', 'targetActivity']:
+        value = elem.getAttributeNS(android_ns, name)
+        if value:
+          elem.setAttributeNS(android_ns, 'android:' + name, resolve_class_name(package, value))
+  manifest.removeAttribute('package')
+
+
+def report_warnings(input_path, warnings, warnings_output):
+  """Report the warnings about a manifest.
+
+  Args:
+    input_path: The path of the manifest the warnings are about.
+    warnings: The list of warning messages.
+    warnings_output: The path of a file to write the warnings to, one per line, or None to print
+      them to stderr.
+  """
+  if warnings_output:
+    with open(warnings_output, 'w') as f:
+      for warning in warnings:
+        f.write(warning + '\n')
+    return
+
+  for warning in warnings:
+    print('warning: %s: %s' % (input_path, warning), file=sys.stderr)
+
+
 def main():
   """Program entry point."""
   try:
     args = parse_args()
 
     doc = minidom.parse(args.input)
+    warnings = []
+
+    if is_final(doc):
+      # The manifest has already been fixed by a previous build, e.g. before it was captured
+      # in a prebuilt.  Injecting the values derived by the build system again could change them,
+      # but the other fixes, e.g. those applied to the merged manifest, still apply.
+      for dest in BUILD_INJECTED_ARGS:
+        setattr(args, dest, None)
 
     ensure_manifest_android_ns(doc)
 
+    if args.warn_min_sdk_version_mismatch:
+      warning = check_min_sdk_version(doc, args.min_sdk_version)
+      if warning:
+        warnings.append(warning)
+
     if args.raise_min_sdk_version:
       raise_min_sdk_version(doc, args.min_sdk_version, args.target_sdk_version, args.library)
 
@@ -398,12 +1478,23 @@ def main():
     if args.optional_uses_libraries:
       add_uses_libraries(doc, args.optional_uses_libraries, False)
 
+    if args.warn_missing_uses_libraries:
+      warning = check_missing_uses_libraries(doc, args.warn_missing_uses_libraries.split(','))
+      if warning:
+        warnings.append(warning)
+
     if args.uses_non_sdk_api:
       add_uses_non_sdk_api(doc)
 
     if args.logging_parent:
       add_logging_parent(doc, args.logging_parent)
 
+    if args.test_suites_meta_data:
+      add_test_suites_meta_data(doc, args.test_suites_meta_data)
+
+    if args.app_restrictions:
+      add_app_restrictions(doc, args.app_restrictions)
+
     if args.use_embedded_dex:
       add_use_embedded_dex(doc)
 
@@ -419,9 +1510,252 @@ def main():
     if args.new_version:
       override_placeholder_version(doc, args.new_version)
 
+    if args.version_code:
+      if not args.version_code.isdigit():
+        raise RuntimeError('invalid versionCode "%s"' % args.version_code)
+      set_manifest_attribute(doc, 'versionCode', args.version_code)
+    elif args.version_code_file:
+      set_manifest_attribute(doc, 'versionCode', read_version_code_file(args.version_code_file))
+
+    if args.version_name:
+      set_manifest_attribute(doc, 'versionName', args.version_name)
+
+    if args.install_location:
+      set_manifest_attribute(doc, 'installLocation', args.install_location)
+
+    if args.is_split_required is not None:
+      set_manifest_attribute(doc, 'isSplitRequired', str(args.is_split_required).lower())
+
+    if args.shared_user_max_sdk_version:
+      set_shared_user_max_sdk_version(doc, args.shared_user_max_sdk_version)
+
+    if args.shared_user_label:
+      set_shared_user_label(doc, args.shared_user_label)
+
+    if args.allow_backup is not None:
+      set_application_attribute(doc, 'allowBackup', str(args.allow_backup).lower())
+
+    if args.hardware_accelerated is not None:
+      set_application_attribute(doc, 'hardwareAccelerated',
+                                str(args.hardware_accelerated).lower())
+
+    if args.direct_boot_aware is not None:
+      set_application_attribute(doc, 'directBootAware', str(args.direct_boot_aware).lower())
+
+    if args.allow_clear_user_data is not None:
+      set_application_attribute(doc, 'allowClearUserData',
+                                str(args.allow_clear_user_data).lower())
+
+    if args.persistent is not None:
+      set_application_attribute(doc, 'persistent', str(args.persistent).lower())
+
+    if args.multi_arch is not None:
+      set_application_attribute(doc, 'multiArch', str(args.multi_arch).lower())
+
+    if args.kill_after_restore is not None:
+      set_application_attribute(doc, 'killAfterRestore', str(args.kill_after_restore).lower())
+
+    if args.ui_options:
+      set_application_attribute(doc, 'uiOptions', args.ui_options)
+
+    if args.restore_any_version is not None:
+      set_application_attribute(doc, 'restoreAnyVersion',
+                                str(args.restore_any_version).lower())
+
+    if args.cross_profile is not None:
+      set_application_attribute(doc, 'crossProfile', str(args.cross_profile).lower())
+
+    if args.allow_native_heap_pointer_tagging is not None:
+      set_application_attribute(doc, 'allowNativeHeapPointerTagging',
+                                str(args.allow_native_heap_pointer_tagging).lower())
+
+    if args.large_heap is not None:
+      set_application_attribute(doc, 'largeHeap', str(args.large_heap).lower())
+
+    if args.resizeable_activity is not None:
+      set_application_attribute(doc, 'resizeableActivity', str(args.resizeable_activity).lower())
+
+    if args.application_attributes:
+      for entry in args.application_attributes:
+        name, value = entry.split('=', 1)
+        set_application_attribute(doc, name, value)
+
+    if args.attributions_are_user_visible is not None:
+      set_application_attribute(doc, 'attributionsAreUserVisible',
+                                str(args.attributions_are_user_visible).lower())
+
+    if args.attributions:
+      for entry in args.attributions:
+        tag, label = entry.split('=', 1)
+        add_attribution(doc, tag, label)
+
+    if args.compatible_screens:
+      add_compatible_screens(doc, [tuple(entry.split(':', 1)) for entry in args.compatible_screens])
+
+    if args.uses_sdk_libraries:
+      for entry in args.uses_sdk_libraries:
+        name, values = entry.split('=', 1)
+        version_major, cert_digest = values.split(':', 1)
+        add_uses_sdk_library(doc, name, version_major, cert_digest)
+
+    if args.request_raw_external_storage_access is not None:
+      set_application_attribute(doc, 'requestRawExternalStorageAccess',
+                                str(args.request_raw_external_storage_access).lower())
+      warning = check_attribute_target_sdk_version(doc, 'requestRawExternalStorageAccess', '30')
+      if warning:
+        warnings.append(warning)
+
+    if args.preserve_legacy_external_storage is not None:
+      set_application_attribute(doc, 'preserveLegacyExternalStorage',
+                                str(args.preserve_legacy_external_storage).lower())
+      warning = check_attribute_target_sdk_version(doc, 'preserveLegacyExternalStorage', '30')
+      if warning:
+        warnings.append(warning)
+
+    if args.activity_hardware_accelerated:
+      for entry in args.activity_hardware_accelerated:
+        activity, value = entry.split('=', 1)
+        set_component_attribute(doc, ['activity'], activity, 'hardwareAccelerated', value)
+
+    if args.component_direct_boot_aware:
+      for entry in args.component_direct_boot_aware:
+        component, value = entry.split('=', 1)
+        set_component_attribute(doc, COMPONENT_TAGS, component, 'directBootAware', value)
+
+    if args.component_label:
+      for entry in args.component_label:
+        component, value = entry.split('=', 1)
+        set_component_attribute(doc, COMPONENT_TAGS, component, 'label', value)
+
+    if args.component_description:
+      for entry in args.component_description:
+        component, value = entry.split('=', 1)
+        set_component_attribute(doc, COMPONENT_TAGS, component, 'description', value)
+
+    if args.activity_on_back_invoked_callback:
+      for entry in args.activity_on_back_invoked_callback:
+        activity, value = entry.split('=', 1)
+        set_component_attribute(doc, ['activity'], activity, 'enableOnBackInvokedCallback', value)
+
+    if args.activity_resizeable_activity:
+      for entry in args.activity_resizeable_activity:
+        activity, value = entry.split('=', 1)
+        set_component_attribute(doc, ['activity'], activity, 'resizeableActivity', value)
+
+    if args.resizeable_activity is not None or args.activity_resizeable_activity:
+      warning = check_attribute_target_sdk_version(doc, 'resizeableActivity', '24')
+      if warning:
+        warnings.append(warning)
+
+    if args.activity_ui_options:
+      for entry in args.activity_ui_options:
+        activity, value = entry.split('=', 1)
+        set_component_attribute(doc, ['activity'], activity, 'uiOptions', value)
+
+    if args.activity_theme:
+      for entry in args.activity_theme:
+        activity, value = entry.split('=', 1)
+        set_component_attribute(doc, ['activity'], activity, 'theme', value)
+
+    if args.activity_window_attributes:
+      for entry in args.activity_window_attributes:
+        activity, attr, value = entry.split('=', 2)
+        set_component_attribute(doc, ['activity'], activity, attr, value)
+
+    if args.uses_permission_flags:
+      for entry in args.uses_permission_flags:
+        permission, flags = entry.split('=', 1)
+        set_uses_permission_flags(doc, permission, flags, args.add_missing_uses_permissions)
+
+    if args.uses_permission_modifications:
+      for entry in args.uses_permission_modifications:
+        permission, values = entry.split('=', 1)
+        max_sdk_version, flags = values.split(':', 1)
+        modify_uses_permission(doc, permission, max_sdk_version, flags)
+
+    if args.export_launcher_activities:
+      export_launcher_activities(doc)
+
+    if args.export_broadcast_receivers:
+      export_broadcast_receivers(doc, args.protected_broadcasts)
+
+    if args.clear_task_affinity:
+      clear_task_affinity(doc, args.clear_task_affinity == 'exported')
+
+    if args.grant_uri_permissions:
+      for entry in args.grant_uri_permissions:
+        provider, grant = entry.split('=', 1)
+        attr, value = grant.split(':', 1)
+        add_grant_uri_permission(doc, provider, attr, value)
+
+    if args.disabled_components:
+      for component in args.disabled_components:
+        set_component_attribute(doc, COMPONENT_TAGS, component, 'enabled', 'false')
+
+    if args.gwp_asan_mode:
+      set_application_attribute(doc, 'gwpAsanMode', args.gwp_asan_mode)
+
+    if args.backup_agent:
+      set_application_attribute(doc, 'backupAgent', args.backup_agent)
+
+    if args.zygote_preload_name:
+      set_application_attribute(doc, 'zygotePreloadName', args.zygote_preload_name)
+
+    if args.app_component_factory:
+      set_application_attribute(doc, 'appComponentFactory', args.app_component_factory)
+
+    if args.full_backup_content:
+      set_application_attribute(doc, 'fullBackupContent', args.full_backup_content)
+
+    if args.known_activity_embedding_certs:
+      set_application_attribute(doc, 'knownActivityEmbeddingCerts',
+                                args.known_activity_embedding_certs)
+
+    if args.locale_config:
+      set_application_attribute(doc, 'localeConfig', args.locale_config)
+
+    if args.default_theme:
+      set_application_attribute(doc, 'theme', args.default_theme, args.override_theme)
+
+    if args.required_split_types:
+      set_manifest_attribute(doc, 'requiredSplitTypes', args.required_split_types)
+
+    if args.split_types:
+      set_manifest_attribute(doc, 'splitTypes', args.split_types)
+
+    if args.application_properties:
+      for entry in args.application_properties:
+        name, value = entry.split('=', 1)
+        add_application_property(doc, name, 'value', value)
+
+    if args.application_resource_properties:
+      for entry in args.application_resource_properties:
+        name, value = entry.split('=', 1)
+        add_application_property(doc, name, 'resource', value)
+
+    if args.remove_meta_data:
+      remove_meta_data(doc, args.remove_meta_data)
+
+    if args.strip_overlay:
+      strip_overlay(doc)
+
+    if args.remove_compile_sdk_version:
+      remove_manifest_attributes(doc, ['compileSdkVersion', 'compileSdkVersionCodename'])
+
+    if args.canonicalize_namespaces:
+      canonicalize_namespaces(doc)
+
+    if args.strip_tools_attributes:
+      strip_tools_attributes(doc)
+
+    if args.mark_final:
+      mark_final(doc)
+
     with open(args.output, 'w') as f:
       write_xml(f, doc)
 
+    report_warnings(args.input, warnings, args.warnings_output)
+
   # pylint: disable=broad-except
   except Exception as err:
     print('error: ' + str(err), file=sys.stderr)
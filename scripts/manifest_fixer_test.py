@@ -17,7 +17,9 @@
 """Unit tests for manifest_fixer.py."""
 
 import io
+import os
 import sys
+import tempfile
 import unittest
 from xml.dom import minidom
 import xml.etree.ElementTree as ET
@@ -51,6 +53,159 @@ class CompareVersionGtTest(unittest.TestCase):
     self.assertTrue(manifest_fixer.compare_version_gt('18', '8'))
 
 
+class CheckMinSdkVersionTest(unittest.TestCase):
+  """Unit tests for check_min_sdk_version function."""
+
+  def run_test(self, input_manifest, min_sdk_version):
+    doc = minidom.parseString(input_manifest)
+    return manifest_fixer.check_min_sdk_version(doc, min_sdk_version)
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def test_no_uses_sdk(self):
+    self.assertIsNone(self.run_test(self.manifest_tmpl % '', '28'))
+
+  def test_same_min(self):
+    manifest_input = self.manifest_tmpl % '    <uses-sdk android:minSdkVersion="28"/>\n'
+    self.assertIsNone(self.run_test(manifest_input, '28'))
+
+  def test_lower_min(self):
+    """Tests that a lower minSdkVersion in the manifest is reported."""
+    manifest_input = self.manifest_tmpl % '    <uses-sdk android:minSdkVersion="21"/>\n'
+    warning = self.run_test(manifest_input, '28')
+    self.assertIn('minSdkVersion="21"', warning)
+    self.assertIn('"28"', warning)
+
+
+class CheckMissingUsesLibrariesTest(unittest.TestCase):
+  """Unit tests for check_missing_uses_libraries function."""
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    return manifest_fixer.check_missing_uses_libraries(doc, ['android.test.base', 'foo'])
+
+  def test_missing(self):
+    self.assertEqual(self.run_test(self.manifest_tmpl % ''),
+                     'the class loader context contains android.test.base, foo but the manifest '
+                     'has no <uses-library> tags, the uses_libs of the module may be misconfigured')
+
+  def test_declared(self):
+    manifest_input = self.manifest_tmpl % '        <uses-library android:name="foo"/>\n'
+    self.assertIsNone(self.run_test(manifest_input))
+
+
+class ReportWarningsTest(unittest.TestCase):
+  """Unit tests for report_warnings function."""
+
+  warnings = ['minSdkVersion mismatch', 'no <uses-library> tags']
+
+  def test_file(self):
+    with tempfile.TemporaryDirectory() as tmpdir:
+      path = os.path.join(tmpdir, 'warnings.txt')
+      manifest_fixer.report_warnings('AndroidManifest.xml', self.warnings, path)
+      with open(path) as f:
+        self.assertEqual(f.read(), 'minSdkVersion mismatch\nno <uses-library> tags\n')
+
+  def test_no_warnings_file(self):
+    with tempfile.TemporaryDirectory() as tmpdir:
+      path = os.path.join(tmpdir, 'warnings.txt')
+      manifest_fixer.report_warnings('AndroidManifest.xml', [], path)
+      with open(path) as f:
+        self.assertEqual(f.read(), '')
+
+  def test_stderr(self):
+    stderr = io.StringIO()
+    old_stderr, sys.stderr = sys.stderr, stderr
+    try:
+      manifest_fixer.report_warnings('AndroidManifest.xml', self.warnings, None)
+    finally:
+      sys.stderr = old_stderr
+    self.assertEqual(stderr.getvalue(),
+                     'warning: AndroidManifest.xml: minSdkVersion mismatch\n'
+                     'warning: AndroidManifest.xml: no <uses-library> tags\n')
+
+  def test_post_merge(self):
+    """Tests the warnings of a post-merge run about an activity contributed by a library."""
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android"'
+        ' package="com.android.foo">\n'
+        '    <uses-sdk android:minSdkVersion="21" android:targetSdkVersion="23"/>\n'
+        '    <application>\n'
+        '        <activity android:name="com.android.lib.LibActivity"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    with tempfile.TemporaryDirectory() as tmpdir:
+      source = os.path.join(tmpdir, 'merged.xml')
+      with open(source, 'w') as f:
+        f.write(manifest_input)
+      warnings = os.path.join(tmpdir, 'warnings.txt')
+      old_argv = sys.argv
+      sys.argv = ['manifest_fixer.py', '--activity-resizeable-activity',
+                  'com.android.lib.LibActivity=false', '--warnings-output', warnings, source,
+                  os.path.join(tmpdir, 'fixed.xml')]
+      try:
+        manifest_fixer.main()
+      finally:
+        sys.argv = old_argv
+      with open(warnings) as f:
+        self.assertIn('resizeableActivity has no effect', f.read())
+
+
+class CheckAttributeTargetSdkVersionTest(unittest.TestCase):
+  """Unit tests for check_attribute_target_sdk_version function."""
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <uses-sdk android:minSdkVersion="28" android:targetSdkVersion="%s"/>\n'
+      '</manifest>\n')
+
+  def check(self, target_sdk_version):
+    doc = minidom.parseString(self.manifest_tmpl % target_sdk_version)
+    return manifest_fixer.check_attribute_target_sdk_version(
+        doc, 'requestRawExternalStorageAccess', '30')
+
+  def test_old_target(self):
+    self.assertEqual(self.check('29'),
+                     'requestRawExternalStorageAccess has no effect on apps with '
+                     'targetSdkVersion="29", it requires targetSdkVersion 30 or higher')
+
+  def test_new_target(self):
+    self.assertIsNone(self.check('30'))
+    self.assertIsNone(self.check('VanillaIceCream'))
+
+  def test_resizeable_activity(self):
+    doc = minidom.parseString(self.manifest_tmpl % '23')
+    self.assertEqual(
+        manifest_fixer.check_attribute_target_sdk_version(doc, 'resizeableActivity', '24'),
+        'resizeableActivity has no effect on apps with targetSdkVersion="23", it requires '
+        'targetSdkVersion 24 or higher')
+
+  def test_preserve_legacy_external_storage(self):
+    doc = minidom.parseString(self.manifest_tmpl % '29')
+    self.assertEqual(
+        manifest_fixer.check_attribute_target_sdk_version(
+            doc, 'preserveLegacyExternalStorage', '30'),
+        'preserveLegacyExternalStorage has no effect on apps with targetSdkVersion="29", it '
+        'requires targetSdkVersion 30 or higher')
+    doc = minidom.parseString(self.manifest_tmpl % '30')
+    self.assertIsNone(manifest_fixer.check_attribute_target_sdk_version(
+        doc, 'preserveLegacyExternalStorage', '30'))
+
+
 class RaiseMinSdkVersionTest(unittest.TestCase):
   """Unit tests for raise_min_sdk_version function."""
 
@@ -273,6 +428,87 @@ class AddLoggingParentTest(unittest.TestCase):
     self.assert_xml_equal(output, expected)
 
 
+
+class AddAppRestrictionsTest(unittest.TestCase):
+  """Unit tests for add_app_restrictions function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, resource):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_app_restrictions(doc, resource)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_add(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '</manifest>\n')
+    expected = self.manifest_tmpl % (
+        '        <meta-data android:name="android.content.APP_RESTRICTIONS" '
+        'android:resource="@xml/app_restrictions"/>\n')
+    output = self.run_test(manifest_input, '@xml/app_restrictions')
+    self.assert_xml_equal(output, expected)
+
+  def test_present(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <meta-data android:name="android.content.APP_RESTRICTIONS" '
+        'android:resource="@xml/restrictions"/>\n')
+    output = self.run_test(manifest_input, '@xml/app_restrictions')
+    self.assert_xml_equal(output, manifest_input)
+
+
+class AddTestSuitesMetaDataTest(unittest.TestCase):
+  """Unit tests for add_test_suites_meta_data function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, test_suites):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_test_suites_meta_data(doc, test_suites)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_add(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '</manifest>\n')
+    expected = self.manifest_tmpl % (
+        '        <meta-data android:name="android.testSuites" '
+        'android:value="general-tests,mts-foo"/>\n')
+    output = self.run_test(manifest_input, 'general-tests,mts-foo')
+    self.assert_xml_equal(output, expected)
+
+  def test_replace(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <meta-data android:name="android.testSuites" android:value="cts"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <meta-data android:name="android.testSuites" android:value="mts-foo"/>\n')
+    output = self.run_test(manifest_input, 'mts-foo')
+    self.assert_xml_equal(output, expected)
+
 class AddUsesLibrariesTest(unittest.TestCase):
   """Unit tests for add_uses_libraries function."""
 
@@ -677,5 +913,1066 @@ class OverrideDefaultVersionTest(unittest.TestCase):
     self.assert_xml_equal(output, expected)
 
 
+class RemoveMetaDataTest(unittest.TestCase):
+  """Unit tests for remove_meta_data function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, names):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.remove_meta_data(doc, names)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def meta_data(self, name):
+    return '        <meta-data android:name="%s" android:value="true"/>\n' % name
+
+  def test_remove_present(self):
+    """Tests removing a <meta-data> tag that is present."""
+    manifest_input = self.manifest_tmpl % (self.meta_data('foo') + self.meta_data('bar'))
+    expected = self.manifest_tmpl % self.meta_data('bar')
+    output = self.run_test(manifest_input, ['foo'])
+    self.assertEqual(output, expected)
+
+  def test_remove_absent(self):
+    """Tests that removing a <meta-data> tag that is absent is a no-op."""
+    manifest_input = self.manifest_tmpl % self.meta_data('bar')
+    output = self.run_test(manifest_input, ['foo'])
+    self.assert_xml_equal(output, manifest_input)
+
+  def test_remove_present_and_absent(self):
+    """Tests removing a present and an absent <meta-data> tag together."""
+    manifest_input = self.manifest_tmpl % (self.meta_data('foo') + self.meta_data('bar'))
+    expected = self.manifest_tmpl % self.meta_data('bar')
+    output = self.run_test(manifest_input, ['baz', 'foo'])
+    self.assert_xml_equal(output, expected)
+
+  def test_no_application(self):
+    """Tests that a manifest without <application> is left alone."""
+    manifest_input = ('<?xml version="1.0" encoding="utf-8"?>\n'
+                      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+                      '</manifest>\n')
+    output = self.run_test(manifest_input, ['foo'])
+    self.assert_xml_equal(output, manifest_input)
+
+
+class SetApplicationAttributeTest(unittest.TestCase):
+  """Unit tests for set_application_attribute function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, name, value, override=True):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_application_attribute(doc, name, value, override)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def test_no_application(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % '    <application android:allowBackup="false"/>\n'
+    output = self.run_test(manifest_input, 'allowBackup', 'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_forced_false(self):
+    """Tests that a value declared in the manifest is overridden."""
+    manifest_input = self.manifest_tmpl % '    <application android:allowBackup="true"/>\n'
+    expected = self.manifest_tmpl % '    <application android:allowBackup="false"/>\n'
+    output = self.run_test(manifest_input, 'allowBackup', 'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_backup_agent(self):
+    manifest_input = self.manifest_tmpl % '    <application android:backupAgent=".Old"/>\n'
+    expected = self.manifest_tmpl % '    <application android:backupAgent="com.foo.Backup"/>\n'
+    output = self.run_test(manifest_input, 'backupAgent', 'com.foo.Backup')
+    self.assert_xml_equal(output, expected)
+
+  def test_app_component_factory(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <application android:appComponentFactory="androidx.core.app.CoreComponentFactory"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <application android:appComponentFactory="com.foo.WrapperFactory"/>\n')
+    output = self.run_test(manifest_input, 'appComponentFactory', 'com.foo.WrapperFactory')
+    self.assert_xml_equal(output, expected)
+
+  def test_allowed_true(self):
+    manifest_input = self.manifest_tmpl % '    <application/>\n'
+    expected = self.manifest_tmpl % '    <application android:allowBackup="true"/>\n'
+    output = self.run_test(manifest_input, 'allowBackup', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_full_backup_content(self):
+    """Tests that dataExtractionRules is kept alongside fullBackupContent."""
+    manifest_input = self.manifest_tmpl % (
+        '    <application android:dataExtractionRules="@xml/extraction"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <application android:dataExtractionRules="@xml/extraction"'
+        ' android:fullBackupContent="@xml/backup_rules"/>\n')
+    output = self.run_test(manifest_input, 'fullBackupContent', '@xml/backup_rules')
+    self.assert_xml_equal(output, expected)
+
+  def test_locale_config(self):
+    manifest_input = self.manifest_tmpl % '    <application android:localeConfig="@xml/locales"/>\n'
+    expected = self.manifest_tmpl % (
+        '    <application android:localeConfig="@xml/soong_locale_config"/>\n')
+    output = self.run_test(manifest_input, 'localeConfig', '@xml/soong_locale_config')
+    self.assert_xml_equal(output, expected)
+
+  def test_restore_any_version(self):
+    manifest_input = self.manifest_tmpl % '    <application android:restoreAnyVersion="false"/>\n'
+    expected = self.manifest_tmpl % '    <application android:restoreAnyVersion="true"/>\n'
+    output = self.run_test(manifest_input, 'restoreAnyVersion', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_cross_profile(self):
+    manifest_input = self.manifest_tmpl % '    <application android:crossProfile="true"/>\n'
+    expected = self.manifest_tmpl % '    <application android:crossProfile="false"/>\n'
+    output = self.run_test(manifest_input, 'crossProfile', 'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_allow_native_heap_pointer_tagging(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <application android:allowNativeHeapPointerTagging="true"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <application android:allowNativeHeapPointerTagging="false"/>\n')
+    output = self.run_test(manifest_input, 'allowNativeHeapPointerTagging', 'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_large_heap(self):
+    manifest_input = self.manifest_tmpl % '    <application/>\n'
+    expected = self.manifest_tmpl % '    <application android:largeHeap="true"/>\n'
+    output = self.run_test(manifest_input, 'largeHeap', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_theme_absent(self):
+    manifest_input = self.manifest_tmpl % '    <application/>\n'
+    expected = self.manifest_tmpl % '    <application android:theme="@style/Brand"/>\n'
+    output = self.run_test(manifest_input, 'theme', '@style/Brand', False)
+    self.assert_xml_equal(output, expected)
+
+  def test_theme_not_overridden(self):
+    """Tests that a declared theme is kept when override is not requested."""
+    manifest_input = self.manifest_tmpl % '    <application android:theme="@style/App"/>\n'
+    output = self.run_test(manifest_input, 'theme', '@style/Brand', False)
+    self.assert_xml_equal(output, manifest_input)
+
+  def test_theme_overridden(self):
+    manifest_input = self.manifest_tmpl % '    <application android:theme="@style/App"/>\n'
+    expected = self.manifest_tmpl % '    <application android:theme="@style/Brand"/>\n'
+    output = self.run_test(manifest_input, 'theme', '@style/Brand', True)
+    self.assert_xml_equal(output, expected)
+
+
+class SetComponentAttributeTest(unittest.TestCase):
+  """Unit tests for set_component_attribute function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, tag, component, name, value):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_component_attribute(doc, tag, component, name, value)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.foo">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_relative_name(self):
+    """Tests that a relative name in the manifest matches a fully qualified component."""
+    manifest_input = self.manifest_tmpl % '        <activity android:name=".Main"/>\n'
+    expected = self.manifest_tmpl % (
+        '        <activity android:name=".Main" android:hardwareAccelerated="false"/>\n')
+    output = self.run_test(manifest_input, ['activity'], 'com.foo.Main', 'hardwareAccelerated',
+                           'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_override(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <activity android:name="com.bar.Main" android:hardwareAccelerated="false"/>\n'
+        '        <activity android:name=".Other"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <activity android:name="com.bar.Main" android:hardwareAccelerated="true"/>\n'
+        '        <activity android:name=".Other"/>\n')
+    output = self.run_test(manifest_input, ['activity'], 'com.bar.Main', 'hardwareAccelerated',
+                           'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_missing(self):
+    manifest_input = self.manifest_tmpl % '        <activity android:name=".Main"/>\n'
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, ['activity'], '.Other', 'hardwareAccelerated', 'true')
+
+  def test_direct_boot_aware_service(self):
+    manifest_input = self.manifest_tmpl % '        <service android:name=".Sync"/>\n'
+    expected = self.manifest_tmpl % (
+        '        <service android:name=".Sync" android:directBootAware="true"/>\n')
+    output = self.run_test(manifest_input, manifest_fixer.COMPONENT_TAGS, 'com.foo.Sync',
+                           'directBootAware', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_on_back_invoked_callback_missing(self):
+    manifest_input = self.manifest_tmpl % '        <activity android:name=".Main"/>\n'
+    with self.assertRaisesRegex(RuntimeError, 'com.foo.Settings not found'):
+      self.run_test(manifest_input, ['activity'], '.Settings', 'enableOnBackInvokedCallback',
+                    'true')
+
+  def test_theme_one_activity(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <activity android:name=".Main" android:theme="@style/Main"/>\n'
+        '        <activity android:name=".Other"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <activity android:name=".Main" android:theme="@style/Brand"/>\n'
+        '        <activity android:name=".Other"/>\n')
+    output = self.run_test(manifest_input, ['activity'], 'com.foo.Main', 'theme', '@style/Brand')
+    self.assert_xml_equal(output, expected)
+
+  def test_disable_service(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <activity android:name=".Main"/>\n'
+        '        <service android:name=".Sync"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <activity android:name=".Main"/>\n'
+        '        <service android:name=".Sync" android:enabled="false"/>\n')
+    output = self.run_test(manifest_input, manifest_fixer.COMPONENT_TAGS, 'com.foo.Sync',
+                           'enabled', 'false')
+    self.assert_xml_equal(output, expected)
+
+  def test_disable_missing(self):
+    manifest_input = self.manifest_tmpl % '        <activity android:name=".Main"/>\n'
+    with self.assertRaisesRegex(RuntimeError, 'com.foo.Sync not found'):
+      self.run_test(manifest_input, manifest_fixer.COMPONENT_TAGS, '.Sync', 'enabled', 'false')
+
+
+class ClearTaskAffinityTest(unittest.TestCase):
+  """Unit tests for clear_task_affinity function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, exported_only):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.clear_task_affinity(doc, exported_only)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '        <activity android:name=".Exported" android:exported="true"%s/>\n'
+      '        <activity android:name=".Private" android:exported="false"%s/>\n'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_exported(self):
+    manifest_input = self.manifest_tmpl % (' android:taskAffinity="com.bar"', '')
+    expected = self.manifest_tmpl % (' android:taskAffinity=""', '')
+    output = self.run_test(manifest_input, True)
+    self.assert_xml_equal(output, expected)
+    # Clearing it again doesn't change the manifest.
+    self.assert_xml_equal(self.run_test(output, True), expected)
+
+  def test_all(self):
+    manifest_input = self.manifest_tmpl % ('', '')
+    expected = self.manifest_tmpl % (' android:taskAffinity=""', ' android:taskAffinity=""')
+    output = self.run_test(manifest_input, False)
+    self.assert_xml_equal(output, expected)
+
+
+class ExportLauncherActivitiesTest(unittest.TestCase):
+  """Unit tests for export_launcher_activities function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.export_launcher_activities(doc)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '        <activity android:name=".Main"%s>\n'
+      '            <intent-filter>\n'
+      '                <action android:name="android.intent.action.MAIN"/>\n'
+      '                <category android:name="android.intent.category.LAUNCHER"/>\n'
+      '            </intent-filter>\n'
+      '        </activity>\n'
+      '        <activity android:name=".Other">\n'
+      '            <intent-filter>\n'
+      '                <action android:name="android.intent.action.VIEW"/>\n'
+      '            </intent-filter>\n'
+      '        </activity>\n'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_missing_exported(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % ' android:exported="true"'
+    self.assert_xml_equal(self.run_test(manifest_input), expected)
+
+  def test_explicit_exported(self):
+    """Tests that an explicit value is kept, even if it is false."""
+    manifest_input = self.manifest_tmpl % ' android:exported="false"'
+    self.assert_xml_equal(self.run_test(manifest_input), manifest_input)
+
+
+class ExportBroadcastReceiversTest(unittest.TestCase):
+  """Unit tests for export_broadcast_receivers function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, protected_broadcasts):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.export_broadcast_receivers(doc, protected_broadcasts)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '        <receiver android:name=".Refresh"%s>\n'
+      '            <intent-filter>\n'
+      '                <action android:name="com.android.foo.REFRESH"/>\n'
+      '            </intent-filter>\n'
+      '        </receiver>\n'
+      '        <receiver android:name=".Boot">\n'
+      '            <intent-filter>\n'
+      '                <action android:name="android.intent.action.BOOT_COMPLETED"/>\n'
+      '            </intent-filter>\n'
+      '        </receiver>\n'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_missing_exported(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % ' android:exported="true"'
+    self.assert_xml_equal(
+        self.run_test(manifest_input, ['android.intent.action.BOOT_COMPLETED']), expected)
+
+  def test_explicit_exported(self):
+    """Tests that an explicit value is kept, even if it is false."""
+    manifest_input = self.manifest_tmpl % ' android:exported="false"'
+    self.assert_xml_equal(
+        self.run_test(manifest_input, ['android.intent.action.BOOT_COMPLETED']), manifest_input)
+
+
+class AddUsesSdkLibraryTest(unittest.TestCase):
+  """Unit tests for add_uses_sdk_library function."""
+
+  def run_test(self, input_manifest, name, version_major, cert_digest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_uses_sdk_library(doc, name, version_major, cert_digest)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_add(self):
+    manifest_input = self.manifest_tmpl % '        <uses-library android:name="foo"/>\n'
+    expected = self.manifest_tmpl % (
+        '        <uses-library android:name="foo"/>\n'
+        '        <uses-sdk-library android:name="com.android.sdk.foo" android:versionMajor="2"'
+        ' android:certDigest="AB"/>\n')
+    output = self.run_test(manifest_input, 'com.android.sdk.foo', '2', 'AB')
+    self.assertEqual(output, expected)
+
+  def test_replace(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <uses-sdk-library android:name="com.android.sdk.foo" android:versionMajor="1"'
+        ' android:certDigest="CD"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <uses-sdk-library android:name="com.android.sdk.foo" android:versionMajor="2"'
+        ' android:certDigest="AB"/>\n')
+    output = self.run_test(manifest_input, 'com.android.sdk.foo', '2', 'AB')
+    self.assertEqual(output, expected)
+
+
+class AddAttributionTest(unittest.TestCase):
+  """Unit tests for add_attribution function."""
+
+  def run_test(self, input_manifest, attributions):
+    doc = minidom.parseString(input_manifest)
+    for tag, label in attributions:
+      manifest_fixer.add_attribution(doc, tag, label)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def test_before_application(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '    <application/>\n')
+    expected = self.manifest_tmpl % (
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '    <attribution android:tag="contacts" android:label="@string/contacts"/>\n'
+        '    <attribution android:tag="location" android:label="@string/location"/>\n'
+        '    <application/>\n')
+    output = self.run_test(manifest_input, [('contacts', '@string/contacts'),
+                                            ('location', '@string/location')])
+    self.assertEqual(output, expected)
+
+  def test_no_application(self):
+    manifest_input = self.manifest_tmpl % '    <uses-sdk android:minSdkVersion="29"/>\n'
+    expected = self.manifest_tmpl % (
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '    <attribution android:tag="contacts" android:label="@string/contacts"/>\n')
+    output = self.run_test(manifest_input, [('contacts', '@string/contacts')])
+    self.assertEqual(output, expected)
+
+  def test_replace(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <attribution android:tag="contacts" android:label="@string/old"/>\n'
+        '    <application/>\n')
+    expected = self.manifest_tmpl % (
+        '    <attribution android:tag="contacts" android:label="@string/contacts"/>\n'
+        '    <application/>\n')
+    output = self.run_test(manifest_input, [('contacts', '@string/contacts')])
+    self.assertEqual(output, expected)
+
+
+class AddCompatibleScreensTest(unittest.TestCase):
+  """Unit tests for add_compatible_screens function."""
+
+  def run_test(self, input_manifest, screens):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_compatible_screens(doc, screens)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def test_before_application(self):
+    manifest_input = self.manifest_tmpl % '    <application/>\n'
+    expected = self.manifest_tmpl % (
+        '    <compatible-screens>\n'
+        '        <screen android:screenSize="small" android:screenDensity="ldpi"/>\n'
+        '        <screen android:screenSize="normal" android:screenDensity="480"/>\n'
+        '    </compatible-screens>\n'
+        '    <application/>\n')
+    output = self.run_test(manifest_input, [('small', 'ldpi'), ('normal', '480')])
+    self.assertEqual(output, expected)
+
+  def test_existing(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <compatible-screens>\n'
+        '        <screen android:screenSize="small" android:screenDensity="ldpi"/>\n'
+        '    </compatible-screens>\n'
+        '    <application/>\n')
+    expected = self.manifest_tmpl % (
+        '    <compatible-screens>\n'
+        '        <screen android:screenSize="small" android:screenDensity="ldpi"/>\n'
+        '        <screen android:screenSize="large" android:screenDensity="xhdpi"/>\n'
+        '    </compatible-screens>\n'
+        '    <application/>\n')
+    output = self.run_test(manifest_input, [('small', 'ldpi'), ('large', 'xhdpi')])
+    self.assertEqual(output, expected)
+
+
+class SetUsesPermissionFlagsTest(unittest.TestCase):
+  """Unit tests for set_uses_permission_flags function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, permission, flags, add_missing=False):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_uses_permission_flags(doc, permission, flags, add_missing)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '    <application/>\n'
+      '</manifest>\n')
+
+  def test_present(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.BLUETOOTH_SCAN"/>\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.BLUETOOTH_SCAN"'
+        ' android:usesPermissionFlags="neverForLocation"/>\n'
+        '    <uses-permission android:name="android.permission.INTERNET"/>\n')
+    output = self.run_test(manifest_input, 'android.permission.BLUETOOTH_SCAN',
+                           'neverForLocation')
+    self.assert_xml_equal(output, expected)
+
+  def test_missing(self):
+    manifest_input = self.manifest_tmpl % ''
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, 'android.permission.BLUETOOTH_SCAN', 'neverForLocation')
+
+  def test_add_missing(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.BLUETOOTH_SCAN"'
+        ' android:usesPermissionFlags="neverForLocation"/>\n')
+    output = self.run_test(manifest_input, 'android.permission.BLUETOOTH_SCAN',
+                           'neverForLocation', add_missing=True)
+    self.assert_xml_equal(output, expected)
+
+
+class ModifyUsesPermissionTest(unittest.TestCase):
+  """Unit tests for modify_uses_permission function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, permission, max_sdk_version, flags):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.modify_uses_permission(doc, permission, max_sdk_version, flags)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '    <application/>\n'
+      '</manifest>\n')
+
+  def test_both(self):
+    manifest_input = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.WRITE_EXTERNAL_STORAGE"'
+        ' android:usesPermissionFlags="none"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.WRITE_EXTERNAL_STORAGE"'
+        ' android:usesPermissionFlags="neverForLocation" android:maxSdkVersion="32"/>\n')
+    output = self.run_test(manifest_input, 'android.permission.WRITE_EXTERNAL_STORAGE', '32',
+                           'neverForLocation')
+    self.assert_xml_equal(output, expected)
+
+  def test_max_sdk_version_only(self):
+    """Tests that usesPermissionFlags are kept when only maxSdkVersion is set."""
+    manifest_input = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.WRITE_EXTERNAL_STORAGE"'
+        ' android:usesPermissionFlags="neverForLocation" android:maxSdkVersion="28"/>\n')
+    expected = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.WRITE_EXTERNAL_STORAGE"'
+        ' android:usesPermissionFlags="neverForLocation" android:maxSdkVersion="32"/>\n')
+    output = self.run_test(manifest_input, 'android.permission.WRITE_EXTERNAL_STORAGE', '32', '')
+    self.assert_xml_equal(output, expected)
+
+  def test_missing(self):
+    manifest_input = self.manifest_tmpl % ''
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, 'android.permission.WRITE_EXTERNAL_STORAGE', '32', '')
+
+  def test_invalid_max_sdk_version(self):
+    """Tests that no attribute is set if maxSdkVersion is invalid."""
+    manifest_input = self.manifest_tmpl % (
+        '    <uses-permission android:name="android.permission.WRITE_EXTERNAL_STORAGE"/>\n')
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, 'android.permission.WRITE_EXTERNAL_STORAGE', 'S',
+                    'neverForLocation')
+
+
+class AddGrantUriPermissionTest(unittest.TestCase):
+  """Unit tests for add_grant_uri_permission function."""
+
+  def run_test(self, input_manifest, provider, attr, value):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_grant_uri_permission(doc, provider, attr, value)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.foo">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_path_prefix(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <provider android:name=".Files" android:authorities="com.foo.files"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <provider android:name=".Files" android:authorities="com.foo.files"'
+        ' android:grantUriPermissions="true">\n'
+        '            <grant-uri-permission android:pathPrefix="/shared/"/>\n'
+        '        </provider>\n')
+    output = self.run_test(manifest_input, 'com.foo.Files', 'pathPrefix', '/shared/')
+    self.assertEqual(output, expected)
+
+  def test_existing_grant(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <provider android:name=".Files" android:grantUriPermissions="true">\n'
+        '            <grant-uri-permission android:pathPrefix="/shared/"/>\n'
+        '        </provider>\n')
+    output = self.run_test(manifest_input, '.Files', 'pathPrefix', '/shared/')
+    self.assertEqual(output, manifest_input)
+
+  def test_missing(self):
+    manifest_input = self.manifest_tmpl % '        <provider android:name=".Files"/>\n'
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, '.Other', 'path', '/foo')
+
+
+class AddApplicationPropertyTest(unittest.TestCase):
+  """Unit tests for add_application_property function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, name, attr, value):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_application_property(doc, name, attr, value)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application>\n'
+      '%s'
+      '    </application>\n'
+      '</manifest>\n')
+
+  def test_value(self):
+    manifest_input = self.manifest_tmpl % (
+        '        <property android:name="android.bar" android:value="false"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <property android:name="android.bar" android:value="false"/>\n'
+        '        <property android:name="android.foo" android:value="true"/>\n')
+    output = self.run_test(manifest_input, 'android.foo', 'value', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_replace_with_resource(self):
+    """Tests that an existing property with the same name is replaced."""
+    manifest_input = self.manifest_tmpl % (
+        '        <property android:name="android.foo" android:value="true"/>\n')
+    expected = self.manifest_tmpl % (
+        '        <property android:name="android.foo" android:resource="@xml/foo"/>\n')
+    output = self.run_test(manifest_input, 'android.foo', 'resource', '@xml/foo')
+    self.assert_xml_equal(output, expected)
+
+
+class RemoveManifestAttributesTest(unittest.TestCase):
+  """Unit tests for remove_manifest_attributes function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, names):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.remove_manifest_attributes(doc, names)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android"%s>\n'
+      '</manifest>\n')
+
+  def test_compile_sdk_version(self):
+    manifest_input = self.manifest_tmpl % (
+        ' android:compileSdkVersion="34" android:compileSdkVersionCodename="14"'
+        ' android:versionCode="1"')
+    expected = self.manifest_tmpl % ' android:versionCode="1"'
+    output = self.run_test(manifest_input, ['compileSdkVersion', 'compileSdkVersionCodename'])
+    self.assert_xml_equal(output, expected)
+
+  def test_absent(self):
+    manifest_input = self.manifest_tmpl % ''
+    output = self.run_test(manifest_input, ['compileSdkVersion'])
+    self.assert_xml_equal(output, manifest_input)
+
+
+class SetSharedUserMaxSdkVersionTest(unittest.TestCase):
+  """Unit tests for set_shared_user_max_sdk_version function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, version):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_shared_user_max_sdk_version(doc, version)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android"%s>\n'
+      '</manifest>\n')
+
+  def test_shared_user_id(self):
+    manifest_input = self.manifest_tmpl % ' android:sharedUserId="android.uid.foo"'
+    expected = self.manifest_tmpl % (
+        ' android:sharedUserId="android.uid.foo" android:sharedUserMaxSdkVersion="32"')
+    output = self.run_test(manifest_input, '32')
+    self.assert_xml_equal(output, expected)
+
+  def test_no_shared_user_id(self):
+    manifest_input = self.manifest_tmpl % ''
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, '32')
+
+
+class SetSharedUserLabelTest(unittest.TestCase):
+  """Unit tests for set_shared_user_label function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, label):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_shared_user_label(doc, label)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android"%s>\n'
+      '</manifest>\n')
+
+  def test_shared_user_id(self):
+    manifest_input = self.manifest_tmpl % ' android:sharedUserId="android.uid.foo"'
+    expected = self.manifest_tmpl % (
+        ' android:sharedUserId="android.uid.foo" android:sharedUserLabel="@string/foo"')
+    output = self.run_test(manifest_input, '@string/foo')
+    self.assert_xml_equal(output, expected)
+
+  def test_no_shared_user_id(self):
+    manifest_input = self.manifest_tmpl % ''
+    with self.assertRaisesRegex(RuntimeError, 'requires android:sharedUserId'):
+      self.run_test(manifest_input, '@string/foo')
+
+
+class StripToolsAttributesTest(unittest.TestCase):
+  """Unit tests for strip_tools_attributes function."""
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.strip_tools_attributes(doc)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  def test_scattered(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android"'
+        ' xmlns:tools="http://schemas.android.com/tools" tools:ignore="GradleOverrides">\n'
+        '    <uses-sdk android:minSdkVersion="29" tools:overrideLibrary="com.bar"/>\n'
+        '    <application android:label="foo" tools:replace="android:label">\n'
+        '        <activity android:name=".Main" tools:node="merge">\n'
+        '            <meta-data android:name="foo" tools:node="remove"/>\n'
+        '        </activity>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    expected = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '    <application android:label="foo">\n'
+        '        <activity android:name=".Main">\n'
+        '            <meta-data android:name="foo"/>\n'
+        '        </activity>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    self.assertEqual(self.run_test(manifest_input), expected)
+
+
+class StripOverlayTest(unittest.TestCase):
+  """Unit tests for strip_overlay function."""
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.strip_overlay(doc)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  def test_other_target(self):
+    """Tests that an <overlay> targeting another app is removed."""
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '    <overlay android:targetPackage="com.other" android:isStatic="true"/>\n'
+        '    <application android:hasCode="false"/>\n'
+        '</manifest>\n')
+    expected = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '    <application android:hasCode="false"/>\n'
+        '</manifest>\n')
+    self.assertEqual(self.run_test(manifest_input), expected)
+
+  def test_other_package(self):
+    """Tests that the package is removed after resolving relative class names against it."""
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android"'
+        ' package="com.foo.overlay">\n'
+        '    <overlay android:targetPackage="com.foo"/>\n'
+        '    <application android:name=".App">\n'
+        '        <activity android:name=".Main"/>\n'
+        '        <activity-alias android:name="Alias" android:targetActivity=".Main"/>\n'
+        '        <service android:name="com.bar.Service"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    expected = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '    <application android:name="com.foo.overlay.App">\n'
+        '        <activity android:name="com.foo.overlay.Main"/>\n'
+        '        <activity-alias android:name="com.foo.overlay.Alias"'
+        ' android:targetActivity="com.foo.overlay.Main"/>\n'
+        '        <service android:name="com.bar.Service"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    self.assertEqual(self.run_test(manifest_input), expected)
+
+
+class CanonicalizeNamespacesTest(unittest.TestCase):
+  """Unit tests for canonicalize_namespaces function."""
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.canonicalize_namespaces(doc)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  def test_unusual_prefixes(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:a="http://schemas.android.com/apk/res/android" package="com.foo">\n'
+        '    <uses-sdk a:minSdkVersion="29"/>\n'
+        '    <application a:label="foo" xmlns:t="http://schemas.android.com/tools"'
+        ' t:replace="android:label">\n'
+        '        <activity a:name=".Main" android:exported="true"'
+        ' xmlns:android="http://schemas.android.com/apk/res/android"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    expected = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest package="com.foo" xmlns:android="http://schemas.android.com/apk/res/android"'
+        ' xmlns:tools="http://schemas.android.com/tools">\n'
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '    <application android:label="foo" tools:replace="android:label">\n'
+        '        <activity android:exported="true" android:name=".Main"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    self.assertEqual(self.run_test(manifest_input), expected)
+
+  def test_canonical(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.foo">\n'
+        '    <uses-sdk android:minSdkVersion="29"/>\n'
+        '</manifest>\n')
+    self.assertEqual(self.run_test(manifest_input), manifest_input)
+
+  def test_conflicting_prefix(self):
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android"'
+        ' xmlns:tools="http://example.com/tools"/>\n')
+    with self.assertRaisesRegex(RuntimeError, 'prefix tools is declared for namespace'):
+      self.run_test(manifest_input)
+
+
+class SetManifestAttributeTest(unittest.TestCase):
+  """Unit tests for set_manifest_attribute function."""
+
+  def assert_xml_equal(self, output, expected):
+    self.assertEqual(ET.canonicalize(output), ET.canonicalize(expected))
+
+  def run_test(self, input_manifest, name, value):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_manifest_attribute(doc, name, value)
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android"%s>\n'
+      '</manifest>\n')
+
+  def test_split_types(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % ' android:splitTypes="bar,foo"'
+    output = self.run_test(manifest_input, 'splitTypes', 'bar,foo')
+    self.assert_xml_equal(output, expected)
+
+  def test_version_code_overridden(self):
+    manifest_input = self.manifest_tmpl % ' android:versionCode="1"'
+    expected = self.manifest_tmpl % ' android:versionCode="42"'
+    output = self.run_test(manifest_input, 'versionCode', '42')
+    self.assert_xml_equal(output, expected)
+
+  def test_is_split_required_overridden(self):
+    manifest_input = self.manifest_tmpl % ' android:isSplitRequired="false"'
+    expected = self.manifest_tmpl % ' android:isSplitRequired="true"'
+    output = self.run_test(manifest_input, 'isSplitRequired', 'true')
+    self.assert_xml_equal(output, expected)
+
+  def test_required_split_types_overridden(self):
+    """Tests that a value declared in the manifest is overridden."""
+    manifest_input = self.manifest_tmpl % ' android:requiredSplitTypes="old"'
+    expected = self.manifest_tmpl % ' android:requiredSplitTypes="bar,foo"'
+    output = self.run_test(manifest_input, 'requiredSplitTypes', 'bar,foo')
+    self.assert_xml_equal(output, expected)
+
+
+class ReadVersionCodeFileTest(unittest.TestCase):
+  """Unit tests for read_version_code_file function."""
+
+  def read(self, contents):
+    with tempfile.TemporaryDirectory() as tmpdir:
+      path = os.path.join(tmpdir, 'version_code.txt')
+      with open(path, 'w') as f:
+        f.write(contents)
+      return manifest_fixer.read_version_code_file(path)
+
+  def test_version_code(self):
+    self.assertEqual(self.read('42\n'), '42')
+
+  def test_empty(self):
+    self.assertRaises(RuntimeError, self.read, '')
+
+  def test_whitespace(self):
+    self.assertRaises(RuntimeError, self.read, '42 43\n')
+
+  def test_not_numeric(self):
+    self.assertRaises(RuntimeError, self.read, '1.0')
+
+
+class MarkFinalTest(unittest.TestCase):
+  """Unit tests for mark_final and is_final functions."""
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <uses-sdk android:minSdkVersion="28" android:targetSdkVersion="28"/>\n'
+      '</manifest>\n')
+
+  def round_trip(self, doc):
+    output = io.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return minidom.parseString(output.getvalue())
+
+  def test_not_final(self):
+    doc = minidom.parseString(self.manifest_tmpl)
+    self.assertFalse(manifest_fixer.is_final(doc))
+
+  def test_round_trip(self):
+    """Tests that the final marker survives writing and re-reading the manifest."""
+    doc = minidom.parseString(self.manifest_tmpl)
+    manifest_fixer.mark_final(doc)
+    doc = self.round_trip(doc)
+    self.assertTrue(manifest_fixer.is_final(doc))
+
+  def test_mark_twice(self):
+    """Tests that marking a final manifest again doesn't add a second marker."""
+    doc = minidom.parseString(self.manifest_tmpl)
+    manifest_fixer.mark_final(doc)
+    doc = self.round_trip(doc)
+    manifest_fixer.mark_final(doc)
+    comments = [n for n in doc.childNodes if n.nodeType == minidom.Node.COMMENT_NODE]
+    self.assertEqual(len(comments), 1)
+
+  def run_main(self, input_path, output_path, *flags):
+    old_argv = sys.argv
+    sys.argv = ['manifest_fixer.py'] + list(flags) + [input_path, output_path]
+    try:
+      manifest_fixer.main()
+    finally:
+      sys.argv = old_argv
+    return minidom.parse(output_path)
+
+  def test_reimport(self):
+    """Tests that a final manifest fixed again keeps its SDK versions but gets the other fixes."""
+    manifest_input = (
+        '<?xml version="1.0" encoding="utf-8"?>\n'
+        '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+        '    <application>\n'
+        '        <meta-data android:name="foo" android:value="bar"/>\n'
+        '    </application>\n'
+        '</manifest>\n')
+    with tempfile.TemporaryDirectory() as tmpdir:
+      source = os.path.join(tmpdir, 'source.xml')
+      with open(source, 'w') as f:
+        f.write(manifest_input)
+      marked = os.path.join(tmpdir, 'marked.xml')
+      self.run_main(source, marked, '--minSdkVersion', '28', '--targetSdkVersion', '28',
+                    '--raise-min-sdk-version', '--mark-final')
+
+      # The marked manifest captured in a prebuilt is fixed again with other SDK versions.
+      refixed = os.path.join(tmpdir, 'refixed.xml')
+      doc = self.run_main(marked, refixed, '--minSdkVersion', '30', '--targetSdkVersion', '31',
+                          '--raise-min-sdk-version', '--remove-meta-data', 'foo')
+
+    self.assertTrue(manifest_fixer.is_final(doc))
+    uses_sdk = doc.getElementsByTagName('uses-sdk')
+    self.assertEqual(len(uses_sdk), 1)
+    self.assertEqual(uses_sdk[0].getAttributeNS(manifest_fixer.android_ns, 'minSdkVersion'), '28')
+    self.assertEqual(uses_sdk[0].getAttributeNS(manifest_fixer.android_ns, 'targetSdkVersion'), '28')
+    self.assertEqual(doc.getElementsByTagName('meta-data'), [])
+
+
 if __name__ == '__main__':
   unittest.main(verbosity=2)